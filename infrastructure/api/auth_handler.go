@@ -1,64 +1,214 @@
 package api
 
 import (
-	tracer "github.com/XWS-DISLINKT/dislinkt/tracer"
-	"github.com/opentracing/opentracing-go"
-	"github.com/prometheus/client_golang/prometheus"
+	"api-gateway/infrastructure/services"
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 )
 
+// CookieRewrite adjusts the cookies set by the auth service so the browser
+// scopes them to the gateway instead of the internal host.
+type CookieRewrite struct {
+	// Domain replaces the cookie domain; empty makes it a host-only cookie.
+	Domain string
+	// Path replaces the cookie path when set.
+	Path string
+	// Secure forces the Secure attribute on.
+	Secure bool
+}
+
+type AuthProxyConfig struct {
+	Cookies CookieRewrite
+	// Timeout bounds the whole exchange with the auth service.
+	Timeout time.Duration
+	Breaker *services.CircuitBreaker
+	// TLS switches the proxy to HTTPS when set.
+	TLS *tls.Config
+}
+
+// AuthHandler forwards the login, refresh and logout calls to the auth
+// service so browsers never talk to the internal host directly.
 type AuthHandler struct {
-	authClientAdress    string
-	profileClientAdress string
-	tracer              opentracing.Tracer
-	allRequests         prometheus.Counter
-	okRequests          prometheus.Counter
-	badRequests         prometheus.Counter
+	authClientAdress string
+	config           AuthProxyConfig
+	proxy            *httputil.ReverseProxy
+	revocations      services.RevocationStore
+	maxTokenLifetime time.Duration
+	tracer           opentracing.Tracer
 }
 
-func NewAuthHandler(authClientAdress string, profileClientAdress string, tracer opentracing.Tracer, allRequests prometheus.Counter, okRequests prometheus.Counter, badRequests prometheus.Counter) Handler {
-	return &AuthHandler{
-		authClientAdress:    authClientAdress,
-		profileClientAdress: profileClientAdress,
-		tracer:              tracer,
-		allRequests:         allRequests,
-		okRequests:          okRequests,
-		badRequests:         badRequests,
+func NewAuthHandler(authClientAdress string, config AuthProxyConfig, revocations services.RevocationStore, maxTokenLifetime time.Duration, tracer opentracing.Tracer) Handler {
+	handler := &AuthHandler{
+		authClientAdress: authClientAdress,
+		config:           config,
+		revocations:      revocations,
+		maxTokenLifetime: maxTokenLifetime,
+		tracer:           tracer,
+	}
+	handler.proxy = &httputil.ReverseProxy{
+		Director: handler.direct,
+		Transport: &http.Transport{
+			DialContext:           (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+			MaxIdleConnsPerHost:   16,
+			IdleConnTimeout:       90 * time.Second,
+			ResponseHeaderTimeout: config.Timeout,
+			TLSClientConfig:       config.TLS,
+			TLSHandshakeTimeout:   5 * time.Second,
+		},
+		// Flush every write so bodies stream instead of being buffered.
+		FlushInterval:  -1,
+		ModifyResponse: handler.rewriteCookies,
+		ErrorHandler:   handler.proxyError,
 	}
+	return handler
 }
 
 func (handler *AuthHandler) Init(mux *runtime.ServeMux) {
-	err := mux.HandlePath("POST", "/login", handler.Login)
-	err = mux.HandlePath("GET", "/refresh", handler.Refresh)
-	if err != nil {
-		panic(err)
+	registerRoutes(mux, handler.Routes())
+}
+
+func (handler *AuthHandler) Routes() []Route {
+	return []Route{
+		{Method: "POST", Pattern: "/login", Handler: handler.Login},
+		{Method: "GET", Pattern: "/refresh", Handler: handler.Refresh},
+		{Method: "POST", Pattern: "/logout", Handler: handler.Logout},
 	}
 }
 
 func (handler *AuthHandler) Login(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
+	handler.forward("LoginHandler", w, r)
+}
 
-	span := tracer.StartSpanFromRequest("LoginHandler", handler.tracer, r)
-	defer span.Finish()
+func (handler *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	handler.forward("RefreshHandler", w, r)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	handler.okRequests.Inc()
-	http.Redirect(w, r, "http://"+handler.authClientAdress+"/login", 307)
+// Logout revokes the caller's token at the gateway before the auth service
+// clears the session, so a copied token stops working right away.
+func (handler *AuthHandler) Logout(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	if principal, ok := services.PrincipalFromContext(r.Context()); ok {
+		if err := services.RevokeSession(r.Context(), handler.revocations, principal, handler.maxTokenLifetime); err != nil {
+			log.Printf("failed to revoke token of user %s: %v", principal.Id, err)
+			services.WriteStatus(w, r, codes.Unavailable, "could not revoke the session")
+			return
+		}
+	}
+	handler.forward("LogoutHandler", w, r)
 }
 
-func (handler *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
+func (handler *AuthHandler) forward(operation string, w http.ResponseWriter, r *http.Request) {
+	generation, err := handler.config.Breaker.Allow()
+	if err != nil {
+		services.WriteError(w, r, handler.config.Breaker.OpenError())
+		return
+	}
 
-	span := tracer.StartSpanFromRequest("RefreshHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, operation, r)
 	defer span.Finish()
+	ext.SpanKindRPCClient.Set(span)
+	ext.PeerService.Set(span, "auth_service")
+	services.AddBackend(r.Context(), "auth"+r.URL.Path)
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	// The OpenTelemetry span only records when tracingProvider is otel.
+	ctx, otelSpan := otel.Tracer("api-gateway").Start(r.Context(), operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.PeerServiceKey.String("auth_service")))
+	defer otelSpan.End()
+
+	ctx, cancel := context.WithTimeout(opentracing.ContextWithSpan(ctx, span), handler.config.Timeout)
+	defer cancel()
+
+	recorder := services.NewResponseRecorder(w)
+	handler.proxy.ServeHTTP(recorder, r.WithContext(ctx))
+	// Server errors and timeouts count against the auth service; a client
+	// that hung up proves nothing either way.
+	handler.config.Breaker.Done(generation, recorder.Status() < http.StatusInternalServerError || errors.Is(r.Context().Err(), context.Canceled))
+
+	ext.HTTPStatusCode.Set(span, uint16(recorder.Status()))
+	otelSpan.SetAttributes(semconv.HTTPStatusCodeKey.Int(recorder.Status()))
+	if recorder.Status() >= http.StatusBadRequest {
+		ext.Error.Set(span, true)
+	}
+	if recorder.Status() >= http.StatusInternalServerError {
+		otelSpan.SetStatus(otelcodes.Error, http.StatusText(recorder.Status()))
+	}
+}
+
+// direct points the outgoing request at the auth service and injects the
+// current span so the auth service continues the same trace. The caller's
+// own W3C headers are replaced, not forwarded.
+func (handler *AuthHandler) direct(r *http.Request) {
+	r.Header.Set("X-Forwarded-Host", r.Host)
+	r.URL.Scheme = "http"
+	if handler.config.TLS != nil {
+		r.URL.Scheme = "https"
+	}
+	r.URL.Host = handler.authClientAdress
+	r.Host = handler.authClientAdress
+	if span := opentracing.SpanFromContext(r.Context()); span != nil {
+		carrier := opentracing.HTTPHeadersCarrier(r.Header)
+		if err := handler.tracer.Inject(span.Context(), opentracing.HTTPHeaders, carrier); err != nil {
+			span.LogKV("event", "inject failed", "error", err.Error())
+		}
+	}
+	if trace.SpanContextFromContext(r.Context()).IsValid() {
+		r.Header.Del("Traceparent")
+		r.Header.Del("Tracestate")
+		otel.GetTextMapPropagator().Inject(r.Context(), propagation.HeaderCarrier(r.Header))
+	}
+}
+
+func (handler *AuthHandler) rewriteCookies(response *http.Response) error {
+	cookies := response.Cookies()
+	if len(cookies) == 0 {
+		return nil
+	}
+	rewrite := handler.config.Cookies
+	response.Header.Del("Set-Cookie")
+	for _, cookie := range cookies {
+		cookie.Domain = rewrite.Domain
+		if rewrite.Path != "" {
+			cookie.Path = rewrite.Path
+		}
+		if rewrite.Secure {
+			cookie.Secure = true
+		}
+		response.Header.Add("Set-Cookie", cookie.String())
+	}
+	return nil
+}
+
+func (handler *AuthHandler) proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		// The client went away; nobody is left to read an answer.
+		w.WriteHeader(499)
+		return
+	}
+	code := codes.Unavailable
+	if errors.Is(err, context.DeadlineExceeded) || isTimeout(err) {
+		code = codes.DeadlineExceeded
+	}
+	services.WriteStatus(w, r, code, "authentication service unavailable")
+}
 
-	handler.okRequests.Inc()
-	http.Redirect(w, r, "http://"+handler.authClientAdress+"/refresh", 307)
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"api-gateway/infrastructure/services"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// testAuthProxy serves the auth routes through a mux, forwarding to the fake
+// auth service at address.
+func testAuthProxy(address string, config AuthProxyConfig) http.Handler {
+	config.Breaker = services.NewCircuitBreaker("auth", services.BreakerConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Hour,
+	}, prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "breaker_state"}, []string{"service"}))
+	if config.Timeout == 0 {
+		config.Timeout = time.Second
+	}
+	mux := runtime.NewServeMux()
+	NewAuthHandler(address, config, nil, time.Hour, opentracing.NoopTracer{}).Init(mux)
+	return mux
+}
+
+func TestAuthProxyBreaker(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend http.HandlerFunc
+		down    bool
+		calls   int
+		// status is the answer to the last call, hits the calls that reached
+		// the auth service.
+		status int
+		hits   int64
+	}{
+		{"success keeps the breaker closed", func(w http.ResponseWriter, r *http.Request) {}, false, 3, http.StatusOK, 3},
+		{"client errors prove the service is up", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}, false, 3, http.StatusUnauthorized, 3},
+		{"server errors open the breaker", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}, false, 3, http.StatusServiceUnavailable, 2},
+		{"timeouts open the breaker", func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(200 * time.Millisecond):
+			}
+		}, false, 3, http.StatusServiceUnavailable, 2},
+		{"unreachable service opens the breaker", func(w http.ResponseWriter, r *http.Request) {}, true, 2, http.StatusServiceUnavailable, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var hits int64
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt64(&hits, 1)
+				test.backend(w, r)
+			}))
+			defer backend.Close()
+			if test.down {
+				backend.Close()
+			}
+			proxy := testAuthProxy(strings.TrimPrefix(backend.URL, "http://"), AuthProxyConfig{Timeout: 50 * time.Millisecond})
+
+			var status int
+			for i := 0; i < test.calls; i++ {
+				recorder := httptest.NewRecorder()
+				proxy.ServeHTTP(recorder, httptest.NewRequest("POST", "/login", strings.NewReader(`{"username":"alice"}`)))
+				status = recorder.Code
+			}
+			if status != test.status {
+				t.Errorf("got status %d, want %d", status, test.status)
+			}
+			if got := atomic.LoadInt64(&hits); got != test.hits {
+				t.Errorf("auth service got %d calls, want %d", got, test.hits)
+			}
+		})
+	}
+}
+
+func TestAuthProxyForwardsRequest(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/refresh" || r.Header.Get("X-Forwarded-Host") != "gateway.example" {
+			t.Errorf("got %s with forwarded host %q", r.URL.Path, r.Header.Get("X-Forwarded-Host"))
+		}
+		http.SetCookie(w, &http.Cookie{Name: "token", Value: "jwt", Domain: "auth.internal", Path: "/auth"})
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name    string
+		cookies CookieRewrite
+		want    string
+	}{
+		{"host-only cookie", CookieRewrite{Secure: true}, "token=jwt; Path=/auth; Secure"},
+		{"gateway domain and path", CookieRewrite{Domain: "gateway.example", Path: "/"}, "token=jwt; Path=/; Domain=gateway.example"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proxy := testAuthProxy(strings.TrimPrefix(backend.URL, "http://"), AuthProxyConfig{Cookies: test.cookies})
+			request := httptest.NewRequest("GET", "/refresh", nil)
+			request.Host = "gateway.example"
+			recorder := httptest.NewRecorder()
+			proxy.ServeHTTP(recorder, request)
+			if got := recorder.Header().Get("Set-Cookie"); got != test.want {
+				t.Errorf("got cookie %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAuthProxyOtelTraceContext(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracing, err := services.NewOtelTracing(services.OtelConfig{Exporter: exporter, Sampler: services.SamplerParent, SampleRatio: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	tracing.Register()
+	defer func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	}()
+
+	var traceparent string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+	}))
+	defer backend.Close()
+	proxy := tracing.Middleware(testAuthProxy(strings.TrimPrefix(backend.URL, "http://"), AuthProxyConfig{}))
+
+	const callerTrace, callerSpan = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	request := httptest.NewRequest("POST", "/login", strings.NewReader(`{"username":"alice"}`))
+	request.Header.Set("Traceparent", "00-"+callerTrace+"-"+callerSpan+"-01")
+	proxy.ServeHTTP(httptest.NewRecorder(), request)
+	if err := tracing.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var client tracetest.SpanStub
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "LoginHandler" {
+			client = span
+		}
+	}
+	if client.SpanKind != trace.SpanKindClient {
+		t.Fatalf("got spans %v, want a LoginHandler client span", exporter.GetSpans())
+	}
+	want := "00-" + callerTrace + "-" + client.SpanContext.SpanID().String() + "-01"
+	if traceparent != want {
+		t.Errorf("auth service got traceparent %q, want %q", traceparent, want)
+	}
+}
@@ -2,396 +2,293 @@ package api
 
 import (
 	"api-gateway/infrastructure/services"
-	"context"
 	"encoding/json"
-	tracer "github.com/XWS-DISLINKT/dislinkt/tracer"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/opentracing/opentracing-go"
-	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
 	"net/http"
 
 	connection "github.com/XWS-DISLINKT/dislinkt/common/proto/connection-service"
 )
 
 type ConnectionsHandler struct {
-	connectionsClientAddress string
-	tracer                   opentracing.Tracer
-	allRequests              prometheus.Counter
-	okRequests               prometheus.Counter
-	badRequests              prometheus.Counter
+	connectionsClient connection.ConnectionServiceClient
+	tracer            opentracing.Tracer
 }
 
-func NewConnectionsHandler(connectionsClientAddress string, tracer opentracing.Tracer, allRequests prometheus.Counter, okRequests prometheus.Counter, badRequests prometheus.Counter) Handler {
+func NewConnectionsHandler(connectionsClient connection.ConnectionServiceClient, tracer opentracing.Tracer) Handler {
 	return &ConnectionsHandler{
-		connectionsClientAddress: connectionsClientAddress,
-		tracer:                   tracer,
-		allRequests:              allRequests,
-		okRequests:               okRequests,
-		badRequests:              badRequests,
+		connectionsClient: connectionsClient,
+		tracer:            tracer,
 	}
 }
 
 func (handler *ConnectionsHandler) Init(mux *runtime.ServeMux) {
-	err := mux.HandlePath("POST", "/connection", handler.MakeConnectionWithPublicProfile)
-	err = mux.HandlePath("POST", "/connection/request", handler.MakeConnectionRequest)
-	err = mux.HandlePath("PUT", "/connection/approve", handler.ApproveConnectionRequest)
-	err = mux.HandlePath("GET", "/connection/usernames/{id}", handler.GetConnectionsUsernamesFor)
-	err = mux.HandlePath("GET", "/connection/requests/{id}", handler.GetRequestsUsernamesFor)
-	err = mux.HandlePath("POST", "/connection/user", handler.InsertUser)
-	err = mux.HandlePath("POST", "/connection/block", handler.BlockConnection)
-	err = mux.HandlePath("GET", "/connection/blocked/usernames/{id}", handler.GetBlockedConnectionsUsernames)
-	err = mux.HandlePath("PUT", "/connection/user", handler.UpdateUser)
+	registerRoutes(mux, handler.Routes())
+}
 
-	if err != nil {
-		panic(err)
+func (handler *ConnectionsHandler) Routes() []Route {
+	return []Route{
+		{Method: "POST", Pattern: "/connection", Handler: handler.MakeConnectionWithPublicProfile},
+		{Method: "POST", Pattern: "/connection/request", Handler: handler.MakeConnectionRequest},
+		{Method: "PUT", Pattern: "/connection/approve", Handler: handler.ApproveConnectionRequest},
+		{Method: "GET", Pattern: "/connection/usernames/{id}", Handler: handler.GetConnectionsUsernamesFor},
+		{Method: "GET", Pattern: "/connection/requests/{id}", Handler: handler.GetRequestsUsernamesFor},
+		{Method: "POST", Pattern: "/connection/user", Handler: handler.InsertUser},
+		{Method: "POST", Pattern: "/connection/block", Handler: handler.BlockConnection},
+		{Method: "GET", Pattern: "/connection/blocked/usernames/{id}", Handler: handler.GetBlockedConnectionsUsernames},
+		{Method: "PUT", Pattern: "/connection/user", Handler: handler.UpdateUser},
 	}
 }
 
 func (handler *ConnectionsHandler) InsertUser(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	span := tracer.StartSpanFromRequest("InsertUserHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "InsertUserHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	user := connection.User{}
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusBadRequest)
+		services.WriteStatus(w, r, codes.InvalidArgument, "malformed request body")
 		return
 	}
 
-	response, err := services.ConnectionsClient(handler.connectionsClientAddress).InsertUser(context.TODO(), &user)
+	response, err := handler.connectionsClient.InsertUser(ctx, &user)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
 	if !response.Success || err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusBadRequest)
+		services.WriteStatus(w, r, codes.FailedPrecondition, "the connection service rejected the request")
 		return
 	}
 
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 }
 
 func (handler *ConnectionsHandler) UpdateUser(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	span := tracer.StartSpanFromRequest("UpdateUserHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "UpdateUserHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	user := connection.User{}
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusBadRequest)
+		services.WriteStatus(w, r, codes.InvalidArgument, "malformed request body")
 		return
 	}
 
-	response, err := services.ConnectionsClient(handler.connectionsClientAddress).UpdateUser(context.TODO(), &user)
+	response, err := handler.connectionsClient.UpdateUser(ctx, &user)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
 	if !response.Success || err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusBadRequest)
+		services.WriteStatus(w, r, codes.FailedPrecondition, "the connection service rejected the request")
 		return
 	}
 
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 }
 
 func (handler *ConnectionsHandler) MakeConnectionWithPublicProfile(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	if !services.JWTValid(w, r) {
-		return
-	}
-
-	span := tracer.StartSpanFromRequest("MakeConnectionWithPublicProfileHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "MakeConnectionWithPublicProfileHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	request := connection.ConnectionBody{}
 
 	err := json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	if request.GetRequestSenderId() != services.LoggedUserId {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusUnauthorized)
+		services.WriteStatus(w, r, codes.InvalidArgument, "malformed request body")
 		return
 	}
 
-	response, err := services.ConnectionsClient(handler.connectionsClientAddress).MakeConnectionWithPublicProfile(context.TODO(), &request)
+	response, err := handler.connectionsClient.MakeConnectionWithPublicProfile(ctx, &request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
 	if !response.Success {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusBadRequest)
+		services.WriteStatus(w, r, codes.FailedPrecondition, "the connection service rejected the request")
 		return
 	}
 
-	handler.okRequests.Inc()
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 }
 
 func (handler *ConnectionsHandler) MakeConnectionRequest(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	if !services.JWTValid(w, r) {
-		return
-	}
-
-	span := tracer.StartSpanFromRequest("MakeConnectionRequestHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "MakeConnectionRequestHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	request := connection.ConnectionBody{}
 
 	err := json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	if request.GetRequestSenderId() != services.LoggedUserId {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusUnauthorized)
+		services.WriteStatus(w, r, codes.InvalidArgument, "malformed request body")
 		return
 	}
 
-	response, err := services.ConnectionsClient(handler.connectionsClientAddress).MakeConnectionRequest(context.TODO(), &request)
+	response, err := handler.connectionsClient.MakeConnectionRequest(ctx, &request)
 
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
 	if !response.Success {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusBadRequest)
+		services.WriteStatus(w, r, codes.FailedPrecondition, "the connection service rejected the request")
 		return
 	}
 
-	handler.okRequests.Inc()
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 }
 
 func (handler *ConnectionsHandler) ApproveConnectionRequest(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	if !services.JWTValid(w, r) {
-		return
-	}
-
-	span := tracer.StartSpanFromRequest("ApproveConnectionRequestHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "ApproveConnectionRequestHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	request := connection.ConnectionBody{}
 
 	err := json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	if request.GetRequestSenderId() != services.LoggedUserId {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusUnauthorized)
+		services.WriteStatus(w, r, codes.InvalidArgument, "malformed request body")
 		return
 	}
 
-	connectionResponse, err := services.ConnectionsClient(handler.connectionsClientAddress).ApproveConnectionRequest(context.TODO(), &request)
+	connectionResponse, err := handler.connectionsClient.ApproveConnectionRequest(ctx, &request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
 	if !connectionResponse.Success || err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusBadRequest)
+		services.WriteStatus(w, r, codes.FailedPrecondition, "the connection service rejected the request")
 		return
 	}
 
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 }
 
 func (handler *ConnectionsHandler) BlockConnection(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	if !services.JWTValid(w, r) {
-		return
-	}
-
-	span := tracer.StartSpanFromRequest("BlockConnectionHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "BlockConnectionHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	request := connection.ConnectionBody{}
 
 	err := json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	if request.GetRequestSenderId() != services.LoggedUserId {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusUnauthorized)
+		services.WriteStatus(w, r, codes.InvalidArgument, "malformed request body")
 		return
 	}
 
-	connectionResponse, err := services.ConnectionsClient(handler.connectionsClientAddress).BlockConnection(context.TODO(), &request)
+	connectionResponse, err := handler.connectionsClient.BlockConnection(ctx, &request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
 	if !connectionResponse.Success || err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusBadRequest)
+		services.WriteStatus(w, r, codes.FailedPrecondition, "the connection service rejected the request")
 		return
 	}
 
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 }
 
 func (handler *ConnectionsHandler) GetConnectionsUsernamesFor(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	if !services.JWTValid(w, r) {
-		return
-	}
-
-	span := tracer.StartSpanFromRequest("GetConnectionsUsernamesForHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "GetConnectionsUsernamesForHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	usernames := make([]string, 0)
 	id := pathParams["id"]
 
-	if id != services.LoggedUserId {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusUnauthorized)
+	response, err := handler.connectionsClient.GetConnectionsUsernamesFor(ctx,
+		&connection.GetConnectionsUsernamesRequest{Id: id})
+	if err != nil {
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
-	response, err := services.ConnectionsClient(handler.connectionsClientAddress).GetConnectionsUsernamesFor(context.TODO(),
-		&connection.GetConnectionsUsernamesRequest{Id: id})
-
 	if response.Usernames != nil {
 		usernames = response.Usernames
 	}
 
 	res, err := json.Marshal(usernames)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusBadRequest)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 
-	handler.okRequests.Inc()
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(res)
 }
 
 func (handler *ConnectionsHandler) GetRequestsUsernamesFor(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	if !services.JWTValid(w, r) {
-		return
-	}
-
-	span := tracer.StartSpanFromRequest("GetRequestsUsernamesForHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "GetRequestsUsernamesForHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	usernames := make([]string, 0)
 	id := pathParams["id"]
 
-	if id != services.LoggedUserId {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusUnauthorized)
+	response, err := handler.connectionsClient.GetRequestsUsernamesFor(ctx,
+		&connection.GetConnectionsUsernamesRequest{Id: id})
+	if err != nil {
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
-	response, err := services.ConnectionsClient(handler.connectionsClientAddress).GetRequestsUsernamesFor(context.TODO(),
-		&connection.GetConnectionsUsernamesRequest{Id: id})
-
 	if response.Usernames != nil {
 		usernames = response.Usernames
 	}
 
 	resp, err := json.Marshal(usernames)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusBadRequest)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 
-	handler.okRequests.Inc()
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(resp)
 }
 
 func (handler *ConnectionsHandler) GetBlockedConnectionsUsernames(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	if !services.JWTValid(w, r) {
-		return
-	}
-
-	span := tracer.StartSpanFromRequest("GetBlockedConnectionsUsernamesHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "GetBlockedConnectionsUsernamesHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	usernames := make([]string, 0)
 	id := pathParams["id"]
 
-	if id != services.LoggedUserId {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusUnauthorized)
+	response, err := handler.connectionsClient.GetBlockedConnectionsUsernames(ctx,
+		&connection.GetConnectionsUsernamesRequest{Id: id})
+	if err != nil {
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
-	response, err := services.ConnectionsClient(handler.connectionsClientAddress).GetBlockedConnectionsUsernames(context.TODO(),
-		&connection.GetConnectionsUsernamesRequest{Id: id})
-
 	if response.Usernames != nil {
 		usernames = response.Usernames
 	}
 
 	res, err := json.Marshal(usernames)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusBadRequest)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 
-	handler.okRequests.Inc()
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(res)
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"api-gateway/infrastructure/services"
+	"encoding/json"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+)
+
+type CSRFHandler struct {
+	protector *services.CSRFProtector
+}
+
+func NewCSRFHandler(protector *services.CSRFProtector) Handler {
+	return &CSRFHandler{
+		protector: protector,
+	}
+}
+
+func (handler *CSRFHandler) Init(mux *runtime.ServeMux) {
+	registerRoutes(mux, handler.Routes())
+}
+
+func (handler *CSRFHandler) Routes() []Route {
+	return []Route{
+		{Method: "GET", Pattern: "/csrf", Handler: handler.Issue},
+	}
+}
+
+func (handler *CSRFHandler) Issue(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	token, err := handler.protector.Issue(w)
+	if err != nil {
+		services.WriteStatus(w, r, codes.Internal, "issuing a CSRF token failed")
+		return
+	}
+
+	response, err := json.Marshal(map[string]string{"csrfToken": token})
+	if err != nil {
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
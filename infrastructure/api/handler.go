@@ -1,7 +1,54 @@
 package api
 
-import "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+import (
+	"api-gateway/infrastructure/services"
+	"context"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/opentracing/opentracing-go"
+)
 
 type Handler interface {
 	Init(mux *runtime.ServeMux)
+	Routes() []Route
+}
+
+// Route is a custom endpoint served by a handler. Every route needs an entry
+// in the gateway's authorization policy table.
+type Route struct {
+	Method  string
+	Pattern string
+	Handler runtime.HandlerFunc
+}
+
+func registerRoutes(mux *runtime.ServeMux, routes []Route) {
+	for _, route := range routes {
+		if err := mux.HandlePath(route.Method, route.Pattern, withRoute(route)); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// withRoute tells the access log which template matched; generated routes
+// report theirs through the metadata annotator of the mux.
+func withRoute(route Route) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		services.SetRoute(r.Context(), route.Pattern)
+		route.Handler(w, r, pathParams)
+	}
+}
+
+// startSpan starts the span of a handler as a child of the request span
+// started by the tracing middleware.
+func startSpan(tracer opentracing.Tracer, name string, r *http.Request) opentracing.Span {
+	span, _ := opentracing.StartSpanFromContextWithTracer(r.Context(), tracer, name)
+	return span
+}
+
+// backendContext is the context for backend calls made on behalf of r. It
+// keeps the request deadline and caller metadata and adds the handler span,
+// which the tracing interceptor continues into the backend.
+func backendContext(r *http.Request, span opentracing.Span) context.Context {
+	return opentracing.ContextWithSpan(r.Context(), span)
 }
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"api-gateway/infrastructure/services"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	connection "github.com/XWS-DISLINKT/dislinkt/common/proto/connection-service"
+	post "github.com/XWS-DISLINKT/dislinkt/common/proto/post-service"
+	profile "github.com/XWS-DISLINKT/dislinkt/common/proto/profile-service"
+	"github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// faultyBackend answers every call of every backend with a nil response
+// and err, the way a failed gRPC call does.
+type faultyBackend struct {
+	err   error
+	calls int
+}
+
+func (backend *faultyBackend) fail() error {
+	backend.calls++
+	return backend.err
+}
+
+type faultyPostClient struct{ *faultyBackend }
+
+func (client faultyPostClient) PostJobDislinkt(ctx context.Context, in *post.PostJobDislinktRequest, opts ...grpc.CallOption) (*post.Response, error) {
+	return nil, client.fail()
+}
+
+func (client faultyPostClient) SearchJobsByPosition(ctx context.Context, in *post.SearchJobsByPositionRequest, opts ...grpc.CallOption) (*post.GetAllJobsResponse, error) {
+	return nil, client.fail()
+}
+
+func (client faultyPostClient) RegisterApiKey(ctx context.Context, in *post.GetApiKeyRequest, opts ...grpc.CallOption) (*post.Response, error) {
+	return nil, client.fail()
+}
+
+func (client faultyPostClient) PostJob(ctx context.Context, in *post.PostJobRequest, opts ...grpc.CallOption) (*post.Response, error) {
+	return nil, client.fail()
+}
+
+func (client faultyPostClient) GetAllJobs(ctx context.Context, in *post.GetAllJobsRequest, opts ...grpc.CallOption) (*post.GetAllJobsResponse, error) {
+	return nil, client.fail()
+}
+
+func (client faultyPostClient) Get(ctx context.Context, in *post.GetRequest, opts ...grpc.CallOption) (*post.GetResponse, error) {
+	return nil, client.fail()
+}
+
+func (client faultyPostClient) GetAll(ctx context.Context, in *post.GetAllRequest, opts ...grpc.CallOption) (*post.GetAllResponse, error) {
+	return nil, client.fail()
+}
+
+func (client faultyPostClient) Post(ctx context.Context, in *post.PostM, opts ...grpc.CallOption) (*post.Response, error) {
+	return nil, client.fail()
+}
+
+func (client faultyPostClient) LikePost(ctx context.Context, in *post.ReactionRequest, opts ...grpc.CallOption) (*post.Response, error) {
+	return nil, client.fail()
+}
+
+func (client faultyPostClient) DislikePost(ctx context.Context, in *post.ReactionRequest, opts ...grpc.CallOption) (*post.Response, error) {
+	return nil, client.fail()
+}
+
+func (client faultyPostClient) CommentPost(ctx context.Context, in *post.CommentRequest, opts ...grpc.CallOption) (*post.Response, error) {
+	return nil, client.fail()
+}
+
+type faultyProfileClient struct{ *faultyBackend }
+
+func (client faultyProfileClient) Get(ctx context.Context, in *profile.GetRequest, opts ...grpc.CallOption) (*profile.Profile, error) {
+	return nil, client.fail()
+}
+
+func (client faultyProfileClient) GetAll(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*profile.GetAllResponse, error) {
+	return nil, client.fail()
+}
+
+func (client faultyProfileClient) GetChatMessages(ctx context.Context, in *profile.GetMessagesRequest, opts ...grpc.CallOption) (*profile.GetMessagesResponse, error) {
+	return nil, client.fail()
+}
+
+func (client faultyProfileClient) Create(ctx context.Context, in *profile.NewProfile, opts ...grpc.CallOption) (*profile.Response, error) {
+	return nil, client.fail()
+}
+
+func (client faultyProfileClient) SendMessage(ctx context.Context, in *profile.Message, opts ...grpc.CallOption) (*profile.Response, error) {
+	return nil, client.fail()
+}
+
+func (client faultyProfileClient) Update(ctx context.Context, in *profile.Profile, opts ...grpc.CallOption) (*profile.Response, error) {
+	return nil, client.fail()
+}
+
+func (client faultyProfileClient) GetByName(ctx context.Context, in *profile.GetByNameRequest, opts ...grpc.CallOption) (*profile.GetAllResponse, error) {
+	return nil, client.fail()
+}
+
+type faultyConnectionClient struct{ *faultyBackend }
+
+func (client faultyConnectionClient) InsertUser(ctx context.Context, in *connection.User, opts ...grpc.CallOption) (*connection.ConnectionResponse, error) {
+	return nil, client.fail()
+}
+
+func (client faultyConnectionClient) UpdateUser(ctx context.Context, in *connection.User, opts ...grpc.CallOption) (*connection.ConnectionResponse, error) {
+	return nil, client.fail()
+}
+
+func (client faultyConnectionClient) MakeConnectionWithPublicProfile(ctx context.Context, in *connection.ConnectionBody, opts ...grpc.CallOption) (*connection.ConnectionResponse, error) {
+	return nil, client.fail()
+}
+
+func (client faultyConnectionClient) MakeConnectionRequest(ctx context.Context, in *connection.ConnectionBody, opts ...grpc.CallOption) (*connection.ConnectionResponse, error) {
+	return nil, client.fail()
+}
+
+func (client faultyConnectionClient) ApproveConnectionRequest(ctx context.Context, in *connection.ConnectionBody, opts ...grpc.CallOption) (*connection.ConnectionResponse, error) {
+	return nil, client.fail()
+}
+
+func (client faultyConnectionClient) BlockConnection(ctx context.Context, in *connection.ConnectionBody, opts ...grpc.CallOption) (*connection.ConnectionResponse, error) {
+	return nil, client.fail()
+}
+
+func (client faultyConnectionClient) GetConnectionsUsernamesFor(ctx context.Context, in *connection.GetConnectionsUsernamesRequest, opts ...grpc.CallOption) (*connection.GetConnectionsUsernamesResponse, error) {
+	return nil, client.fail()
+}
+
+func (client faultyConnectionClient) GetRequestsUsernamesFor(ctx context.Context, in *connection.GetConnectionsUsernamesRequest, opts ...grpc.CallOption) (*connection.GetConnectionsUsernamesResponse, error) {
+	return nil, client.fail()
+}
+
+func (client faultyConnectionClient) GetBlockedConnectionsUsernames(ctx context.Context, in *connection.GetConnectionsUsernamesRequest, opts ...grpc.CallOption) (*connection.GetConnectionsUsernamesResponse, error) {
+	return nil, client.fail()
+}
+
+var pathParam = regexp.MustCompile(`\{[^}]+\}`)
+
+// localRoutes make no backend call; the image upload writes to disk.
+var localRoutes = map[string]bool{"POST /post/image": true}
+
+// TestBackendFaults fails every backend call of every route and expects a
+// gateway answer for it instead of a panic.
+func TestBackendFaults(t *testing.T) {
+	faults := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"unavailable", status.Error(codes.Unavailable, "connection refused"), http.StatusServiceUnavailable},
+		{"deadline exceeded", status.Error(codes.DeadlineExceeded, "deadline exceeded"), http.StatusGatewayTimeout},
+		{"internal", status.Error(codes.Internal, "nil dereference"), http.StatusBadGateway},
+		{"not a status", errors.New("transport is closing"), http.StatusBadGateway},
+		{"not found", status.Error(codes.NotFound, "no such post"), http.StatusNotFound},
+	}
+	for _, fault := range faults {
+		backend := &faultyBackend{err: fault.err}
+		handlers := []Handler{
+			NewPostHandler(faultyPostClient{backend}, opentracing.NoopTracer{}),
+			NewProfileHandler(faultyProfileClient{backend}, opentracing.NoopTracer{}),
+			NewConnectionsHandler(faultyConnectionClient{backend}, opentracing.NoopTracer{}),
+		}
+		for _, handler := range handlers {
+			server := services.Recover(authenticated(t, handler))
+			for _, route := range handler.Routes() {
+				if localRoutes[route.Method+" "+route.Pattern] {
+					continue
+				}
+				t.Run(fault.name+"/"+route.Method+" "+route.Pattern, func(t *testing.T) {
+					backend.calls = 0
+					path := pathParam.ReplaceAllString(route.Pattern, "1")
+					request := httptest.NewRequest(route.Method, path, strings.NewReader(`{"id":"1","userId":"1","postId":"1"}`))
+					request.Header.Set("Authorization", "Bearer "+signedToken(t, "1"))
+					recorder := httptest.NewRecorder()
+					server.ServeHTTP(recorder, request)
+					if backend.calls == 0 {
+						t.Fatalf("backend was not called, got status %d: %s", recorder.Code, recorder.Body)
+					}
+					if recorder.Code != fault.status {
+						t.Errorf("got status %d, want %d: %s", recorder.Code, fault.status, recorder.Body)
+					}
+				})
+			}
+		}
+	}
+}
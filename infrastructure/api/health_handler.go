@@ -0,0 +1,170 @@
+package api
+
+import (
+	"api-gateway/infrastructure/services"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+)
+
+// Dependency is a backend probed by /readyz. A failing optional dependency
+// degrades readiness without failing it.
+type Dependency struct {
+	Name     string
+	Optional bool
+	Check    services.HealthCheck
+	// Replicas, when set, replaces Check for replicated backends: the
+	// dependency is up while at least one replica is.
+	Replicas func(ctx context.Context) []services.ReplicaStatus
+}
+
+type dependencyStatus struct {
+	Status   string                   `json:"status"`
+	Optional bool                     `json:"optional,omitempty"`
+	Error    string                   `json:"error,omitempty"`
+	Replicas []services.ReplicaStatus `json:"replicas,omitempty"`
+}
+
+type readinessResponse struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]dependencyStatus `json:"dependencies"`
+}
+
+// readinessSummary is what /readyz tells the public port: no addresses and
+// no errors, only whether each dependency is up.
+type readinessSummary struct {
+	Status       string            `json:"status"`
+	Dependencies map[string]string `json:"dependencies"`
+}
+
+type HealthHandler struct {
+	dependencies []Dependency
+	timeout      time.Duration
+	ready        func() bool
+}
+
+func NewHealthHandler(dependencies []Dependency, timeout time.Duration, ready func() bool) *HealthHandler {
+	return &HealthHandler{
+		dependencies: dependencies,
+		timeout:      timeout,
+		ready:        ready,
+	}
+}
+
+func (handler *HealthHandler) Init(mux *runtime.ServeMux) {
+	registerRoutes(mux, handler.Routes())
+}
+
+func (handler *HealthHandler) Routes() []Route {
+	return []Route{
+		{Method: "GET", Pattern: "/healthz", Handler: handler.Healthz},
+		{Method: "GET", Pattern: "/readyz", Handler: handler.Readyz},
+	}
+}
+
+func (handler *HealthHandler) Healthz(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"up"}`))
+}
+
+func (handler *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	response, statusCode := handler.readiness(r.Context())
+	summary := readinessSummary{Status: response.Status, Dependencies: map[string]string{}}
+	for name, dependency := range response.Dependencies {
+		summary.Dependencies[name] = dependency.Status
+	}
+	writeJSON(w, r, statusCode, summary)
+}
+
+// Dependencies serves the readiness of every dependency and replica with
+// their errors. It belongs on the admin listener.
+func (handler *HealthHandler) Dependencies(w http.ResponseWriter, r *http.Request) {
+	response, statusCode := handler.readiness(r.Context())
+	writeJSON(w, r, statusCode, response)
+}
+
+func (handler *HealthHandler) readiness(ctx context.Context) (readinessResponse, int) {
+	response := readinessResponse{
+		Status:       "up",
+		Dependencies: handler.probe(ctx),
+	}
+	statusCode := http.StatusOK
+	for _, dependency := range response.Dependencies {
+		if dependency.Status == "up" {
+			continue
+		}
+		if dependency.Optional {
+			if response.Status == "up" {
+				response.Status = "degraded"
+			}
+			continue
+		}
+		response.Status = "down"
+		statusCode = http.StatusServiceUnavailable
+	}
+	if !handler.ready() {
+		response.Status = "draining"
+		statusCode = http.StatusServiceUnavailable
+	}
+	return response, statusCode
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, statusCode int, response interface{}) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
+// probe checks every dependency concurrently so one slow backend cannot
+// push the response past the orchestrator's probe timeout.
+func (handler *HealthHandler) probe(ctx context.Context) map[string]dependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, handler.timeout)
+	defer cancel()
+
+	statuses := make(map[string]dependencyStatus, len(handler.dependencies))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, dependency := range handler.dependencies {
+		wg.Add(1)
+		go func(dependency Dependency) {
+			defer wg.Done()
+			status := check(ctx, dependency)
+			mutex.Lock()
+			statuses[dependency.Name] = status
+			mutex.Unlock()
+		}(dependency)
+	}
+	wg.Wait()
+	return statuses
+}
+
+func check(ctx context.Context, dependency Dependency) dependencyStatus {
+	status := dependencyStatus{Status: "up", Optional: dependency.Optional}
+	if dependency.Replicas == nil {
+		if err := dependency.Check(ctx); err != nil {
+			status.Status = "down"
+			status.Error = err.Error()
+		}
+		return status
+	}
+	status.Replicas = dependency.Replicas(ctx)
+	for _, replica := range status.Replicas {
+		if replica.Status == "up" {
+			return status
+		}
+	}
+	status.Status = "down"
+	status.Error = "no healthy replica"
+	return status
+}
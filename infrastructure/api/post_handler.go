@@ -2,13 +2,9 @@ package api
 
 import (
 	"api-gateway/infrastructure/services"
-	"context"
 	"encoding/json"
-	"fmt"
-	tracer "github.com/XWS-DISLINKT/dislinkt/tracer"
 	"github.com/opentracing/opentracing-go"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc/codes"
 	"io"
 	"net/http"
 	"os"
@@ -19,428 +15,374 @@ import (
 )
 
 type PostHandler struct {
-	postClientAddress string
-	tracer            opentracing.Tracer
-	allRequests       prometheus.Counter
-	okRequests        prometheus.Counter
-	badRequests       prometheus.Counter
+	postClient post.PostServiceClient
+	tracer     opentracing.Tracer
 }
 
-func NewPostHandler(postClientAddress string, tracer opentracing.Tracer, allRequests prometheus.Counter, okRequests prometheus.Counter, badRequests prometheus.Counter) Handler {
+func NewPostHandler(postClient post.PostServiceClient, tracer opentracing.Tracer) Handler {
 
 	return &PostHandler{
-		postClientAddress: postClientAddress,
-		tracer:            tracer,
-		allRequests:       allRequests,
-		okRequests:        okRequests,
-		badRequests:       badRequests,
+		postClient: postClient,
+		tracer:     tracer,
 	}
 }
 
 func (handler *PostHandler) Init(mux *runtime.ServeMux) {
-	err := mux.HandlePath("GET", "/post", handler.GetAll)
-	err = mux.HandlePath("GET", "/post/{id}", handler.Get)
-	err = mux.HandlePath("POST", "/post", handler.Create)
-	err = mux.HandlePath("GET", "/post/job", handler.GetAllJobs)
-	err = mux.HandlePath("POST", "/post/job", handler.CreateJob)
-	err = mux.HandlePath("POST", "/post/job/apikey", handler.RegisterApiKey)
-	err = mux.HandlePath("GET", "/post/job/{search}", handler.SearchJobsByPosition)
-	err = mux.HandlePath("POST", "/post/job/dislinkt", handler.CreateJobDislinkt)
-	err = mux.HandlePath("POST", "/post/like", handler.Like)
-	err = mux.HandlePath("POST", "/post/dislike", handler.Dislike)
-	err = mux.HandlePath("POST", "/post/comment", handler.Comment)
-	err = mux.HandlePath("POST", "/post/image", handler.UploadImage)
-	err = mux.HandlePath("GET", "/actuator/prometheus", handler.PrometheusHandler)
-	if err != nil {
-		panic(err)
-	}
+	registerRoutes(mux, handler.Routes())
 }
 
-func (handler *PostHandler) PrometheusHandler(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	promhttp.Handler().ServeHTTP(w, r)
+func (handler *PostHandler) Routes() []Route {
+	return []Route{
+		{Method: "GET", Pattern: "/post", Handler: handler.GetAll},
+		{Method: "GET", Pattern: "/post/{id}", Handler: handler.Get},
+		{Method: "POST", Pattern: "/post", Handler: handler.Create},
+		{Method: "GET", Pattern: "/post/job", Handler: handler.GetAllJobs},
+		{Method: "POST", Pattern: "/post/job", Handler: handler.CreateJob},
+		{Method: "POST", Pattern: "/post/job/apikey", Handler: handler.RegisterApiKey},
+		{Method: "GET", Pattern: "/post/job/{search}", Handler: handler.SearchJobsByPosition},
+		{Method: "POST", Pattern: "/post/job/dislinkt", Handler: handler.CreateJobDislinkt},
+		{Method: "POST", Pattern: "/post/like", Handler: handler.Like},
+		{Method: "POST", Pattern: "/post/dislike", Handler: handler.Dislike},
+		{Method: "POST", Pattern: "/post/comment", Handler: handler.Comment},
+		{Method: "POST", Pattern: "/post/image", Handler: handler.UploadImage},
+	}
 }
 
 func (handler *PostHandler) CreateJobDislinkt(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	if !services.JWTValid(w, r) {
+	principal, ok := services.PrincipalFromContext(r.Context())
+	if !ok {
+		services.WriteStatus(w, r, codes.Unauthenticated, "authentication required")
 		return
 	}
 
-	span := tracer.StartSpanFromRequest("CreateJobDislinktHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "CreateJobDislinktHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	request := post.PostJobDislinktRequest{}
 	err := json.NewDecoder(r.Body).Decode(&request.Job)
-	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+	if err != nil || request.Job == nil {
+		services.WriteStatus(w, r, codes.InvalidArgument, "malformed request body")
 		return
 	}
-	request.Job.UserId = services.LoggedUserId
-	responsePost, err := services.NewPostClient(handler.postClientAddress).PostJobDislinkt(context.TODO(), &request)
+	request.Job.UserId = principal.Id
+	responsePost, err := handler.postClient.PostJobDislinkt(ctx, &request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
 	response, err := json.Marshal(responsePost)
 
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 }
 
 func (handler *PostHandler) SearchJobsByPosition(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	span := tracer.StartSpanFromRequest("SearchJobsByPositionHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "SearchJobsByPositionHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
-	responseGrpc, err := services.NewPostClient(handler.postClientAddress).SearchJobsByPosition(context.TODO(), &post.SearchJobsByPositionRequest{Search: pathParams["search"]})
-	responseJobs := responseGrpc.Jobs
+	responseGrpc, err := handler.postClient.SearchJobsByPosition(ctx, &post.SearchJobsByPositionRequest{Search: pathParams["search"]})
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
+	responseJobs := responseGrpc.Jobs
 
 	response, err := json.Marshal(responseJobs)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 }
 
 func (handler *PostHandler) RegisterApiKey(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	if !services.JWTValid(w, r) {
+	principal, ok := services.PrincipalFromContext(r.Context())
+	if !ok {
+		services.WriteStatus(w, r, codes.Unauthenticated, "authentication required")
 		return
 	}
 
-	span := tracer.StartSpanFromRequest("RegisterApiKeyHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "RegisterApiKeyHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
-	request := post.GetApiKeyRequest{UserId: services.LoggedUserId}
-	serviceResponse, err := services.NewPostClient(handler.postClientAddress).RegisterApiKey(context.TODO(), &request)
+	request := post.GetApiKeyRequest{UserId: principal.Id}
+	serviceResponse, err := handler.postClient.RegisterApiKey(ctx, &request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
 	response, err := json.Marshal(serviceResponse)
 
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 }
 
 func (handler *PostHandler) CreateJob(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	span := tracer.StartSpanFromRequest("CreateJobHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "CreateJobHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	request := post.PostJobRequest{}
 	err := json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.InvalidArgument, "malformed request body")
 		return
 	}
-	responsePost, err := services.NewPostClient(handler.postClientAddress).PostJob(context.TODO(), &request)
+	responsePost, err := handler.postClient.PostJob(ctx, &request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
 	response, err := json.Marshal(responsePost)
 
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 }
 
 func (handler *PostHandler) GetAllJobs(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	span := tracer.StartSpanFromRequest("GetAllJobsHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "GetAllJobsHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
-	responseGrpc, err := services.NewPostClient(handler.postClientAddress).GetAllJobs(context.TODO(), &post.GetAllJobsRequest{})
-	responseJobs := responseGrpc.Jobs
+	responseGrpc, err := handler.postClient.GetAllJobs(ctx, &post.GetAllJobsRequest{})
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
+	responseJobs := responseGrpc.Jobs
 
 	response, err := json.Marshal(responseJobs)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 }
 
 func (handler *PostHandler) Get(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	span := tracer.StartSpanFromRequest("GetPostHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "GetPostHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
-	responseGrpc, err := services.NewPostClient(handler.postClientAddress).Get(context.TODO(), &post.GetRequest{Id: pathParams["id"]})
-	responsePost := responseGrpc.Post
+	responseGrpc, err := handler.postClient.Get(ctx, &post.GetRequest{Id: pathParams["id"]})
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
+	responsePost := responseGrpc.Post
 
 	response, err := json.Marshal(responsePost)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 }
 
 func (handler *PostHandler) GetAll(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	span := tracer.StartSpanFromRequest("GetAllPostsHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "GetAllPostsHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
-	responseGrpc, err := services.NewPostClient(handler.postClientAddress).GetAll(context.TODO(), &post.GetAllRequest{})
-	responsePost := responseGrpc.Posts
+	responseGrpc, err := handler.postClient.GetAll(ctx, &post.GetAllRequest{})
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
+	responsePost := responseGrpc.Posts
 
 	response, err := json.Marshal(responsePost)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 }
 
 func (handler *PostHandler) Create(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	if !services.JWTValid(w, r) {
-		return
-	}
-
-	span := tracer.StartSpanFromRequest("CreatePostHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "CreatePostHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	request := post.PostM{}
 	err := json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.InvalidArgument, "malformed request body")
 		return
 	}
-	responsePost, err := services.NewPostClient(handler.postClientAddress).Post(context.TODO(), &request)
+	responsePost, err := handler.postClient.Post(ctx, &request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
 	response, err := json.Marshal(responsePost)
 
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 }
 
 func (handler *PostHandler) Like(w http.ResponseWriter, r *http.Request, params map[string]string) {
-	handler.allRequests.Inc()
-
-	if !services.JWTValid(w, r) {
+	principal, ok := services.PrincipalFromContext(r.Context())
+	if !ok {
+		services.WriteStatus(w, r, codes.Unauthenticated, "authentication required")
 		return
 	}
 
-	span := tracer.StartSpanFromRequest("LikePostHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "LikePostHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	request := post.ReactionRequest{}
 	err := json.NewDecoder(r.Body).Decode(&request.Reaction)
-	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+	if err != nil || request.Reaction == nil {
+		services.WriteStatus(w, r, codes.InvalidArgument, "malformed request body")
 		return
 	}
-	request.Reaction.Username = services.LoggedUserUsername
-	responsePost, err := services.NewPostClient(handler.postClientAddress).LikePost(context.TODO(), &request)
+	request.Reaction.Username = principal.Username
+	responsePost, err := handler.postClient.LikePost(ctx, &request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
 	response, err := json.Marshal(responsePost)
 
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 }
 
 func (handler *PostHandler) Dislike(w http.ResponseWriter, r *http.Request, params map[string]string) {
-	handler.allRequests.Inc()
-
-	if !services.JWTValid(w, r) {
+	principal, ok := services.PrincipalFromContext(r.Context())
+	if !ok {
+		services.WriteStatus(w, r, codes.Unauthenticated, "authentication required")
 		return
 	}
 
-	span := tracer.StartSpanFromRequest("DislikePostHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "DislikePostHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	request := post.ReactionRequest{}
 	err := json.NewDecoder(r.Body).Decode(&request.Reaction)
-	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+	if err != nil || request.Reaction == nil {
+		services.WriteStatus(w, r, codes.InvalidArgument, "malformed request body")
 		return
 	}
-	request.Reaction.Username = services.LoggedUserUsername
-	responsePost, err := services.NewPostClient(handler.postClientAddress).DislikePost(context.TODO(), &request)
+	request.Reaction.Username = principal.Username
+	responsePost, err := handler.postClient.DislikePost(ctx, &request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
 	response, err := json.Marshal(responsePost)
 
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 }
 
 func (handler *PostHandler) Comment(w http.ResponseWriter, r *http.Request, params map[string]string) {
-	handler.allRequests.Inc()
-
-	if !services.JWTValid(w, r) {
+	principal, ok := services.PrincipalFromContext(r.Context())
+	if !ok {
+		services.WriteStatus(w, r, codes.Unauthenticated, "authentication required")
 		return
 	}
 
-	span := tracer.StartSpanFromRequest("CommentPostHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "CommentPostHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	request := post.CommentRequest{}
 	err := json.NewDecoder(r.Body).Decode(&request.Comment)
-	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+	if err != nil || request.Comment == nil {
+		services.WriteStatus(w, r, codes.InvalidArgument, "malformed request body")
 		return
 	}
-	request.Comment.Username = services.LoggedUserUsername
-	responsePost, err := services.NewPostClient(handler.postClientAddress).CommentPost(context.TODO(), &request)
+	request.Comment.Username = principal.Username
+	responsePost, err := handler.postClient.CommentPost(ctx, &request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
 	response, err := json.Marshal(responsePost)
 
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 }
 
 func (handler *PostHandler) UploadImage(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	span := tracer.StartSpanFromRequest("ImagePostHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "ImagePostHandler", r)
 	defer span.Finish()
 	// left shift 32 << 20 which results in 32*2^20 = 33554432
 	// x << y, results in x*2^y
 	err := r.ParseMultipartForm(32 << 20)
 	if err != nil {
+		services.WriteStatus(w, r, codes.InvalidArgument, "malformed multipart form")
 		return
 	}
 	n := r.FormValue("fileName")
 	// Retrieve the file from form data
 	f, _, err := r.FormFile("file")
 	if err != nil {
+		services.WriteStatus(w, r, codes.InvalidArgument, "the form has no file")
 		return
 	}
 	defer f.Close()
 	path := ""
 	if _, err := os.Stat("/.dockerenv"); err == nil {
-		fmt.Println("docker")
 		path = filepath.Join("..", "usr", "src", "app", "assets", "images")
 	} else {
-		fmt.Println("local")
 		path = filepath.Join("..", "client-web-app", "dislinkt-client", "src", "assets", "images")
 	}
 
@@ -448,14 +390,15 @@ func (handler *PostHandler) UploadImage(w http.ResponseWriter, r *http.Request,
 	fullPath := path + "/" + n
 	file, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE, os.ModePerm)
 	if err != nil {
+		services.WriteStatus(w, r, codes.Internal, "storing the image failed")
 		return
 	}
 	defer file.Close()
 	// Copy the file to the destination path
 	_, err = io.Copy(file, f)
 	if err != nil {
+		services.WriteStatus(w, r, codes.Internal, "storing the image failed")
 		return
 	}
 
-	handler.okRequests.Inc()
 }
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"api-gateway/infrastructure/services"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	post "github.com/XWS-DISLINKT/dislinkt/common/proto/post-service"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc"
+)
+
+const testSecret = "test-secret-with-enough-entropy"
+
+// fakePostClient records which user every reaction on a post was sent for.
+// Each call sleeps a little so concurrent requests overlap.
+type fakePostClient struct {
+	post.PostServiceClient
+	mutex     sync.Mutex
+	usernames map[string]string
+}
+
+func (client *fakePostClient) record(postId, username string) (*post.Response, error) {
+	time.Sleep(time.Duration(rand.Intn(2000)) * time.Microsecond)
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.usernames[postId] = username
+	return &post.Response{}, nil
+}
+
+func (client *fakePostClient) LikePost(ctx context.Context, in *post.ReactionRequest, opts ...grpc.CallOption) (*post.Response, error) {
+	return client.record(in.Reaction.PostId, in.Reaction.Username)
+}
+
+func (client *fakePostClient) DislikePost(ctx context.Context, in *post.ReactionRequest, opts ...grpc.CallOption) (*post.Response, error) {
+	return client.record(in.Reaction.PostId, in.Reaction.Username)
+}
+
+func (client *fakePostClient) CommentPost(ctx context.Context, in *post.CommentRequest, opts ...grpc.CallOption) (*post.Response, error) {
+	return client.record(in.Comment.PostId, in.Comment.Username)
+}
+
+func signedToken(t testing.TB, id string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":       id,
+		"username": "user-" + id,
+		"iat":      time.Now().Unix(),
+		"exp":      time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// authenticated serves handler's routes behind the authentication
+// middleware, as the gateway does.
+func authenticated(t testing.TB, handler Handler) http.Handler {
+	t.Helper()
+	verifier, err := services.NewTokenVerifier(services.VerifierConfig{Algorithms: []string{"HS256"}, Secret: []byte(testSecret)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := services.NewAuthenticator(verifier, services.AuthenticatorConfig{
+		TokenSources: []string{services.TokenSourceBearer},
+		Realm:        "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := runtime.NewServeMux()
+	handler.Init(mux)
+	return services.NewPolicyTable(nil, services.Authenticated, authenticator).Middleware(mux)
+}
+
+func TestPostHandlerParallelCallers(t *testing.T) {
+	const callers = 50
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"like", "/post/like"},
+		{"dislike", "/post/dislike"},
+		{"comment", "/post/comment"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &fakePostClient{usernames: map[string]string{}}
+			server := authenticated(t, NewPostHandler(client, opentracing.NoopTracer{}))
+			tokens := make([]string, callers)
+			for i := range tokens {
+				tokens[i] = signedToken(t, fmt.Sprint(i))
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < callers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					// The body claims another user; the token must win.
+					body := fmt.Sprintf(`{"postId":"post-%d","username":"user-%d"}`, i, (i+1)%callers)
+					request := httptest.NewRequest("POST", test.path, strings.NewReader(body))
+					request.Header.Set("Authorization", "Bearer "+tokens[i])
+					recorder := httptest.NewRecorder()
+					server.ServeHTTP(recorder, request)
+					if recorder.Code != http.StatusOK {
+						t.Errorf("caller %d: got status %d", i, recorder.Code)
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			for i := 0; i < callers; i++ {
+				if got, want := client.usernames[fmt.Sprintf("post-%d", i)], fmt.Sprintf("user-%d", i); got != want {
+					t.Errorf("post-%d was sent for %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
@@ -4,9 +4,8 @@ import (
 	"api-gateway/infrastructure/services"
 	"context"
 	"encoding/json"
-	tracer "github.com/XWS-DISLINKT/dislinkt/tracer"
 	"github.com/opentracing/opentracing-go"
-	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"net/http"
 
@@ -15,285 +14,245 @@ import (
 )
 
 type ProfileHandler struct {
-	profileClientAdress string
-	tracer              opentracing.Tracer
-	allRequests         prometheus.Counter
-	okRequests          prometheus.Counter
-	badRequests         prometheus.Counter
+	profileClient profile.ProfileServiceClient
+	tracer        opentracing.Tracer
 }
 
-func NewProfileHandler(profileClientAdress string, tracer opentracing.Tracer, allRequests prometheus.Counter, okRequests prometheus.Counter, badRequests prometheus.Counter) Handler {
+func NewProfileHandler(profileClient profile.ProfileServiceClient, tracer opentracing.Tracer) Handler {
 	return &ProfileHandler{
-		profileClientAdress: profileClientAdress,
-		tracer:              tracer,
-		allRequests:         allRequests,
-		okRequests:          okRequests,
-		badRequests:         badRequests,
+		profileClient: profileClient,
+		tracer:        tracer,
 	}
 }
 
 func (handler *ProfileHandler) Init(mux *runtime.ServeMux) {
-	err := mux.HandlePath("GET", "/profile", handler.GetAll)
-	err = mux.HandlePath("GET", "/profile/{id}", handler.Get)
-	err = mux.HandlePath("POST", "/profile", handler.Create)
-	err = mux.HandlePath("PUT", "/profile/{id}", handler.Update)
-	err = mux.HandlePath("GET", "/profile/search/{name}", handler.GetByName)
-	//err = mux.HandlePath("POST", "/message", handler.SendMessage)
-	//err = mux.HandlePath("GET", "/message/{senderId}/{receiverId}", handler.GetChatMessages)
-	if err != nil {
-		panic(err)
+	registerRoutes(mux, handler.Routes())
+}
+
+func (handler *ProfileHandler) Routes() []Route {
+	return []Route{
+		{Method: "GET", Pattern: "/profile", Handler: handler.GetAll},
+		{Method: "GET", Pattern: "/profile/{id}", Handler: handler.Get},
+		{Method: "POST", Pattern: "/profile", Handler: handler.Create},
+		{Method: "PUT", Pattern: "/profile/{id}", Handler: handler.Update},
+		{Method: "GET", Pattern: "/profile/search/{name}", Handler: handler.GetByName},
+		//{Method: "POST", Pattern: "/message", Handler: handler.SendMessage},
+		//{Method: "GET", Pattern: "/message/{senderId}/{receiverId}", Handler: handler.GetChatMessages},
 	}
 }
 
 func (handler *ProfileHandler) Get(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	span := tracer.StartSpanFromRequest("GetProfileHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "GetProfileHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	id := pathParams["id"]
-	profileClient := services.NewProfileClient(handler.profileClientAdress)
-	profile, err := profileClient.Get(context.TODO(), &profile.GetRequest{Id: id})
+	profile, err := handler.profileClient.Get(ctx, &profile.GetRequest{Id: id})
 
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
 	if profile.Id == "" {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusBadRequest)
+		services.WriteStatus(w, r, codes.NotFound, "profile not found")
 		return
 	}
 
 	response, err := json.Marshal(profile)
 
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 }
 
 func (handler *ProfileHandler) GetChatMessages(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	span := tracer.StartSpanFromRequest("GetChatMessagesHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "GetChatMessagesHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	senderId := pathParams["senderId"]
 	receiverId := pathParams["receiverId"]
 	messages := make([](*profile.Message), 0)
 
-	handler.addMessages(&messages, senderId, receiverId)
+	if err := handler.addMessages(ctx, &messages, senderId, receiverId); err != nil {
+		services.WriteBackendError(w, r, err)
+		return
+	}
 
 	response, err := json.Marshal(messages)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 
 }
 
 func (handler *ProfileHandler) GetAll(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
 	//if !services.JWTValid(w, r) {
 	//	return
 	//}
-	span := tracer.StartSpanFromRequest("GetAllProfilesHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "GetAllProfilesHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	profiles := make([](*profile.Profile), 0)
 
-	handler.addProfiles(&profiles)
+	if err := handler.addProfiles(ctx, &profiles); err != nil {
+		services.WriteBackendError(w, r, err)
+		return
+	}
 
 	response, err := json.Marshal(profiles)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 }
 
-func (handler *ProfileHandler) addProfiles(profiles *[]*profile.Profile) error {
-	profileClient := services.NewProfileClient(handler.profileClientAdress)
-	response, err := profileClient.GetAll(context.TODO(), &emptypb.Empty{})
-	*profiles = response.Profiles
+func (handler *ProfileHandler) addProfiles(ctx context.Context, profiles *[]*profile.Profile) error {
+	response, err := handler.profileClient.GetAll(ctx, &emptypb.Empty{})
 	if err != nil {
 		return err
 	}
+	*profiles = response.Profiles
 	return nil
 }
 
-func (handler *ProfileHandler) addMessages(messages *[]*profile.Message, senderId string, receiverId string) error {
-	profileClient := services.NewProfileClient(handler.profileClientAdress)
-	response, err := profileClient.GetChatMessages(context.TODO(), &profile.GetMessagesRequest{
+func (handler *ProfileHandler) addMessages(ctx context.Context, messages *[]*profile.Message, senderId string, receiverId string) error {
+	response, err := handler.profileClient.GetChatMessages(ctx, &profile.GetMessagesRequest{
 		SenderId:   senderId,
 		ReceiverId: receiverId,
 	})
-	*messages = response.Messages
 	if err != nil {
 		return err
 	}
+	*messages = response.Messages
 	return nil
 }
 
 func (handler *ProfileHandler) Create(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	span := tracer.StartSpanFromRequest("CreateProfileHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "CreateProfileHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	request := profile.NewProfile{}
 	err := json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.InvalidArgument, "malformed request body")
 		return
 	}
-	responseProfile, err := services.NewProfileClient(handler.profileClientAdress).Create(context.TODO(), &request)
+	responseProfile, err := handler.profileClient.Create(ctx, &request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
 	response, err := json.Marshal(responseProfile)
 
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 }
 
 func (handler *ProfileHandler) SendMessage(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	span := tracer.StartSpanFromRequest("SendMessageHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "SendMessageHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	newMessage := profile.Message{}
 	err := json.NewDecoder(r.Body).Decode(&newMessage)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.InvalidArgument, "malformed request body")
 		return
 	}
-	responseMessage, err := services.NewProfileClient(handler.profileClientAdress).SendMessage(context.TODO(), &newMessage)
+	responseMessage, err := handler.profileClient.SendMessage(ctx, &newMessage)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
 	response, err := json.Marshal(responseMessage)
 
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusCreated)
 	w.Write(response)
 }
 
 func (handler *ProfileHandler) Update(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	if !services.JWTValid(w, r) {
-		return
-	}
-
-	span := tracer.StartSpanFromRequest("UpdateProfileHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "UpdateProfileHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	request := profile.Profile{}
 	err := json.NewDecoder(r.Body).Decode(&request)
 
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.InvalidArgument, "malformed request body")
 		return
 	}
 
 	request.Id = pathParams["id"]
 
-	if request.Id != services.LoggedUserId {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-
-	responseProfile, err := services.NewProfileClient(handler.profileClientAdress).Update(context.TODO(), &request)
+	responseProfile, err := handler.profileClient.Update(ctx, &request)
 
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
 	response, err := json.Marshal(responseProfile)
 
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 }
 
 func (handler *ProfileHandler) GetByName(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-	handler.allRequests.Inc()
-
-	span := tracer.StartSpanFromRequest("GetByNameHandler", handler.tracer, r)
+	span := startSpan(handler.tracer, "GetByNameHandler", r)
 	defer span.Finish()
+	ctx := backendContext(r, span)
 
 	name := pathParams["name"]
 	request := profile.GetByNameRequest{Name: name}
-	responseProfiles, err := services.NewProfileClient(handler.profileClientAdress).GetByName(context.TODO(), &request)
+	responseProfiles, err := handler.profileClient.GetByName(ctx, &request)
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteBackendError(w, r, err)
 		return
 	}
 
 	response, err := json.Marshal(responseProfiles)
 
 	if err != nil {
-		handler.badRequests.Inc()
-		w.WriteHeader(http.StatusInternalServerError)
+		services.WriteStatus(w, r, codes.Internal, "encoding the response failed")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	handler.okRequests.Inc()
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 }
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"api-gateway/infrastructure/services"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+)
+
+// RevocationHandler lets administrators end every session of a user, for
+// example after an account compromise.
+type RevocationHandler struct {
+	revocations      services.RevocationStore
+	maxTokenLifetime time.Duration
+}
+
+func NewRevocationHandler(revocations services.RevocationStore, maxTokenLifetime time.Duration) Handler {
+	return &RevocationHandler{
+		revocations:      revocations,
+		maxTokenLifetime: maxTokenLifetime,
+	}
+}
+
+func (handler *RevocationHandler) Init(mux *runtime.ServeMux) {
+	registerRoutes(mux, handler.Routes())
+}
+
+func (handler *RevocationHandler) Routes() []Route {
+	return []Route{
+		{Method: "POST", Pattern: "/admin/users/{id}/revoke", Handler: handler.RevokeUser},
+	}
+}
+
+// RevokeUser invalidates all tokens of the user issued up to now. The entry
+// lives as long as the longest token could.
+func (handler *RevocationHandler) RevokeUser(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	userId := pathParams["id"]
+	now := time.Now()
+	if err := handler.revocations.RevokeUser(r.Context(), userId, now, now.Add(handler.maxTokenLifetime)); err != nil {
+		log.Printf("failed to revoke tokens of user %s: %v", userId, err)
+		services.WriteStatus(w, r, codes.Unavailable, "could not revoke the user's tokens")
+		return
+	}
+	if principal, ok := services.PrincipalFromContext(r.Context()); ok {
+		log.Printf("user %s revoked all tokens of user %s", principal.Id, userId)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+var levels = map[string]int{LevelDebug: 0, LevelInfo: 1, LevelWarn: 2, LevelError: 3}
+
+// RequestInfo collects what the access log reports about a request. The
+// middleware puts it in the request context and code further down the chain
+// fills it in, since the outermost handler cannot see their contexts.
+type RequestInfo struct {
+	mutex    sync.Mutex
+	route    string
+	userId   string
+	traceId  string
+	backends []string
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFromContext(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info
+}
+
+// withRequestInfo returns r with a RequestInfo, reusing the one an outer
+// middleware already added.
+func withRequestInfo(r *http.Request) (*http.Request, *RequestInfo) {
+	if info := requestInfoFromContext(r.Context()); info != nil {
+		return r, info
+	}
+	info := &RequestInfo{}
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)), info
+}
+
+// SetRoute records the route template that matched the request, such as
+// "/profile/{id}".
+func SetRoute(ctx context.Context, route string) {
+	if info := requestInfoFromContext(ctx); info != nil {
+		info.mutex.Lock()
+		info.route = route
+		info.mutex.Unlock()
+	}
+}
+
+// RouteFromContext returns the template recorded by SetRoute, empty when no
+// route matched.
+func RouteFromContext(ctx context.Context) string {
+	info := requestInfoFromContext(ctx)
+	if info == nil {
+		return ""
+	}
+	info.mutex.Lock()
+	defer info.mutex.Unlock()
+	return info.route
+}
+
+// SetTraceId records the trace the request belongs to.
+func SetTraceId(ctx context.Context, traceId string) {
+	if info := requestInfoFromContext(ctx); info != nil && traceId != "" {
+		info.mutex.Lock()
+		info.traceId = traceId
+		info.mutex.Unlock()
+	}
+}
+
+// AddBackend records a backend called while serving the request, once.
+func AddBackend(ctx context.Context, backend string) {
+	info := requestInfoFromContext(ctx)
+	if info == nil {
+		return
+	}
+	info.mutex.Lock()
+	defer info.mutex.Unlock()
+	for _, existing := range info.backends {
+		if existing == backend {
+			return
+		}
+	}
+	info.backends = append(info.backends, backend)
+}
+
+func setUser(ctx context.Context, userId string) {
+	if info := requestInfoFromContext(ctx); info != nil {
+		info.mutex.Lock()
+		info.userId = userId
+		info.mutex.Unlock()
+	}
+}
+
+// TraceId extracts the trace id of span through the tracer's text map
+// format, which works for Jaeger, W3C and B3 propagation alike.
+func TraceId(tracer opentracing.Tracer, span opentracing.SpanContext) string {
+	carrier := opentracing.TextMapCarrier{}
+	if err := tracer.Inject(span, opentracing.TextMap, carrier); err != nil {
+		return ""
+	}
+	for key, value := range carrier {
+		switch strings.ToLower(key) {
+		case "uber-trace-id":
+			traceId, _, _ := strings.Cut(value, ":")
+			return traceId
+		case "traceparent":
+			if fields := strings.Split(value, "-"); len(fields) == 4 {
+				return fields[1]
+			}
+		case "x-b3-traceid":
+			return value
+		}
+	}
+	return ""
+}
+
+type AccessLogConfig struct {
+	// Level is the least severe line written: successful requests log at
+	// info, 4xx at warn and 5xx at error.
+	Level string
+	// SuccessSampleRate is the fraction of successful requests logged, so
+	// busy routes do not drown the failures.
+	SuccessSampleRate float64
+	Output            io.Writer
+	ClientIP          func(r *http.Request) string
+}
+
+// AccessLog writes one JSON line per request. It runs inside RequestId so
+// every line carries the id of its request.
+type AccessLog struct {
+	config AccessLogConfig
+	level  int
+	mutex  sync.Mutex
+	now    func() time.Time
+}
+
+func NewAccessLog(config AccessLogConfig) (*AccessLog, error) {
+	level, ok := levels[config.Level]
+	if !ok {
+		return nil, fmt.Errorf("unknown log level %q", config.Level)
+	}
+	if config.SuccessSampleRate < 0 || config.SuccessSampleRate > 1 {
+		return nil, fmt.Errorf("success sample rate %v is not between 0 and 1", config.SuccessSampleRate)
+	}
+	return &AccessLog{config: config, level: level, now: time.Now}, nil
+}
+
+type accessLogLine struct {
+	Time      string   `json:"time"`
+	Level     string   `json:"level"`
+	Message   string   `json:"msg"`
+	RequestId string   `json:"requestId"`
+	Method    string   `json:"method"`
+	Route     string   `json:"route,omitempty"`
+	Path      string   `json:"path"`
+	Status    int      `json:"status"`
+	LatencyMs float64  `json:"latencyMs"`
+	Bytes     int64    `json:"bytes"`
+	UserId    string   `json:"userId,omitempty"`
+	ClientIP  string   `json:"clientIp"`
+	TraceId   string   `json:"traceId,omitempty"`
+	Backends  []string `json:"backends,omitempty"`
+}
+
+func (accessLog *AccessLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := accessLog.now()
+		r, info := withRequestInfo(r)
+		recorder := NewResponseRecorder(w)
+		next.ServeHTTP(recorder, r)
+
+		level := LevelInfo
+		switch {
+		case recorder.status >= http.StatusInternalServerError:
+			level = LevelError
+		case recorder.status >= http.StatusBadRequest:
+			level = LevelWarn
+		case mathrand.Float64() >= accessLog.config.SuccessSampleRate:
+			return
+		}
+		if levels[level] < accessLog.level {
+			return
+		}
+		info.mutex.Lock()
+		line := accessLogLine{
+			Time:      start.UTC().Format(time.RFC3339Nano),
+			Level:     level,
+			Message:   "request",
+			RequestId: r.Header.Get(RequestIdHeader),
+			Method:    r.Method,
+			Route:     info.route,
+			Path:      r.URL.Path,
+			Status:    recorder.status,
+			LatencyMs: float64(accessLog.now().Sub(start).Microseconds()) / 1000,
+			Bytes:     recorder.bytes,
+			UserId:    info.userId,
+			ClientIP:  accessLog.config.ClientIP(r),
+			TraceId:   info.traceId,
+			Backends:  info.backends,
+		}
+		info.mutex.Unlock()
+		accessLog.write(line)
+	})
+}
+
+func (accessLog *AccessLog) write(line accessLogLine) {
+	encoded, err := json.Marshal(line)
+	if err != nil {
+		return
+	}
+	accessLog.mutex.Lock()
+	defer accessLog.mutex.Unlock()
+	accessLog.config.Output.Write(append(encoded, '\n'))
+}
+
+// ResponseRecorder remembers the status and size of the response. It passes
+// Flush on so streamed bodies are not buffered.
+type ResponseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+// Status is the status written so far, 200 when none was.
+func (recorder *ResponseRecorder) Status() int {
+	return recorder.status
+}
+
+func (recorder *ResponseRecorder) WriteHeader(status int) {
+	if !recorder.wroteHeader {
+		recorder.status = status
+		recorder.wroteHeader = true
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *ResponseRecorder) Write(body []byte) (int, error) {
+	recorder.wroteHeader = true
+	written, err := recorder.ResponseWriter.Write(body)
+	recorder.bytes += int64(written)
+	return written, err
+}
+
+func (recorder *ResponseRecorder) Flush() {
+	if flusher, ok := recorder.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
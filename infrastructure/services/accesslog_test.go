@@ -0,0 +1,167 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAccessLog(t *testing.T, level string, sampleRate float64) (*AccessLog, *bytes.Buffer) {
+	t.Helper()
+	var out bytes.Buffer
+	accessLog, err := NewAccessLog(AccessLogConfig{
+		Level:             level,
+		SuccessSampleRate: sampleRate,
+		Output:            &out,
+		ClientIP:          func(r *http.Request) string { return "203.0.113.5" },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return accessLog, &out
+}
+
+func TestAccessLogLine(t *testing.T) {
+	accessLog, out := newTestAccessLog(t, LevelInfo, 1)
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	times := []time.Time{start, start.Add(1500 * time.Microsecond)}
+	accessLog.now = func() time.Time {
+		now := times[0]
+		times = times[1:]
+		return now
+	}
+	handler := accessLog.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRoute(r.Context(), "/profile/{id}")
+		SetTraceId(r.Context(), testTraceId)
+		setUser(r.Context(), "alice")
+		AddBackend(r.Context(), "profile")
+		AddBackend(r.Context(), "post")
+		AddBackend(r.Context(), "profile")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("no such profile"))
+	}))
+	request := httptest.NewRequest("GET", "/profile/7", nil)
+	request.Header.Set(RequestIdHeader, "request-1")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	if strings.Count(out.String(), "\n") != 1 {
+		t.Fatalf("got %q, want one line", out)
+	}
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"time":      "2022-06-01T12:00:00Z",
+		"level":     "warn",
+		"msg":       "request",
+		"requestId": "request-1",
+		"method":    "GET",
+		"route":     "/profile/{id}",
+		"path":      "/profile/7",
+		"status":    float64(404),
+		"latencyMs": 1.5,
+		"bytes":     float64(len("no such profile")),
+		"userId":    "alice",
+		"clientIp":  "203.0.113.5",
+		"traceId":   testTraceId,
+		"backends":  []interface{}{"profile", "post"},
+	}
+	if !reflect.DeepEqual(line, want) {
+		t.Errorf("got %v, want %v", line, want)
+	}
+}
+
+func TestAccessLogLevels(t *testing.T) {
+	statuses := []int{http.StatusOK, http.StatusFound, http.StatusBadRequest, http.StatusServiceUnavailable}
+	tests := []struct {
+		level string
+		want  []string
+	}{
+		{LevelDebug, []string{"info", "info", "warn", "error"}},
+		{LevelInfo, []string{"info", "info", "warn", "error"}},
+		{LevelWarn, []string{"warn", "error"}},
+		{LevelError, []string{"error"}},
+	}
+	for _, test := range tests {
+		t.Run(test.level, func(t *testing.T) {
+			accessLog, out := newTestAccessLog(t, test.level, 1)
+			for _, status := range statuses {
+				status := status
+				handler := accessLog.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(status)
+				}))
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/post", nil))
+			}
+			got := make([]string, 0)
+			decoder := json.NewDecoder(out)
+			for decoder.More() {
+				var line accessLogLine
+				if err := decoder.Decode(&line); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, line.Level)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got levels %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAccessLogSamplesSuccesses(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     float64
+		min, max int
+	}{
+		{"none", 0, 0, 0},
+		{"half", 0.5, 800, 1200},
+		{"all", 1, 2000, 2000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			accessLog, out := newTestAccessLog(t, LevelInfo, test.rate)
+			ok := accessLog.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			failed := accessLog.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			for i := 0; i < 2000; i++ {
+				ok.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/post", nil))
+			}
+			successes := strings.Count(out.String(), "\n")
+			if successes < test.min || successes > test.max {
+				t.Errorf("logged %d of 2000 successes, want %d to %d", successes, test.min, test.max)
+			}
+			for i := 0; i < 10; i++ {
+				failed.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/post", nil))
+			}
+			if failures := strings.Count(out.String(), "\n") - successes; failures != 10 {
+				t.Errorf("logged %d of 10 failures, want all", failures)
+			}
+		})
+	}
+}
+
+func TestNewAccessLogRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		config AccessLogConfig
+	}{
+		{"unknown level", AccessLogConfig{Level: "trace", SuccessSampleRate: 1}},
+		{"sample rate above 1", AccessLogConfig{Level: LevelInfo, SuccessSampleRate: 1.5}},
+		{"negative sample rate", AccessLogConfig{Level: LevelInfo, SuccessSampleRate: -0.1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewAccessLog(test.config); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
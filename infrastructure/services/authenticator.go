@@ -0,0 +1,161 @@
+package services
+
+import (
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	TokenSourceCookie = "cookie"
+	TokenSourceBearer = "bearer"
+)
+
+// CSRFCheck reports whether a cookie-authenticated request proves it was
+// sent by our own frontend.
+type CSRFCheck func(r *http.Request) bool
+
+type AuthenticatorConfig struct {
+	// TokenSources lists where to look for a token, in order of precedence.
+	// The first source that carries a token decides the outcome.
+	TokenSources []string
+	CookieName   string
+	// CookieRequiresCSRF rejects cookie authentication on state-changing
+	// methods unless CSRFCheck passes.
+	CookieRequiresCSRF bool
+	CSRFCheck          CSRFCheck
+	Realm              string
+	// Revocations, when set, is consulted for every verified token.
+	Revocations RevocationStore
+}
+
+type Authenticator struct {
+	verifier *TokenVerifier
+	config   AuthenticatorConfig
+}
+
+func NewAuthenticator(verifier *TokenVerifier, config AuthenticatorConfig) (*Authenticator, error) {
+	for _, source := range config.TokenSources {
+		if source != TokenSourceCookie && source != TokenSourceBearer {
+			return nil, fmt.Errorf("unknown token source %q", source)
+		}
+	}
+	if len(config.TokenSources) == 0 {
+		return nil, fmt.Errorf("at least one token source is required")
+	}
+	return &Authenticator{verifier: verifier, config: config}, nil
+}
+
+// authError follows RFC 6750: Code is the "error" attribute of the
+// WWW-Authenticate challenge and is left empty when no credentials were sent.
+type authError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (authenticator *Authenticator) authenticate(r *http.Request) (*Principal, *authError) {
+	source := credentialSource(r, authenticator.config.TokenSources, authenticator.config.CookieName)
+	if source == "" {
+		return nil, &authError{status: http.StatusUnauthorized, message: "authentication required"}
+	}
+	token, authErr := authenticator.tokenFrom(r, source)
+	if authErr != nil {
+		return nil, authErr
+	}
+	if source == TokenSourceCookie && authenticator.config.CookieRequiresCSRF && isStateChanging(r.Method) {
+		if authenticator.config.CSRFCheck == nil || !authenticator.config.CSRFCheck(r) {
+			return nil, &authError{status: http.StatusForbidden, code: "invalid_request", message: "cookie authentication requires a valid CSRF token"}
+		}
+	}
+	claims, err := authenticator.verifier.Verify(r.Context(), token)
+	if err != nil {
+		return nil, &authError{status: http.StatusUnauthorized, code: "invalid_token", message: err.Error()}
+	}
+	principal := newPrincipal(claims)
+	principal.Source = source
+	if authErr := authenticator.checkRevoked(r, principal); authErr != nil {
+		return nil, authErr
+	}
+	return principal, nil
+}
+
+// credentialSource returns the first of sources that r carries a credential
+// in, or "" when it carries none. The first source present decides the
+// outcome, so this is the credential a request is authenticated by.
+func credentialSource(r *http.Request, sources []string, cookieName string) string {
+	for _, source := range sources {
+		switch source {
+		case TokenSourceCookie:
+			if cookie, err := r.Cookie(cookieName); err == nil && cookie.Value != "" {
+				return source
+			}
+		case TokenSourceBearer:
+			if r.Header.Get("Authorization") != "" {
+				return source
+			}
+		}
+	}
+	return ""
+}
+
+// checkRevoked fails closed: when the store cannot answer, the request is
+// rejected rather than letting a possibly revoked token through.
+func (authenticator *Authenticator) checkRevoked(r *http.Request, principal *Principal) *authError {
+	if authenticator.config.Revocations == nil {
+		return nil
+	}
+	// A token without "iat" has a zero IssuedAt and so counts as issued
+	// before any per-user cutoff.
+	revoked, err := authenticator.config.Revocations.IsRevoked(r.Context(), principal.TokenId, principal.Id, principal.IssuedAt)
+	if err != nil {
+		log.Printf("revocation check failed: %v", err)
+		return &authError{status: http.StatusServiceUnavailable, message: "cannot verify token revocation"}
+	}
+	if revoked {
+		return &authError{status: http.StatusUnauthorized, code: "invalid_token", message: "token has been revoked"}
+	}
+	return nil
+}
+
+// tokenFrom reads the token of a source credentialSource found.
+func (authenticator *Authenticator) tokenFrom(r *http.Request, source string) (string, *authError) {
+	if source == TokenSourceCookie {
+		cookie, _ := r.Cookie(authenticator.config.CookieName)
+		return cookie.Value, nil
+	}
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", &authError{status: http.StatusBadRequest, code: "invalid_request", message: "malformed Authorization header"}
+	}
+	return strings.TrimSpace(token), nil
+}
+
+func (authenticator *Authenticator) writeError(w http.ResponseWriter, r *http.Request, authErr *authError) {
+	challenge := fmt.Sprintf("Bearer realm=%q", authenticator.config.Realm)
+	if authErr.code != "" {
+		challenge += fmt.Sprintf(", error=%q, error_description=%q", authErr.code, authErr.message)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+
+	code := codes.Unauthenticated
+	switch authErr.status {
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	}
+	WriteStatus(w, r, code, authErr.message)
+}
+
+func isStateChanging(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestAuthenticator(t *testing.T, config AuthenticatorConfig) *Authenticator {
+	t.Helper()
+	verifier, err := NewTokenVerifier(VerifierConfig{Algorithms: []string{"HS256"}, Secret: []byte(testSecret)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CookieName = "token"
+	config.Realm = "dislinkt"
+	authenticator, err := NewAuthenticator(verifier, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authenticator
+}
+
+func TestAuthenticatorTokenSources(t *testing.T) {
+	alice, bob := testToken(t, "alice"), testToken(t, "bob")
+	bearerFirst := []string{TokenSourceBearer, TokenSourceCookie}
+	cookieFirst := []string{TokenSourceCookie, TokenSourceBearer}
+	tests := []struct {
+		name          string
+		sources       []string
+		authorization string
+		cookie        string
+		id            string
+		source        string
+		status        int
+	}{
+		{"bearer only", bearerFirst, "Bearer " + alice, "", "alice", TokenSourceBearer, 0},
+		{"cookie only", bearerFirst, "", bob, "bob", TokenSourceCookie, 0},
+		{"bearer before cookie", bearerFirst, "Bearer " + alice, bob, "alice", TokenSourceBearer, 0},
+		{"cookie before bearer", cookieFirst, "Bearer " + alice, bob, "bob", TokenSourceCookie, 0},
+		{"lower case scheme", bearerFirst, "bearer " + alice, "", "alice", TokenSourceBearer, 0},
+		{"malformed header is not skipped for the cookie", bearerFirst, "Token " + alice, bob, "", "", http.StatusBadRequest},
+		{"malformed header behind a cookie", cookieFirst, "Token " + alice, bob, "bob", TokenSourceCookie, 0},
+		{"invalid first token is not skipped", bearerFirst, "Bearer " + alice + "x", bob, "", "", http.StatusUnauthorized},
+		{"source not enabled", []string{TokenSourceBearer}, "", bob, "", "", http.StatusUnauthorized},
+		{"no token", bearerFirst, "", "", "", "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authenticator := newTestAuthenticator(t, AuthenticatorConfig{TokenSources: test.sources})
+			request := httptest.NewRequest("GET", "/profile", nil)
+			if test.authorization != "" {
+				request.Header.Set("Authorization", test.authorization)
+			}
+			if test.cookie != "" {
+				request.AddCookie(&http.Cookie{Name: "token", Value: test.cookie})
+			}
+			principal, authErr := authenticator.authenticate(request)
+			if test.status != 0 {
+				if authErr == nil || authErr.status != test.status {
+					t.Fatalf("got error %+v, want status %d", authErr, test.status)
+				}
+				return
+			}
+			if authErr != nil {
+				t.Fatalf("got error %+v", authErr)
+			}
+			if principal.Id != test.id || principal.Source != test.source {
+				t.Errorf("got %s from %s, want %s from %s", principal.Id, principal.Source, test.id, test.source)
+			}
+		})
+	}
+}
+
+func TestAuthenticatorChallenge(t *testing.T) {
+	csrfPasses := false
+	authenticator := newTestAuthenticator(t, AuthenticatorConfig{
+		TokenSources:       []string{TokenSourceBearer, TokenSourceCookie},
+		CookieRequiresCSRF: true,
+		CSRFCheck:          func(*http.Request) bool { return csrfPasses },
+	})
+	table := NewPolicyTable(nil, Authenticated, authenticator)
+	handler := table.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		cookie        string
+		csrf          bool
+		status        int
+		challenge     string
+	}{
+		{"no credentials", "GET", "", "", false, http.StatusUnauthorized, `Bearer realm="dislinkt"`},
+		{"malformed header", "GET", "Basic dXNlcjpwYXNz", "", false, http.StatusBadRequest, `Bearer realm="dislinkt", error="invalid_request", error_description="malformed Authorization header"`},
+		{"scheme without token", "GET", "Bearer ", "", false, http.StatusBadRequest, `Bearer realm="dislinkt", error="invalid_request", error_description="malformed Authorization header"`},
+		{"bad signature", "GET", "Bearer " + testToken(t, "alice") + "x", "", false, http.StatusUnauthorized, `Bearer realm="dislinkt", error="invalid_token", error_description="signature is invalid"`},
+		{"cookie without CSRF token", "POST", "", testToken(t, "alice"), false, http.StatusForbidden, `Bearer realm="dislinkt", error="invalid_request", error_description="cookie authentication requires a valid CSRF token"`},
+		{"cookie with CSRF token", "POST", "", testToken(t, "alice"), true, http.StatusOK, ""},
+		{"cookie on a safe method", "GET", "", testToken(t, "alice"), false, http.StatusOK, ""},
+		{"bearer needs no CSRF token", "POST", "Bearer " + testToken(t, "alice"), "", false, http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			csrfPasses = test.csrf
+			request := httptest.NewRequest(test.method, "/profile", nil)
+			if test.authorization != "" {
+				request.Header.Set("Authorization", test.authorization)
+			}
+			if test.cookie != "" {
+				request.AddCookie(&http.Cookie{Name: "token", Value: test.cookie})
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Fatalf("got status %d, want %d: %s", recorder.Code, test.status, recorder.Body)
+			}
+			if got := recorder.Header().Get("WWW-Authenticate"); got != test.challenge {
+				t.Errorf("got challenge %s, want %s", got, test.challenge)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (state BreakerState) String() string {
+	switch state {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the
+	// breaker; 0 disables it.
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before letting trial
+	// requests through.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of concurrent trial requests; the
+	// breaker closes once that many succeed.
+	HalfOpenRequests int
+}
+
+// CircuitBreaker stops calls to a backend that keeps failing, so requests
+// fail fast instead of waiting on a dead service.
+type CircuitBreaker struct {
+	name      string
+	config    BreakerConfig
+	gauge     *prometheus.GaugeVec
+	mutex     sync.Mutex
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	inFlight  int
+	successes int
+	// generation changes with every transition, so Done can tell calls
+	// that started in an earlier state.
+	generation uint64
+	now        func() time.Time
+}
+
+// NewCircuitBreaker creates a closed breaker. gauge reports the state of
+// every breaker by the label "service".
+func NewCircuitBreaker(name string, config BreakerConfig, gauge *prometheus.GaugeVec) *CircuitBreaker {
+	if config.HalfOpenRequests <= 0 {
+		config.HalfOpenRequests = 1
+	}
+	breaker := &CircuitBreaker{name: name, config: config, gauge: gauge, now: time.Now}
+	gauge.WithLabelValues(name).Set(float64(BreakerClosed))
+	return breaker
+}
+
+// Allow reserves a call and returns the generation it started in. Every
+// successful Allow must be followed by Done with that generation.
+func (breaker *CircuitBreaker) Allow() (uint64, error) {
+	if breaker.config.FailureThreshold <= 0 {
+		return 0, nil
+	}
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	switch breaker.state {
+	case BreakerOpen:
+		if breaker.now().Sub(breaker.openedAt) < breaker.config.OpenTimeout {
+			return 0, ErrCircuitOpen
+		}
+		breaker.transition(BreakerHalfOpen)
+		fallthrough
+	case BreakerHalfOpen:
+		if breaker.inFlight >= breaker.config.HalfOpenRequests {
+			return 0, ErrCircuitOpen
+		}
+		breaker.inFlight++
+	}
+	return breaker.generation, nil
+}
+
+// Done records the outcome of a call reserved by Allow. Calls that started
+// before the last transition are ignored: a slow call from the closed state
+// is no trial, and a trial of an earlier half-open round no longer holds a
+// slot.
+func (breaker *CircuitBreaker) Done(generation uint64, success bool) {
+	if breaker.config.FailureThreshold <= 0 {
+		return
+	}
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	if generation != breaker.generation {
+		return
+	}
+	switch breaker.state {
+	case BreakerClosed:
+		if success {
+			breaker.failures = 0
+			return
+		}
+		breaker.failures++
+		if breaker.failures >= breaker.config.FailureThreshold {
+			breaker.transition(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		breaker.inFlight--
+		if !success {
+			breaker.transition(BreakerOpen)
+			return
+		}
+		breaker.successes++
+		if breaker.successes >= breaker.config.HalfOpenRequests {
+			breaker.transition(BreakerClosed)
+		}
+	}
+}
+
+// transition changes the state; callers hold the mutex.
+func (breaker *CircuitBreaker) transition(state BreakerState) {
+	log.Printf("circuit breaker for %s service: %s -> %s", breaker.name, breaker.state, state)
+	breaker.state = state
+	breaker.generation++
+	breaker.failures = 0
+	breaker.successes = 0
+	breaker.inFlight = 0
+	if state == BreakerOpen {
+		breaker.openedAt = breaker.now()
+	}
+	breaker.gauge.WithLabelValues(breaker.name).Set(float64(state))
+}
+
+// OpenError is the status returned while the breaker rejects calls.
+func (breaker *CircuitBreaker) OpenError() error {
+	return status.Error(codes.Unavailable, fmt.Sprintf("%s service is unavailable: %v", breaker.name, ErrCircuitOpen))
+}
+
+// UnaryClientInterceptor guards calls on a connection. Only errors that
+// point at an unhealthy backend count as failures; a NotFound or
+// InvalidArgument answer proves the service is up.
+func (breaker *CircuitBreaker) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		generation, err := breaker.Allow()
+		if err != nil {
+			return breaker.OpenError()
+		}
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		breaker.Done(generation, !backendFailure(ctx, err))
+		return err
+	}
+}
+
+// backendFailure reports whether a call failed because of the backend. A
+// timeout counts even when the deadline was the caller's, because that is
+// how a hanging backend fails. Only a caller that hung up proves nothing.
+func backendFailure(ctx context.Context, err error) bool {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown:
+		return true
+	}
+	return false
+}
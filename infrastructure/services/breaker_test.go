@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBackendFailure(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		err     error
+		failure bool
+	}{
+		{"success", context.Background(), nil, false},
+		{"not found", context.Background(), status.Error(codes.NotFound, "no post"), false},
+		{"invalid argument", context.Background(), status.Error(codes.InvalidArgument, "bad id"), false},
+		{"unavailable", context.Background(), status.Error(codes.Unavailable, "connection refused"), true},
+		{"internal", context.Background(), status.Error(codes.Internal, "panic"), true},
+		{"backend deadline", context.Background(), status.Error(codes.DeadlineExceeded, "slow"), true},
+		{"caller deadline", expired, status.Error(codes.DeadlineExceeded, "slow"), true},
+		{"plain context deadline", expired, context.DeadlineExceeded, true},
+		{"caller hung up", canceled, status.Error(codes.Canceled, "canceled"), false},
+		{"caller hung up while backend was down", canceled, status.Error(codes.Unavailable, "connection refused"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if failure := backendFailure(test.ctx, test.err); failure != test.failure {
+				t.Errorf("got %v, want %v", failure, test.failure)
+			}
+		})
+	}
+}
+
+func TestBreakerOpensOnTimeouts(t *testing.T) {
+	tests := []struct {
+		name  string
+		ctx   func() (context.Context, context.CancelFunc)
+		state BreakerState
+	}{
+		{"hanging backend times out", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 10*time.Millisecond)
+		}, BreakerOpen},
+		{"caller cancels", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				cancel()
+			}()
+			return ctx, cancel
+		}, BreakerClosed},
+	}
+	// hang blocks like a backend that never answers.
+	hang := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		<-ctx.Done()
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "state"}, []string{"service"})
+			breaker := NewCircuitBreaker("post", BreakerConfig{FailureThreshold: 3, OpenTimeout: time.Minute}, gauge)
+			interceptor := breaker.UnaryClientInterceptor()
+			for i := 0; i < 3; i++ {
+				ctx, cancel := test.ctx()
+				interceptor(ctx, "/post.PostService/Get", nil, nil, nil, hang)
+				cancel()
+			}
+			if breaker.state != test.state {
+				t.Fatalf("got state %s, want %s", breaker.state, test.state)
+			}
+			if test.state == BreakerOpen {
+				err := interceptor(context.Background(), "/post.PostService/Get", nil, nil, nil, hang)
+				_, allowErr := breaker.Allow()
+				if status.Code(err) != codes.Unavailable || !errors.Is(allowErr, ErrCircuitOpen) {
+					t.Errorf("open breaker let the call through: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// TestBreakerIgnoresCallsFromEarlierStates replays calls that outlive the
+// state they started in.
+func TestBreakerIgnoresCallsFromEarlierStates(t *testing.T) {
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "state"}, []string{"service"})
+	breaker := NewCircuitBreaker("post", BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenRequests: 2}, gauge)
+	now := time.Unix(1700000000, 0)
+	breaker.now = func() time.Time { return now }
+	allow := func() uint64 {
+		t.Helper()
+		generation, err := breaker.Allow()
+		if err != nil {
+			t.Fatalf("call rejected in state %s: %v", breaker.state, err)
+		}
+		return generation
+	}
+	expect := func(step string, state BreakerState, inFlight int) {
+		t.Helper()
+		if breaker.state != state || breaker.inFlight != inFlight {
+			t.Fatalf("%s: got %s with %d trials in flight, want %s with %d", step, breaker.state, breaker.inFlight, state, inFlight)
+		}
+	}
+
+	slowSuccess, slowFailure := allow(), allow()
+	breaker.Done(allow(), false)
+	expect("failure while closed", BreakerOpen, 0)
+
+	now = now.Add(time.Minute)
+	first, second := allow(), allow()
+	breaker.Done(slowSuccess, true)
+	breaker.Done(slowFailure, false)
+	expect("slow calls from the closed state end", BreakerHalfOpen, 2)
+	if _, err := breaker.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v for a third trial, want %v", err, ErrCircuitOpen)
+	}
+
+	breaker.Done(first, false)
+	expect("failed trial", BreakerOpen, 0)
+	now = now.Add(time.Minute)
+	third := allow()
+	breaker.Done(second, true)
+	expect("trial of the previous round ends", BreakerHalfOpen, 1)
+
+	fourth := allow()
+	if _, err := breaker.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v for a third trial, want %v", err, ErrCircuitOpen)
+	}
+	breaker.Done(third, true)
+	breaker.Done(fourth, true)
+	expect("trials of this round succeed", BreakerClosed, 0)
+}
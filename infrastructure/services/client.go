@@ -1,40 +1,121 @@
 package services
 
 import (
-	"log"
+	"fmt"
+	"time"
 
 	connection "github.com/XWS-DISLINKT/dislinkt/common/proto/connection-service"
 	post "github.com/XWS-DISLINKT/dislinkt/common/proto/post-service"
 	profile "github.com/XWS-DISLINKT/dislinkt/common/proto/profile-service"
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
 )
 
-func NewProfileClient(address string) profile.ProfileServiceClient {
-	conn, err := getConnection(address)
-	if err != nil {
-		log.Fatalf("Failed to start gRPC connection to profile service: %v", err)
-	}
-	return profile.NewProfileServiceClient(conn)
+// Clients holds one long-lived replica pool per backend service. It is built
+// once at startup and shared by every handler.
+type Clients struct {
+	ProfilePool    *Pool
+	PostPool       *Pool
+	ConnectionPool *Pool
+	Profile        profile.ProfileServiceClient
+	Post           post.PostServiceClient
+	Connection     connection.ConnectionServiceClient
 }
 
-func ConnectionsClient(address string) connection.ConnectionServiceClient {
-	conn, err := getConnection(address)
-	if err != nil {
-		log.Fatalf("Failed to start gRPC connection to profile service: %v", err)
-	}
-	return connection.NewConnectionServiceClient(conn)
+type ClientsConfig struct {
+	ProfileAddresses    []string
+	PostAddresses       []string
+	ConnectionAddresses []string
+	ProfileTLS          ClientTLS
+	PostTLS             ClientTLS
+	ConnectionTLS       ClientTLS
+	Balancer            string
+	Outlier             OutlierConfig
+	DNSRefresh          time.Duration
+	Retry               RetryPolicy
+	RetryMetrics        RetryMetrics
+	Metrics             ClientMetrics
+	Tracer              opentracing.Tracer
+	// Otel replaces Tracer for the client spans when set.
+	Otel    *OtelTracing
+	Breaker BreakerConfig
+	// BreakerState is the gauge shared by every breaker of the gateway.
+	BreakerState *prometheus.GaugeVec
 }
 
-func NewPostClient(address string) post.PostServiceClient {
-	conn, err := getConnection(address)
+func NewClients(config ClientsConfig) (*Clients, error) {
+	clients := &Clients{}
+	var err error
+	clients.ProfilePool, err = newPool("profile", config.ProfileAddresses, config.ProfileTLS, config)
 	if err != nil {
-		log.Fatalf("Failed to start gRPC connection to post service: %v", err)
+		clients.Close()
+		return nil, err
 	}
-	return post.NewPostServiceClient(conn)
+	clients.PostPool, err = newPool("post", config.PostAddresses, config.PostTLS, config)
+	if err != nil {
+		clients.Close()
+		return nil, err
+	}
+	clients.ConnectionPool, err = newPool("connection", config.ConnectionAddresses, config.ConnectionTLS, config)
+	if err != nil {
+		clients.Close()
+		return nil, err
+	}
+	clients.Profile = profile.NewProfileServiceClient(clients.ProfilePool)
+	clients.Post = post.NewPostServiceClient(clients.PostPool)
+	clients.Connection = connection.NewConnectionServiceClient(clients.ConnectionPool)
+	return clients, nil
+}
 
+// Close closes every pool that was opened and returns the first error.
+func (clients *Clients) Close() error {
+	var firstErr error
+	for _, pool := range []*Pool{clients.ProfilePool, clients.PostPool, clients.ConnectionPool} {
+		if pool == nil {
+			continue
+		}
+		if err := pool.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
-func getConnection(address string) (*grpc.ClientConn, error) {
-	return grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// newPool builds the replica pool of one service. The breaker sits outside
+// the retries so a retried call counts once, and both see the logical call
+// so a retry may go to a different replica. Tracing and metrics sit inside
+// and observe every attempt.
+func newPool(service string, addresses []string, tls ClientTLS, config ClientsConfig) (*Pool, error) {
+	transportCredentials, err := tls.TransportCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("%s service TLS: %w", service, err)
+	}
+	tracing := TracingInterceptor(config.Tracer, service)
+	if config.Otel != nil {
+		tracing = config.Otel.UnaryClientInterceptor(service)
+	}
+	return NewPool(PoolConfig{
+		Name:       service,
+		Addresses:  addresses,
+		Balancer:   config.Balancer,
+		Outlier:    config.Outlier,
+		DNSRefresh: config.DNSRefresh,
+		DialOptions: []grpc.DialOption{
+			grpc.WithTransportCredentials(transportCredentials),
+			// Pings only go out while calls are in flight and no more often
+			// than the default server enforcement policy allows.
+			grpc.WithKeepaliveParams(keepalive.ClientParameters{
+				Time:    5 * time.Minute,
+				Timeout: 20 * time.Second,
+			}),
+		},
+		Interceptors: []grpc.UnaryClientInterceptor{
+			NewCircuitBreaker(service, config.Breaker, config.BreakerState).UnaryClientInterceptor(),
+			RetryInterceptor(service, config.Retry, config.RetryMetrics),
+			tracing,
+			config.Metrics.UnaryClientInterceptor(service),
+		},
+	})
 }
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+type CSRFConfig struct {
+	// Secret signs issued tokens so a cookie planted by a sibling domain
+	// cannot be paired with a forged header. All replicas must share it.
+	Secret     []byte
+	CookieName string
+	HeaderName string
+	// TokenSources and AuthCookieName are those of the authenticator. Only
+	// requests it authenticates by the cookie need CSRF protection.
+	TokenSources   []string
+	AuthCookieName string
+	// ExemptPaths are exact paths, or prefixes when they end in "*".
+	ExemptPaths  []string
+	CookieSecure bool
+}
+
+// CSRFProtector implements the signed double-submit cookie pattern: the
+// token is set as a cookie and must be echoed back in a header on
+// state-changing requests.
+type CSRFProtector struct {
+	config CSRFConfig
+}
+
+func NewCSRFProtector(config CSRFConfig) (*CSRFProtector, error) {
+	// A secret per process would break tokens across replicas and restarts.
+	if len(config.Secret) == 0 {
+		return nil, errors.New("a CSRF secret shared by all gateway replicas is required")
+	}
+	return &CSRFProtector{config: config}, nil
+}
+
+// Issue creates a fresh token, sets it as a cookie and returns it.
+func (protector *CSRFProtector) Issue(w http.ResponseWriter) (string, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	encodedNonce := base64.RawURLEncoding.EncodeToString(nonce)
+	token := encodedNonce + "." + protector.sign(encodedNonce)
+	http.SetCookie(w, &http.Cookie{
+		Name:     protector.config.CookieName,
+		Value:    token,
+		Path:     "/",
+		Secure:   protector.config.CookieSecure,
+		SameSite: http.SameSiteStrictMode,
+		// Readable by the frontend so it can copy the value into the header.
+		HttpOnly: false,
+	})
+	return token, nil
+}
+
+// Valid reports whether the header token matches the cookie token and
+// carries our signature.
+func (protector *CSRFProtector) Valid(r *http.Request) bool {
+	header := r.Header.Get(protector.config.HeaderName)
+	cookie, err := r.Cookie(protector.config.CookieName)
+	if header == "" || err != nil || cookie.Value == "" {
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 {
+		return false
+	}
+	nonce, signature, found := strings.Cut(header, ".")
+	if !found {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(protector.sign(nonce)))
+}
+
+// Middleware rejects cookie-authenticated state-changing requests that do
+// not carry a valid token. Requests authenticated by a bearer token cannot
+// be forged cross-site and pass through.
+func (protector *CSRFProtector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if protector.requiresToken(r) && !protector.Valid(r) {
+			writeForbidden(w, r, "missing or invalid CSRF token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (protector *CSRFProtector) requiresToken(r *http.Request) bool {
+	if !isStateChanging(r.Method) {
+		return false
+	}
+	if credentialSource(r, protector.config.TokenSources, protector.config.AuthCookieName) != TokenSourceCookie {
+		return false
+	}
+	for _, exempt := range protector.config.ExemptPaths {
+		if prefix := strings.TrimSuffix(exempt, "*"); prefix != exempt {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				return false
+			}
+		} else if r.URL.Path == exempt {
+			return false
+		}
+	}
+	return true
+}
+
+func (protector *CSRFProtector) sign(nonce string) string {
+	mac := hmac.New(sha256.New, protector.config.Secret)
+	mac.Write([]byte(nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
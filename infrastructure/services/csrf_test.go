@@ -0,0 +1,93 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewCSRFProtectorRequiresSecret(t *testing.T) {
+	if _, err := NewCSRFProtector(CSRFConfig{CookieName: "XSRF-TOKEN", HeaderName: "X-XSRF-TOKEN"}); err == nil {
+		t.Error("protector without a secret was created")
+	}
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	newProtector := func(t *testing.T, secret string, sources ...string) *CSRFProtector {
+		protector, err := NewCSRFProtector(CSRFConfig{
+			Secret:         []byte(secret),
+			CookieName:     "XSRF-TOKEN",
+			HeaderName:     "X-XSRF-TOKEN",
+			TokenSources:   sources,
+			AuthCookieName: "token",
+			ExemptPaths:    []string{"/login", "/public/*"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return protector
+	}
+	issue := func(t *testing.T, protector *CSRFProtector) string {
+		recorder := httptest.NewRecorder()
+		token, err := protector.Issue(recorder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	cookieFirst := newProtector(t, "gateway-secret", TokenSourceCookie, TokenSourceBearer)
+	bearerFirst := newProtector(t, "gateway-secret", TokenSourceBearer, TokenSourceCookie)
+	valid := issue(t, cookieFirst)
+	// A token signed with another key, as planted by a sibling domain.
+	forged := issue(t, newProtector(t, "attacker-secret", TokenSourceCookie))
+
+	tests := []struct {
+		name          string
+		protector     *CSRFProtector
+		method        string
+		path          string
+		authCookie    bool
+		authorization string
+		csrfCookie    string
+		csrfHeader    string
+		status        int
+	}{
+		{"cookie auth without token", cookieFirst, "POST", "/post", true, "", "", "", http.StatusForbidden},
+		{"cookie auth with valid token", cookieFirst, "POST", "/post", true, "", valid, valid, http.StatusOK},
+		{"cookie auth with forged token", cookieFirst, "POST", "/post", true, "", forged, forged, http.StatusForbidden},
+		{"cookie auth with header not matching cookie", cookieFirst, "PUT", "/profile/1", true, "", valid, issue(t, cookieFirst), http.StatusForbidden},
+		{"cookie auth with header only", cookieFirst, "DELETE", "/post/1", true, "", "", valid, http.StatusForbidden},
+		{"safe method", cookieFirst, "GET", "/post", true, "", "", "", http.StatusOK},
+		{"exempt path", cookieFirst, "POST", "/login", true, "", "", "", http.StatusOK},
+		{"exempt prefix", cookieFirst, "POST", "/public/upload", true, "", "", "", http.StatusOK},
+		{"no credentials", cookieFirst, "POST", "/post", false, "", "", "", http.StatusOK},
+		{"bearer auth", cookieFirst, "POST", "/post", false, "Bearer abc", "", "", http.StatusOK},
+		// The cookie wins, so an Authorization header must not lift the check.
+		{"cookie wins over bearer", cookieFirst, "POST", "/post", true, "Bearer abc", "", "", http.StatusForbidden},
+		{"bearer wins over cookie", bearerFirst, "POST", "/post", true, "Bearer abc", "", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := test.protector.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			request := httptest.NewRequest(test.method, test.path, nil)
+			request.Header.Set("Origin", "https://evil.example")
+			if test.authCookie {
+				request.AddCookie(&http.Cookie{Name: "token", Value: "jwt"})
+			}
+			if test.authorization != "" {
+				request.Header.Set("Authorization", test.authorization)
+			}
+			if test.csrfCookie != "" {
+				request.AddCookie(&http.Cookie{Name: "XSRF-TOKEN", Value: test.csrfCookie})
+			}
+			if test.csrfHeader != "" {
+				request.Header.Set("X-XSRF-TOKEN", test.csrfHeader)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Errorf("got status %d, want %d", recorder.Code, test.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/metadata"
+)
+
+// RouteTimeout bounds how long a route may spend on its backend calls.
+type RouteTimeout struct {
+	Method  string
+	Pattern string
+	Timeout time.Duration
+}
+
+// ParseRouteTimeout reads "<method> <pattern> <timeout>", for example
+// "GET /post/job/{search} 3s".
+func ParseRouteTimeout(value string) (RouteTimeout, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 {
+		return RouteTimeout{}, fmt.Errorf("route timeout %q: want \"<method> <pattern> <timeout>\"", value)
+	}
+	timeout, err := time.ParseDuration(fields[2])
+	if err != nil || timeout <= 0 {
+		return RouteTimeout{}, fmt.Errorf("route timeout %q: invalid duration %q", value, fields[2])
+	}
+	return RouteTimeout{Method: strings.ToUpper(fields[0]), Pattern: fields[1], Timeout: timeout}, nil
+}
+
+type compiledTimeout struct {
+	RouteTimeout
+	segments []string
+}
+
+// Deadlines gives every request a context that ends with the client
+// connection or the route timeout, whichever comes first, and carries the
+// metadata forwarded to backends.
+type Deadlines struct {
+	timeouts       []compiledTimeout
+	defaultTimeout time.Duration
+}
+
+func NewDeadlines(timeouts []RouteTimeout, defaultTimeout time.Duration) *Deadlines {
+	deadlines := &Deadlines{
+		timeouts:       make([]compiledTimeout, 0, len(timeouts)),
+		defaultTimeout: defaultTimeout,
+	}
+	for _, timeout := range timeouts {
+		deadlines.timeouts = append(deadlines.timeouts, compiledTimeout{
+			RouteTimeout: timeout,
+			segments:     splitPath(timeout.Pattern),
+		})
+	}
+	return deadlines
+}
+
+func (deadlines *Deadlines) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := metadata.NewOutgoingContext(r.Context(), OutgoingMetadata(r))
+		if timeout := deadlines.timeoutFor(r.Method, r.URL.Path); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// timeoutFor picks the most specific entry the same way PolicyTable does.
+func (deadlines *Deadlines) timeoutFor(method string, path string) time.Duration {
+	segments := splitPath(path)
+	timeout := deadlines.defaultTimeout
+	bestScore := -1
+	for _, entry := range deadlines.timeouts {
+		if entry.Method != method || len(entry.segments) != len(segments) {
+			continue
+		}
+		if _, score, ok := matchSegments(entry.segments, segments); ok && score > bestScore {
+			timeout, bestScore = entry.Timeout, score
+		}
+	}
+	return timeout
+}
+
+const (
+	requestIdKey = "x-request-id"
+	userIdKey    = "x-user-id"
+	usernameKey  = "x-username"
+	userRolesKey = "x-user-roles"
+)
+
+// OutgoingMetadata is what backends learn about the caller: the request id
+// and the authenticated principal, if any. It also serves as the metadata
+// annotator of the mux so generated routes forward the same keys.
+func OutgoingMetadata(r *http.Request) metadata.MD {
+	md := metadata.MD{}
+	if id := r.Header.Get(RequestIdHeader); id != "" {
+		md.Set(requestIdKey, id)
+	}
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		md.Set(userIdKey, principal.Id)
+		md.Set(usernameKey, principal.Username)
+		if len(principal.Roles) > 0 {
+			md.Set(userRolesKey, strings.Join(principal.Roles, ","))
+		}
+	}
+	return md
+}
+
+// IncomingHeaderMatcher forwards headers to backends like the grpc-gateway
+// default, except Grpc-Metadata-* headers naming a key of OutgoingMetadata.
+// Those keys are only ever set from what the gateway verified, so a client
+// cannot pose as another user.
+func IncomingHeaderMatcher(header string) (string, bool) {
+	key, ok := runtime.DefaultHeaderMatcher(header)
+	if !ok {
+		return "", false
+	}
+	switch strings.ToLower(key) {
+	case requestIdKey, userIdKey, usernameKey, userRolesKey:
+		return "", false
+	}
+	return key, true
+}
+
+// MetadataCarrier lets an OpenTracing tracer or an OpenTelemetry propagator
+// inject span context into gRPC metadata.
+type MetadataCarrier metadata.MD
+
+func (carrier MetadataCarrier) Get(key string) string {
+	if values := metadata.MD(carrier).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (carrier MetadataCarrier) Set(key string, value string) {
+	metadata.MD(carrier).Set(key, value)
+}
+
+func (carrier MetadataCarrier) ForeachKey(handler func(key string, value string) error) error {
+	for key, values := range carrier {
+		for _, value := range values {
+			if err := handler(key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (carrier MetadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(carrier))
+	for key := range carrier {
+		keys = append(keys, key)
+	}
+	return keys
+}
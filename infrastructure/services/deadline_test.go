@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestIncomingHeaderMatcher(t *testing.T) {
+	tests := []struct {
+		header string
+		key    string
+		ok     bool
+	}{
+		{"Grpc-Metadata-X-User-Id", "", false},
+		{"Grpc-Metadata-x-username", "", false},
+		{"Grpc-Metadata-X-User-Roles", "", false},
+		{"Grpc-Metadata-X-Request-Id", "", false},
+		{"Grpc-Metadata-X-Tenant", "X-Tenant", true},
+		{"Authorization", "grpcgateway-Authorization", true},
+		{"X-User-Id", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			key, ok := IncomingHeaderMatcher(test.header)
+			if key != test.key || ok != test.ok {
+				t.Errorf("got %q, %v, want %q, %v", key, ok, test.key, test.ok)
+			}
+		})
+	}
+}
+
+func TestGeneratedRouteMetadataCannotBeSpoofed(t *testing.T) {
+	mux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(IncomingHeaderMatcher),
+		runtime.WithMetadata(func(ctx context.Context, r *http.Request) metadata.MD {
+			return OutgoingMetadata(r)
+		}),
+	)
+	tests := []struct {
+		name      string
+		principal *Principal
+		want      metadata.MD
+	}{
+		{"anonymous caller", nil, metadata.MD{"x-request-id": {"req-1"}, "x-tenant": {"acme"}}},
+		{"authenticated caller", &Principal{Id: "alice", Username: "alice", Roles: []string{"user"}}, metadata.MD{
+			"x-request-id": {"req-1"},
+			"x-tenant":     {"acme"},
+			"x-user-id":    {"alice"},
+			"x-username":   {"alice"},
+			"x-user-roles": {"user"},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/post/1", nil)
+			r.Header.Set(RequestIdHeader, "req-1")
+			r.Header.Set("Grpc-Metadata-X-Request-Id", "forged")
+			r.Header.Set("Grpc-Metadata-X-User-Id", "bob")
+			r.Header.Set("Grpc-Metadata-X-Username", "bob")
+			r.Header.Set("Grpc-Metadata-X-User-Roles", "admin")
+			r.Header.Set("Grpc-Metadata-X-Tenant", "acme")
+			if test.principal != nil {
+				r = r.WithContext(context.WithValue(r.Context(), principalKey{}, test.principal))
+			}
+			ctx, err := runtime.AnnotateContext(r.Context(), mux, r, "/post.PostService/Get")
+			if err != nil {
+				t.Fatal(err)
+			}
+			md, _ := metadata.FromOutgoingContext(ctx)
+			got := metadata.MD{}
+			for key := range test.want {
+				got[key] = md.Get(key)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+			for _, key := range []string{"x-user-id", "x-username", "x-user-roles"} {
+				if _, expected := test.want[key]; !expected && len(md.Get(key)) > 0 {
+					t.Errorf("%s forwarded as %v", key, md.Get(key))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Endpoints maps a backend name ("profile", "post", "connection") to its
+// replicas, written as in PoolConfig.Addresses.
+type Endpoints map[string][]string
+
+// DiscoveryProvider reports where the backends currently run.
+type DiscoveryProvider interface {
+	Endpoints(ctx context.Context) (Endpoints, error)
+}
+
+// FileDiscovery reads endpoints from a YAML or JSON file such as
+//
+//	profile: [profile-1:8001, profile-2:8001]
+//	post: [dns:///post:8002]
+//
+// The file is read again on every poll, so edits and atomic replacements
+// (as done for mounted ConfigMaps) are both picked up.
+type FileDiscovery struct {
+	Path string
+}
+
+func (discovery FileDiscovery) Endpoints(ctx context.Context) (Endpoints, error) {
+	content, err := os.ReadFile(discovery.Path)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := Endpoints{}
+	if err := yaml.Unmarshal(content, &endpoints); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", discovery.Path, err)
+	}
+	return endpoints, nil
+}
+
+// SRVDiscovery looks up a DNS SRV name per backend, for example
+// "_grpc._tcp.profile-service.dislinkt.svc.cluster.local". Only the records
+// of the best priority are used; the others are backups.
+type SRVDiscovery struct {
+	Names map[string]string
+	// Resolver defaults to net.DefaultResolver.
+	Resolver SRVResolver
+}
+
+// SRVResolver is the part of *net.Resolver that SRVDiscovery uses.
+type SRVResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+func (discovery SRVDiscovery) Endpoints(ctx context.Context) (Endpoints, error) {
+	var resolver SRVResolver = net.DefaultResolver
+	if discovery.Resolver != nil {
+		resolver = discovery.Resolver
+	}
+	endpoints := Endpoints{}
+	for backend, name := range discovery.Names {
+		_, records, err := resolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, fmt.Errorf("looking up %s service: %w", backend, err)
+		}
+		addresses := make([]string, 0, len(records))
+		for _, record := range records {
+			// LookupSRV sorts records by priority.
+			if record.Priority != records[0].Priority {
+				break
+			}
+			host := strings.TrimSuffix(record.Target, ".")
+			addresses = append(addresses, net.JoinHostPort(host, fmt.Sprint(record.Port)))
+		}
+		endpoints[backend] = addresses
+	}
+	return endpoints, nil
+}
+
+// Discovery re-points backend pools whenever their provider reports new
+// endpoints. Handlers and grpc-gateway registrations call through the pools
+// and so follow without being registered again.
+type Discovery struct {
+	provider DiscoveryProvider
+	interval time.Duration
+	pools    map[string]*Pool
+	// applied holds the endpoints last given to each pool; only the loop
+	// goroutine touches it after Start.
+	applied Endpoints
+	cancel  context.CancelFunc
+}
+
+func NewDiscovery(provider DiscoveryProvider, interval time.Duration, pools map[string]*Pool) *Discovery {
+	return &Discovery{
+		provider: provider,
+		interval: interval,
+		pools:    pools,
+		applied:  Endpoints{},
+	}
+}
+
+// Start applies the current endpoints and keeps polling the provider until
+// Close. A provider that fails at startup is an error; later failures keep
+// the previous endpoints.
+func (discovery *Discovery) Start() error {
+	if err := discovery.sync(context.Background()); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	discovery.cancel = cancel
+	if discovery.interval > 0 {
+		go discovery.watch(ctx)
+	}
+	return nil
+}
+
+func (discovery *Discovery) Close() {
+	if discovery.cancel != nil {
+		discovery.cancel()
+	}
+}
+
+func (discovery *Discovery) watch(ctx context.Context) {
+	ticker := time.NewTicker(discovery.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := discovery.sync(ctx); err != nil {
+				log.Printf("service discovery failed, keeping previous endpoints: %v", err)
+			}
+		}
+	}
+}
+
+// sync updates every pool whose endpoints changed. Backends the provider
+// does not list, or lists without addresses, keep their replicas.
+func (discovery *Discovery) sync(ctx context.Context) error {
+	endpoints, err := discovery.provider.Endpoints(ctx)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for backend, pool := range discovery.pools {
+		addresses := append([]string(nil), endpoints[backend]...)
+		if len(addresses) == 0 {
+			continue
+		}
+		sort.Strings(addresses)
+		if equalStrings(addresses, discovery.applied[backend]) {
+			continue
+		}
+		if err := pool.Update(ctx, addresses); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		discovery.applied[backend] = addresses
+	}
+	return firstErr
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// writeEndpoints replaces the discovery file the way a ConfigMap update
+// does, by renaming a new file over it.
+func writeEndpoints(t *testing.T, path string, content string) {
+	t.Helper()
+	next := path + ".next"
+	if err := os.WriteFile(next, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(next, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func poolAddresses(t *testing.T, pool *Pool) []string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	addresses := make([]string, 0)
+	for _, status := range pool.Probe(ctx) {
+		addresses = append(addresses, status.Address)
+	}
+	return addresses
+}
+
+func TestFileDiscoveryRepointsPools(t *testing.T) {
+	first, second, third := startStub(t), startStub(t), startStub(t)
+	pool := testPool(t, PoolConfig{Balancer: BalancerRoundRobin}, first)
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	writeEndpoints(t, path, "profile: ["+first.address+"]\n")
+
+	client := healthpb.NewHealthClient(pool)
+	discovery := NewDiscovery(FileDiscovery{Path: path}, 0, map[string]*Pool{"profile": pool})
+	if err := discovery.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer discovery.Close()
+
+	steps := []struct {
+		name    string
+		content string
+		err     bool
+		want    []*stubBackend
+	}{
+		{"replica replaced", "profile: [" + second.address + "]\n", false, []*stubBackend{second}},
+		{"replica added", "profile:\n  - " + second.address + "\n  - " + third.address + "\n", false, []*stubBackend{second, third}},
+		{"JSON file", `{"profile": ["` + third.address + `"]}`, false, []*stubBackend{third}},
+		{"backend not listed", "post: [" + first.address + "]\n", false, []*stubBackend{third}},
+		{"backend without addresses", "profile: []\n", false, []*stubBackend{third}},
+		{"broken file", "profile: [", true, []*stubBackend{third}},
+	}
+	for _, step := range steps {
+		writeEndpoints(t, path, step.content)
+		if err := discovery.sync(context.Background()); (err != nil) != step.err {
+			t.Fatalf("%s: got error %v, want error %v", step.name, err, step.err)
+		}
+		want := make([]string, 0, len(step.want))
+		for _, stub := range step.want {
+			want = append(want, stub.address)
+		}
+		sort.Strings(want)
+		if got := poolAddresses(t, pool); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: pool points at %v, want %v", step.name, got, want)
+		}
+		before := make([]int64, len(step.want))
+		for i, stub := range step.want {
+			before[i] = atomic.LoadInt64(&stub.calls)
+		}
+		for range step.want {
+			if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+		}
+		for i, stub := range step.want {
+			if atomic.LoadInt64(&stub.calls) == before[i] {
+				t.Errorf("%s: no call reached %s", step.name, stub.address)
+			}
+		}
+	}
+}
+
+func TestDiscoveryWatchesTheFile(t *testing.T) {
+	first, second := startStub(t), startStub(t)
+	pool := testPool(t, PoolConfig{Balancer: BalancerRoundRobin}, first)
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	writeEndpoints(t, path, "profile: ["+first.address+"]\n")
+
+	discovery := NewDiscovery(FileDiscovery{Path: path}, 10*time.Millisecond, map[string]*Pool{"profile": pool})
+	if err := discovery.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer discovery.Close()
+
+	writeEndpoints(t, path, "profile: ["+second.address+"]\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := poolAddresses(t, pool)
+		if reflect.DeepEqual(got, []string{second.address}) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pool still points at %v, want %s", got, second.address)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// fakeResolver answers SRV lookups from a table, sorted by priority like
+// net.Resolver.
+type fakeResolver map[string][]*net.SRV
+
+func (resolver fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	records, ok := resolver[name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return name, records, nil
+}
+
+func TestSRVDiscovery(t *testing.T) {
+	resolver := fakeResolver{
+		"_grpc._tcp.profile": {
+			{Target: "profile-1.cluster.local.", Port: 8001, Priority: 10, Weight: 50},
+			{Target: "profile-2.cluster.local.", Port: 8001, Priority: 10, Weight: 50},
+			{Target: "profile-backup.cluster.local.", Port: 8001, Priority: 20, Weight: 100},
+		},
+		"_grpc._tcp.post": {
+			{Target: "post.cluster.local.", Port: 8002, Priority: 0},
+		},
+		"_grpc._tcp.connection": {},
+	}
+	tests := []struct {
+		name  string
+		names map[string]string
+		want  Endpoints
+		err   bool
+	}{
+		{"best priority only", map[string]string{"profile": "_grpc._tcp.profile"}, Endpoints{"profile": {"profile-1.cluster.local:8001", "profile-2.cluster.local:8001"}}, false},
+		{"several backends", map[string]string{"profile": "_grpc._tcp.profile", "post": "_grpc._tcp.post"}, Endpoints{
+			"profile": {"profile-1.cluster.local:8001", "profile-2.cluster.local:8001"},
+			"post":    {"post.cluster.local:8002"},
+		}, false},
+		{"empty answer", map[string]string{"connection": "_grpc._tcp.connection"}, Endpoints{"connection": {}}, false},
+		{"unknown name", map[string]string{"post": "_grpc._tcp.missing"}, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endpoints, err := SRVDiscovery{Names: test.names, Resolver: resolver}.Endpoints(context.Background())
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v, want error %v", err, test.err)
+			}
+			if !reflect.DeepEqual(endpoints, test.want) {
+				t.Errorf("got %v, want %v", endpoints, test.want)
+			}
+		})
+	}
+}
+
+func TestSRVDiscoveryEmptyAnswerKeepsReplicas(t *testing.T) {
+	stub := startStub(t)
+	pool := testPool(t, PoolConfig{Balancer: BalancerRoundRobin}, stub)
+	resolver := fakeResolver{"_grpc._tcp.profile": {}}
+	discovery := NewDiscovery(SRVDiscovery{Names: map[string]string{"profile": "_grpc._tcp.profile"}, Resolver: resolver}, 0, map[string]*Pool{"profile": pool})
+	if err := discovery.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer discovery.Close()
+	if got := poolAddresses(t, pool); !reflect.DeepEqual(got, []string{stub.address}) {
+		t.Errorf("pool points at %v, want %s", got, stub.address)
+	}
+
+	delete(resolver, "_grpc._tcp.profile")
+	var dnsErr *net.DNSError
+	if err := discovery.sync(context.Background()); !errors.As(err, &dnsErr) {
+		t.Errorf("got %v, want the DNS error", err)
+	}
+	if got := poolAddresses(t, pool); !reflect.DeepEqual(got, []string{stub.address}) {
+		t.Errorf("pool points at %v after a failed lookup, want %s", got, stub.address)
+	}
+}
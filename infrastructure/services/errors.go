@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const RequestIdHeader = "X-Request-Id"
+
+// ErrorBody is the envelope of every error answered by the gateway. It
+// extends the body grpc-gateway renders for google.rpc.Status with the id of
+// the request, so clients see one format for every route.
+type ErrorBody struct {
+	Code      codes.Code        `json:"code"`
+	Message   string            `json:"message"`
+	Details   []json.RawMessage `json:"details"`
+	RequestId string            `json:"requestId,omitempty"`
+}
+
+// WriteError answers with err converted to a gRPC status. Errors that do not
+// carry a status map to Unknown, except context errors which keep their
+// meaning.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	s := errorStatus(err)
+	writeStatusError(w, r, runtime.HTTPStatusFromCode(s.Code()), s)
+}
+
+// WriteBackendError answers for a failed call to a backend service. Failures
+// the backend could not explain are reported as 502 Bad Gateway rather than
+// blamed on the gateway; an unreachable backend keeps 503 and a missed
+// deadline 504.
+func WriteBackendError(w http.ResponseWriter, r *http.Request, err error) {
+	s := errorStatus(err)
+	httpStatus := runtime.HTTPStatusFromCode(s.Code())
+	switch s.Code() {
+	case codes.Unknown, codes.Internal, codes.DataLoss:
+		httpStatus = http.StatusBadGateway
+	}
+	writeStatusError(w, r, httpStatus, s)
+}
+
+// WriteStatus answers with a status built from code and message.
+func WriteStatus(w http.ResponseWriter, r *http.Request, code codes.Code, message string) {
+	writeStatusError(w, r, runtime.HTTPStatusFromCode(code), status.New(code, message))
+}
+
+// GatewayErrorHandler is the runtime.ErrorHandlerFunc of the mux, so the
+// routes generated from the backend protos use the same envelope.
+func GatewayErrorHandler(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	var httpErr *runtime.HTTPStatusError
+	if errors.As(err, &httpErr) {
+		writeStatusError(w, r, httpErr.HTTPStatus, errorStatus(httpErr.Err))
+		return
+	}
+	if md, ok := runtime.ServerMetadataFromContext(ctx); ok {
+		for key, values := range md.HeaderMD {
+			for _, value := range values {
+				w.Header().Add(runtime.MetadataHeaderPrefix+key, value)
+			}
+		}
+	}
+	WriteError(w, r, err)
+}
+
+func errorStatus(err error) *status.Status {
+	if s, ok := status.FromError(err); ok {
+		return s
+	}
+	if s := status.FromContextError(err); s.Code() != codes.Unknown {
+		return s
+	}
+	return status.New(codes.Unknown, err.Error())
+}
+
+func writeStatusError(w http.ResponseWriter, r *http.Request, httpStatus int, s *status.Status) {
+	body := ErrorBody{
+		Code:      s.Code(),
+		Message:   s.Message(),
+		Details:   make([]json.RawMessage, 0),
+		RequestId: requestId(w, r),
+	}
+	for _, detail := range s.Proto().GetDetails() {
+		if encoded, err := protojson.Marshal(detail); err == nil {
+			body.Details = append(body.Details, encoded)
+		}
+	}
+	encoded, _ := json.Marshal(body)
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	w.Write(encoded)
+}
+
+func requestId(w http.ResponseWriter, r *http.Request) string {
+	if id := w.Header().Get(RequestIdHeader); id != "" {
+		return id
+	}
+	return r.Header.Get(RequestIdHeader)
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestErrorStatusMapping(t *testing.T) {
+	withDetail, err := status.New(codes.InvalidArgument, "name is required").WithDetails(wrapperspb.String("name"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		err  error
+		code codes.Code
+		// generated and custom are the HTTP statuses of a route generated
+		// from the backend protos and of a hand-written route.
+		generated int
+		custom    int
+		details   int
+	}{
+		{"not found", status.Error(codes.NotFound, "no such profile"), codes.NotFound, http.StatusNotFound, http.StatusNotFound, 0},
+		{"invalid argument", withDetail.Err(), codes.InvalidArgument, http.StatusBadRequest, http.StatusBadRequest, 1},
+		{"permission denied", status.Error(codes.PermissionDenied, "not yours"), codes.PermissionDenied, http.StatusForbidden, http.StatusForbidden, 0},
+		{"unavailable", status.Error(codes.Unavailable, "connection refused"), codes.Unavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable, 0},
+		{"deadline exceeded", status.Error(codes.DeadlineExceeded, "too slow"), codes.DeadlineExceeded, http.StatusGatewayTimeout, http.StatusGatewayTimeout, 0},
+		{"context deadline", context.DeadlineExceeded, codes.DeadlineExceeded, http.StatusGatewayTimeout, http.StatusGatewayTimeout, 0},
+		{"internal", status.Error(codes.Internal, "boom"), codes.Internal, http.StatusInternalServerError, http.StatusBadGateway, 0},
+		{"error without status", errors.New("boom"), codes.Unknown, http.StatusInternalServerError, http.StatusBadGateway, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writers := map[string]struct {
+				status int
+				write  func(w http.ResponseWriter, r *http.Request)
+			}{
+				"generated": {test.generated, func(w http.ResponseWriter, r *http.Request) {
+					GatewayErrorHandler(r.Context(), runtime.NewServeMux(), &runtime.JSONPb{}, w, r, test.err)
+				}},
+				"custom": {test.custom, func(w http.ResponseWriter, r *http.Request) {
+					WriteBackendError(w, r, test.err)
+				}},
+			}
+			for route, writer := range writers {
+				request := httptest.NewRequest("GET", "/profile/1", nil)
+				request.Header.Set(RequestIdHeader, "request-1")
+				recorder := httptest.NewRecorder()
+				writer.write(recorder, request)
+
+				if recorder.Code != writer.status {
+					t.Errorf("%s route: got status %d, want %d", route, recorder.Code, writer.status)
+				}
+				if got := recorder.Header().Get("Content-Type"); got != "application/json" {
+					t.Errorf("%s route: got content type %q", route, got)
+				}
+				var body map[string]interface{}
+				if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+					t.Fatalf("%s route: %v", route, err)
+				}
+				for _, key := range []string{"code", "message", "details", "requestId"} {
+					if _, ok := body[key]; !ok || len(body) != 4 {
+						t.Fatalf("%s route: got body %s, want code, message, details and requestId", route, recorder.Body)
+					}
+				}
+				if body["code"] != float64(test.code) || body["message"] != errorStatus(test.err).Message() || body["requestId"] != "request-1" {
+					t.Errorf("%s route: got body %s", route, recorder.Body)
+				}
+				if details := body["details"].([]interface{}); len(details) != test.details {
+					t.Errorf("%s route: got %d details, want %d", route, len(details), test.details)
+				}
+			}
+		})
+	}
+}
+
+func TestGatewayErrorHandlerHTTPStatus(t *testing.T) {
+	request := httptest.NewRequest("DELETE", "/profile/1", nil)
+	recorder := httptest.NewRecorder()
+	recorder.Header().Set(RequestIdHeader, "request-2")
+	err := &runtime.HTTPStatusError{HTTPStatus: http.StatusMethodNotAllowed, Err: status.Error(codes.Unimplemented, http.StatusText(http.StatusMethodNotAllowed))}
+	GatewayErrorHandler(request.Context(), runtime.NewServeMux(), &runtime.JSONPb{}, recorder, request, err)
+
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d, want %d", recorder.Code, http.StatusMethodNotAllowed)
+	}
+	var body ErrorBody
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	want := ErrorBody{Code: codes.Unimplemented, Message: "Method Not Allowed", Details: []json.RawMessage{}, RequestId: "request-2"}
+	if !reflect.DeepEqual(body, want) {
+		t.Errorf("got %+v, want %+v", body, want)
+	}
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// HealthCheck reports whether a dependency can currently serve requests.
+type HealthCheck func(ctx context.Context) error
+
+// GRPCHealthCheck asks the backend behind conn through the standard gRPC
+// health protocol.
+func GRPCHealthCheck(conn *grpc.ClientConn) HealthCheck {
+	client := healthpb.NewHealthClient(conn)
+	return func(ctx context.Context) error {
+		response, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			return err
+		}
+		if response.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+			return fmt.Errorf("status %s", response.GetStatus())
+		}
+		return nil
+	}
+}
+
+// TCPHealthCheck only verifies that address accepts connections. It is used
+// for services that do not speak gRPC.
+func TCPHealthCheck(address string) HealthCheck {
+	return func(ctx context.Context) error {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
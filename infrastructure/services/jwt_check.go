@@ -1,51 +1,110 @@
 package services
 
 import (
-	"github.com/dgrijalva/jwt-go"
-	"net/http"
+	"context"
+	"encoding/json"
+	"time"
 )
 
-var jwtKey = []byte("secret_key")
-var LoggedUserUsername = ""
-var LoggedUserId = ""
-
 type Claims struct {
-	Id       string `json:"id"`
-	Username string `json:"username"`
-	jwt.StandardClaims
+	Id        string   `json:"id"`
+	Username  string   `json:"username"`
+	Role      string   `json:"role,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	TokenId   string   `json:"jti,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
 }
 
-func JWTValid(w http.ResponseWriter, r *http.Request) bool {
-	c, err := r.Cookie("token")
-	if err != nil {
-		if err == http.ErrNoCookie {
-			w.WriteHeader(http.StatusUnauthorized)
-			return false
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		return false
+// Valid satisfies jwt.Claims. Time and audience checks are done by
+// TokenVerifier, which applies the configured clock skew.
+func (claims *Claims) Valid() error {
+	return nil
+}
+
+// audience accepts both forms of the "aud" claim: a single string or an
+// array of strings.
+type audience []string
+
+func (aud *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*aud = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
 	}
+	*aud = many
+	return nil
+}
 
-	tknStr := c.Value
+func (aud audience) contains(value string) bool {
+	for _, a := range aud {
+		if a == value {
+			return true
+		}
+	}
+	return false
+}
 
-	claims := &Claims{}
+// Principal is the authenticated caller of a single request.
+type Principal struct {
+	Id        string
+	Username  string
+	Roles     []string
+	TokenId   string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// Source is the credential the token came from, TokenSourceCookie or
+	// TokenSourceBearer.
+	Source string
+}
 
-	tkn, err := jwt.ParseWithClaims(tknStr, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-	if err != nil {
-		if err == jwt.ErrSignatureInvalid {
-			w.WriteHeader(http.StatusUnauthorized)
-			return false
+func (principal *Principal) HasRole(role string) bool {
+	for _, r := range principal.Roles {
+		if r == role {
+			return true
 		}
-		w.WriteHeader(http.StatusBadRequest)
-		return false
 	}
-	if !tkn.Valid {
-		w.WriteHeader(http.StatusUnauthorized)
-		return false
+	return false
+}
+
+type principalKey struct{}
+
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	setUser(ctx, principal.Id)
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the caller stored by PolicyTable.Middleware.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+func newPrincipal(claims *Claims) *Principal {
+	roles := make([]string, 0, len(claims.Roles)+1)
+	roles = append(roles, claims.Roles...)
+	if claims.Role != "" {
+		roles = append(roles, claims.Role)
+	}
+	principal := &Principal{
+		Id:       claims.Id,
+		Username: claims.Username,
+		Roles:    roles,
+		TokenId:  claims.TokenId,
+	}
+	if claims.IssuedAt != 0 {
+		principal.IssuedAt = time.Unix(claims.IssuedAt, 0)
+	}
+	if claims.ExpiresAt != 0 {
+		principal.ExpiresAt = time.Unix(claims.ExpiresAt, 0)
 	}
-	LoggedUserUsername = claims.Username
-	LoggedUserId = claims.Id
-	return true
+	return principal
 }
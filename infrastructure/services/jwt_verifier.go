@@ -0,0 +1,419 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	minRefreshInterval = 30 * time.Second
+	lookupTimeout      = 2 * time.Second
+)
+
+// publicSecret is the HMAC key the gateway and the auth service once shipped
+// with. Anyone can sign tokens with it.
+const publicSecret = "secret_key"
+
+var (
+	ErrTokenExpired     = errors.New("token is expired")
+	ErrMissingExpiry    = errors.New("token has no expiry")
+	ErrTokenNotYetValid = errors.New("token is not valid yet")
+	ErrUnknownKey       = errors.New("no key matches the token")
+)
+
+type VerifierConfig struct {
+	// Algorithms pins the accepted "alg" header values, e.g. HS256, RS256.
+	Algorithms []string
+	Secret     []byte
+	// PublicKeyFile is a PEM encoded RSA or EC public key.
+	PublicKeyFile string
+	// JWKSSource is a file path or an http(s) URL of a JWKS document.
+	JWKSSource  string
+	JWKSRefresh time.Duration
+	Issuer      string
+	Audience    string
+	ClockSkew   time.Duration
+}
+
+// TokenVerifier checks signatures against keys from configuration and an
+// optionally refreshed JWKS document. Tokens that carry a "kid" header are
+// matched against JWKS keys by id, so keys can rotate without a restart.
+type TokenVerifier struct {
+	config      VerifierConfig
+	algorithms  map[string]bool
+	static      []interface{}
+	mutex       sync.RWMutex
+	jwks        map[string]interface{}
+	refreshedAt time.Time
+	now         func() time.Time
+	cancel      context.CancelFunc
+}
+
+func NewTokenVerifier(config VerifierConfig) (*TokenVerifier, error) {
+	verifier := &TokenVerifier{
+		config:     config,
+		algorithms: map[string]bool{},
+		static:     make([]interface{}, 0),
+		jwks:       map[string]interface{}{},
+		now:        time.Now,
+	}
+	for _, algorithm := range config.Algorithms {
+		if jwt.GetSigningMethod(algorithm) == nil || algorithm == "none" {
+			return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+		}
+		verifier.algorithms[algorithm] = true
+	}
+	if len(verifier.algorithms) == 0 {
+		return nil, errors.New("at least one signing algorithm must be allowed")
+	}
+	if string(config.Secret) == publicSecret {
+		return nil, errors.New("the JWT secret is the publicly known default, configure a secret of your own")
+	}
+	if verifier.allowsHMAC() && len(config.Secret) == 0 && config.JWKSSource == "" {
+		return nil, errors.New("a JWT secret is required when an HS algorithm is allowed")
+	}
+	if len(config.Secret) > 0 {
+		verifier.static = append(verifier.static, config.Secret)
+	}
+	if config.PublicKeyFile != "" {
+		key, err := readPublicKey(config.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		verifier.static = append(verifier.static, key)
+	}
+	if config.JWKSSource != "" {
+		if err := verifier.refresh(context.Background()); err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		verifier.cancel = cancel
+		go verifier.refreshLoop(ctx)
+	}
+	if len(verifier.static) == 0 && len(verifier.jwks) == 0 {
+		return nil, errors.New("no JWT verification keys configured")
+	}
+	return verifier, nil
+}
+
+// Close stops the JWKS refresh loop.
+func (verifier *TokenVerifier) Close() error {
+	if verifier.cancel != nil {
+		verifier.cancel()
+	}
+	return nil
+}
+
+// Verify checks the signature and the registered claims of tokenString.
+// The configured keys are tried first, then the JWKS keys: the one named by
+// the "kid" header, or all of them for tokens without one. ctx bounds the
+// JWKS refresh an unknown "kid" can trigger.
+func (verifier *TokenVerifier) Verify(ctx context.Context, tokenString string) (*Claims, error) {
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, _, err := parser.ParseUnverified(tokenString, &Claims{})
+	if err != nil {
+		return nil, err
+	}
+	algorithm := token.Method.Alg()
+	if !verifier.algorithms[algorithm] {
+		return nil, fmt.Errorf("signing algorithm %q is not allowed", algorithm)
+	}
+
+	claims, err := verifyWith(parser, tokenString, algorithm, verifier.static)
+	if err != nil && verifier.config.JWKSSource != "" {
+		var jwksErr error
+		claims, jwksErr = verifyWith(parser, tokenString, algorithm, verifier.jwksKeys(ctx, token))
+		if jwksErr == nil || err == ErrUnknownKey {
+			err = jwksErr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := verifier.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verifyWith returns the claims of tokenString once one of keys verifies
+// its signature. Only keys of the type the pinned algorithm expects are
+// tried, so an RSA public key can never be used as an HMAC secret.
+func verifyWith(parser *jwt.Parser, tokenString string, algorithm string, keys []interface{}) (*Claims, error) {
+	err := ErrUnknownKey
+	for _, key := range keys {
+		if !keyMatches(algorithm, key) {
+			continue
+		}
+		key := key
+		claims := &Claims{}
+		_, err = parser.ParseWithClaims(tokenString, claims, func(*jwt.Token) (interface{}, error) {
+			return key, nil
+		})
+		if err == nil {
+			return claims, nil
+		}
+	}
+	return nil, err
+}
+
+// jwksKeys returns the JWKS key named by the "kid" header of token, or
+// every JWKS key when the token names none.
+func (verifier *TokenVerifier) jwksKeys(ctx context.Context, token *jwt.Token) []interface{} {
+	if kid, ok := token.Header["kid"].(string); ok && kid != "" {
+		if key, found := verifier.lookup(ctx, kid); found {
+			return []interface{}{key}
+		}
+		return nil
+	}
+	verifier.mutex.RLock()
+	defer verifier.mutex.RUnlock()
+	keys := make([]interface{}, 0, len(verifier.jwks))
+	for _, key := range verifier.jwks {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// lookup finds a JWKS key by id. An unknown id triggers an early refresh,
+// at most once per minRefreshInterval, to pick up freshly rotated keys. The
+// refresh runs in the request path, so it ends with ctx and after
+// lookupTimeout at the latest.
+func (verifier *TokenVerifier) lookup(ctx context.Context, kid string) (interface{}, bool) {
+	verifier.mutex.Lock()
+	key, found := verifier.jwks[kid]
+	if found || verifier.now().Sub(verifier.refreshedAt) <= minRefreshInterval {
+		verifier.mutex.Unlock()
+		return key, found
+	}
+	// Claim the refresh slot so concurrent misses do not all hit the source.
+	verifier.refreshedAt = verifier.now()
+	verifier.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	if err := verifier.refresh(ctx); err != nil {
+		log.Printf("failed to refresh JWKS from %s: %v", verifier.config.JWKSSource, err)
+		return nil, false
+	}
+	verifier.mutex.RLock()
+	key, found = verifier.jwks[kid]
+	verifier.mutex.RUnlock()
+	return key, found
+}
+
+func (verifier *TokenVerifier) allowsHMAC() bool {
+	for algorithm := range verifier.algorithms {
+		if strings.HasPrefix(algorithm, "HS") {
+			return true
+		}
+	}
+	return false
+}
+
+func keyMatches(algorithm string, key interface{}) bool {
+	switch key.(type) {
+	case []byte:
+		return strings.HasPrefix(algorithm, "HS")
+	case *rsa.PublicKey:
+		return strings.HasPrefix(algorithm, "RS") || strings.HasPrefix(algorithm, "PS")
+	case *ecdsa.PublicKey:
+		return strings.HasPrefix(algorithm, "ES")
+	}
+	return false
+}
+
+func (verifier *TokenVerifier) validate(claims *Claims) error {
+	now := verifier.now()
+	skew := verifier.config.ClockSkew
+	// A token without "exp" would stay valid forever.
+	if claims.ExpiresAt == 0 {
+		return ErrMissingExpiry
+	}
+	if now.Add(-skew).After(time.Unix(claims.ExpiresAt, 0)) {
+		return ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Add(skew).Before(time.Unix(claims.NotBefore, 0)) {
+		return ErrTokenNotYetValid
+	}
+	if verifier.config.Issuer != "" && claims.Issuer != verifier.config.Issuer {
+		return fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if verifier.config.Audience != "" && !claims.Audience.contains(verifier.config.Audience) {
+		return fmt.Errorf("token is not meant for audience %q", verifier.config.Audience)
+	}
+	return nil
+}
+
+func (verifier *TokenVerifier) refreshLoop(ctx context.Context) {
+	if verifier.config.JWKSRefresh <= 0 {
+		return
+	}
+	ticker := time.NewTicker(verifier.config.JWKSRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := verifier.refresh(ctx); err != nil {
+				log.Printf("failed to refresh JWKS from %s, keeping previous keys: %v", verifier.config.JWKSSource, err)
+			}
+		}
+	}
+}
+
+func (verifier *TokenVerifier) refresh(ctx context.Context) error {
+	content, err := readSource(ctx, verifier.config.JWKSSource)
+	if err != nil {
+		return fmt.Errorf("loading JWKS: %w", err)
+	}
+	keys, err := parseJWKS(content)
+	if err != nil {
+		return fmt.Errorf("parsing JWKS: %w", err)
+	}
+	verifier.mutex.Lock()
+	verifier.jwks = keys
+	verifier.refreshedAt = verifier.now()
+	verifier.mutex.Unlock()
+	return nil
+}
+
+func readSource(ctx context.Context, source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.ReadFile(source)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, 1<<20))
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+func parseJWKS(content []byte) (map[string]interface{}, error) {
+	document := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]interface{}, len(document.Keys))
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (jwk jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(jwk.K)
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(bytes), nil
+}
+
+func readPublicKey(path string) (interface{}, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWT public key: %w", err)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("JWT public key %s is not PEM encoded", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing JWT public key: %w", err)
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("JWT public key %s must be RSA or EC", path)
+}
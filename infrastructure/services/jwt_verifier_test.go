@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestNewTokenVerifierSecrets(t *testing.T) {
+	tests := []struct {
+		name   string
+		config VerifierConfig
+		ok     bool
+	}{
+		{"configured secret", VerifierConfig{Algorithms: []string{"HS256"}, Secret: []byte(testSecret)}, true},
+		{"missing secret", VerifierConfig{Algorithms: []string{"HS256"}}, false},
+		{"public default secret", VerifierConfig{Algorithms: []string{"HS256"}, Secret: []byte("secret_key")}, false},
+		{"none algorithm", VerifierConfig{Algorithms: []string{"none"}, Secret: []byte(testSecret)}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewTokenVerifier(test.config)
+			if (err == nil) != test.ok {
+				t.Errorf("got error %v, want ok %v", err, test.ok)
+			}
+		})
+	}
+}
+
+func TestVerifyRegisteredClaims(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	verifier, err := NewTokenVerifier(VerifierConfig{
+		Algorithms: []string{"HS256"},
+		Secret:     []byte(testSecret),
+		Issuer:     "dislinkt",
+		ClockSkew:  30 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.now = func() time.Time { return now }
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		err    error
+	}{
+		{"valid", jwt.MapClaims{"id": "1", "iss": "dislinkt", "exp": now.Add(time.Minute).Unix()}, nil},
+		{"no expiry", jwt.MapClaims{"id": "1", "iss": "dislinkt"}, ErrMissingExpiry},
+		{"expired", jwt.MapClaims{"id": "1", "iss": "dislinkt", "exp": now.Add(-time.Minute).Unix()}, ErrTokenExpired},
+		{"expired within skew", jwt.MapClaims{"id": "1", "iss": "dislinkt", "exp": now.Add(-10 * time.Second).Unix()}, nil},
+		{"not yet valid", jwt.MapClaims{"id": "1", "iss": "dislinkt", "exp": now.Add(time.Hour).Unix(), "nbf": now.Add(time.Minute).Unix()}, ErrTokenNotYetValid},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, test.claims).SignedString([]byte(testSecret))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := verifier.Verify(context.Background(), token); !errors.Is(err, test.err) {
+				t.Errorf("got %v, want %v", err, test.err)
+			}
+		})
+	}
+
+	wrongIssuer, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": "other", "exp": now.Add(time.Minute).Unix()}).SignedString([]byte(testSecret))
+	if _, err := verifier.Verify(context.Background(), wrongIssuer); err == nil {
+		t.Error("token from another issuer was accepted")
+	}
+}
+
+// testKey is a signing key, published in JWKS documents under kid.
+type testKey struct {
+	kid    string
+	method jwt.SigningMethod
+	key    interface{}
+	public interface{}
+}
+
+func newRSAKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{kid, jwt.SigningMethodRS256, key, &key.PublicKey}
+}
+
+func newECKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{kid, jwt.SigningMethodES256, key, &key.PublicKey}
+}
+
+// sign issues a token valid for an hour from now, naming the key in its
+// "kid" header when withKid is set.
+func (key testKey) sign(t *testing.T, now time.Time, withKid bool) string {
+	t.Helper()
+	token := jwt.NewWithClaims(key.method, jwt.MapClaims{"id": "1", "exp": now.Add(time.Hour).Unix()})
+	if withKid {
+		token.Header["kid"] = key.kid
+	}
+	signed, err := token.SignedString(key.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func encodeJWKS(t *testing.T, keys ...testKey) []byte {
+	t.Helper()
+	encode := func(value *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(value.Bytes())
+	}
+	document := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	for _, key := range keys {
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			document.Keys = append(document.Keys, jsonWebKey{Kty: "RSA", Kid: key.kid, N: encode(public.N), E: encode(big.NewInt(int64(public.E)))})
+		case *ecdsa.PublicKey:
+			document.Keys = append(document.Keys, jsonWebKey{Kty: "EC", Kid: key.kid, Crv: "P-256", X: encode(public.X), Y: encode(public.Y)})
+		}
+	}
+	content, err := json.Marshal(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func writeJWKS(t *testing.T, path string, keys ...testKey) {
+	t.Helper()
+	if err := os.WriteFile(path, encodeJWKS(t, keys...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writePublicKey(t *testing.T, key testKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key.public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), key.kid+".pem")
+	writePEM(t, path, "PUBLIC KEY", der)
+	return path
+}
+
+func TestVerifySigningKeys(t *testing.T) {
+	now := time.Now()
+	rsaKey, otherRSAKey, ecKey := newRSAKey(t, "rsa"), newRSAKey(t, "rsa-2"), newECKey(t, "ec")
+	jwks := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, jwks, rsaKey, otherRSAKey, ecKey)
+
+	// The PEM of the RSA public key is public, so it must not verify
+	// HMAC tokens when HS256 and RS256 are both allowed.
+	publicKeyFile := writePublicKey(t, rsaKey)
+	publicPEM, err := os.ReadFile(publicKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacWithPublicKey, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"exp": now.Add(time.Hour).Unix()}).SignedString(publicPEM)
+	staticWithKid := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"exp": now.Add(time.Hour).Unix()})
+	staticWithKid.Header["kid"] = "hmac"
+	hmacWithKid, _ := staticWithKid.SignedString([]byte(testSecret))
+	unknownKid := newRSAKey(t, "unknown")
+
+	tests := []struct {
+		name   string
+		config VerifierConfig
+		token  string
+		ok     bool
+	}{
+		{"RS256 public key file", VerifierConfig{Algorithms: []string{"RS256"}, PublicKeyFile: publicKeyFile}, rsaKey.sign(t, now, false), true},
+		{"RS256 by kid", VerifierConfig{Algorithms: []string{"RS256"}, JWKSSource: jwks}, otherRSAKey.sign(t, now, true), true},
+		{"ES256 by kid", VerifierConfig{Algorithms: []string{"ES256"}, JWKSSource: jwks}, ecKey.sign(t, now, true), true},
+		{"ES256 without kid", VerifierConfig{Algorithms: []string{"ES256"}, JWKSSource: jwks}, ecKey.sign(t, now, false), true},
+		{"first of several keys without kid", VerifierConfig{Algorithms: []string{"RS256"}, JWKSSource: jwks}, rsaKey.sign(t, now, false), true},
+		{"second of several keys without kid", VerifierConfig{Algorithms: []string{"RS256"}, JWKSSource: jwks}, otherRSAKey.sign(t, now, false), true},
+		{"static key next to a JWKS", VerifierConfig{Algorithms: []string{"HS256"}, Secret: []byte(testSecret), JWKSSource: jwks}, hmacWithKid, true},
+		{"unknown kid", VerifierConfig{Algorithms: []string{"RS256"}, JWKSSource: jwks}, unknownKid.sign(t, now, true), false},
+		{"unknown key without kid", VerifierConfig{Algorithms: []string{"RS256"}, JWKSSource: jwks}, unknownKid.sign(t, now, false), false},
+		{"algorithm not allowed", VerifierConfig{Algorithms: []string{"RS256"}, JWKSSource: jwks}, ecKey.sign(t, now, true), false},
+		{"RSA public key as HMAC secret", VerifierConfig{Algorithms: []string{"HS256", "RS256"}, Secret: []byte(testSecret), PublicKeyFile: publicKeyFile}, hmacWithPublicKey, false},
+		{"RSA public key as HMAC secret with JWKS", VerifierConfig{Algorithms: []string{"HS256", "RS256"}, JWKSSource: jwks}, hmacWithPublicKey, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verifier, err := NewTokenVerifier(test.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer verifier.Close()
+			_, err = verifier.Verify(context.Background(), test.token)
+			if (err == nil) != test.ok {
+				t.Errorf("got error %v, want ok %v", err, test.ok)
+			}
+		})
+	}
+}
+
+func TestVerifyKeyRotation(t *testing.T) {
+	now := time.Now()
+	oldKey, newKey := newRSAKey(t, "2024-01"), newRSAKey(t, "2024-02")
+	jwks := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, jwks, oldKey)
+	verifier, err := NewTokenVerifier(VerifierConfig{Algorithms: []string{"RS256"}, JWKSSource: jwks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer verifier.Close()
+	start := now
+	verifier.now = func() time.Time { return now }
+	verifier.refreshedAt = start
+
+	writeJWKS(t, jwks, newKey)
+	steps := []struct {
+		name  string
+		after time.Duration
+		key   testKey
+		ok    bool
+	}{
+		{"old key before the refresh", 0, oldKey, true},
+		{"new key too soon after the last refresh", 10 * time.Second, newKey, false},
+		{"new key refreshes the JWKS", minRefreshInterval + time.Second, newKey, true},
+		{"old key is gone", minRefreshInterval + 2*time.Second, oldKey, false},
+		{"old key stays gone after the next refresh", 2*minRefreshInterval + 2*time.Second, oldKey, false},
+	}
+	for _, step := range steps {
+		now = start.Add(step.after)
+		_, err := verifier.Verify(context.Background(), step.key.sign(t, start, true))
+		if (err == nil) != step.ok {
+			t.Errorf("%s: got error %v, want ok %v", step.name, err, step.ok)
+		}
+	}
+}
+
+func TestVerifyRefreshEndsWithTheRequest(t *testing.T) {
+	key := newRSAKey(t, "served")
+	var requests int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Write(encodeJWKS(t, key))
+			return
+		}
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	verifier, err := NewTokenVerifier(VerifierConfig{Algorithms: []string{"RS256"}, JWKSSource: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer verifier.Close()
+	verifier.refreshedAt = time.Time{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	_, err = verifier.Verify(ctx, newRSAKey(t, "rotated").sign(t, time.Now(), true))
+	if err != ErrUnknownKey {
+		t.Errorf("got error %v, want %v", err, ErrUnknownKey)
+	}
+	if elapsed := time.Since(started); elapsed >= lookupTimeout {
+		t.Errorf("refresh took %s, want it to end with the request", elapsed)
+	}
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+// unmatchedRoute labels requests no route matched, so scanners probing
+// random paths cannot blow up the label cardinality.
+const unmatchedRoute = "unmatched"
+
+// HTTPMetrics are the RED metrics of the public listener. Route labels are
+// templates such as "/profile/{id}", never raw paths.
+type HTTPMetrics struct {
+	// Requests and Duration are labelled by route, method and status class.
+	Requests *prometheus.CounterVec
+	Duration *prometheus.HistogramVec
+	InFlight prometheus.Gauge
+	// RequestSize and ResponseSize are labelled by route and method.
+	RequestSize  *prometheus.HistogramVec
+	ResponseSize *prometheus.HistogramVec
+	// All, Ok and Bad are the unlabelled counters existing dashboards read;
+	// Bad counts every 4xx and 5xx answer.
+	All prometheus.Counter
+	Ok  prometheus.Counter
+	Bad prometheus.Counter
+}
+
+func (metrics HTTPMetrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		metrics.InFlight.Inc()
+		defer metrics.InFlight.Dec()
+
+		r, info := withRequestInfo(r)
+		recorder := NewResponseRecorder(w)
+		next.ServeHTTP(recorder, r)
+
+		info.mutex.Lock()
+		route := info.route
+		info.mutex.Unlock()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		class := strconv.Itoa(recorder.status/100) + "xx"
+		metrics.Requests.WithLabelValues(route, r.Method, class).Inc()
+		metrics.Duration.WithLabelValues(route, r.Method, class).Observe(time.Since(start).Seconds())
+		if r.ContentLength >= 0 {
+			metrics.RequestSize.WithLabelValues(route, r.Method).Observe(float64(r.ContentLength))
+		}
+		metrics.ResponseSize.WithLabelValues(route, r.Method).Observe(float64(recorder.bytes))
+
+		metrics.All.Inc()
+		if recorder.status >= http.StatusBadRequest {
+			metrics.Bad.Inc()
+		} else {
+			metrics.Ok.Inc()
+		}
+	})
+}
+
+// ClientMetrics are the RPC metrics of the backend clients, labelled by
+// service and method and, for Handled, the gRPC code.
+type ClientMetrics struct {
+	Handled  *prometheus.CounterVec
+	Duration *prometheus.HistogramVec
+}
+
+// UnaryClientInterceptor observes every attempt, so retried calls show up
+// once per try.
+func (metrics ClientMetrics) UnaryClientInterceptor(service string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		metrics.Duration.WithLabelValues(service, method).Observe(time.Since(start).Seconds())
+		metrics.Handled.WithLabelValues(service, method, errorStatus(err).Code().String()).Inc()
+		return err
+	}
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestHTTPMetrics() HTTPMetrics {
+	return HTTPMetrics{
+		Requests:     prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"route", "method", "status"}),
+		Duration:     prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "duration"}, []string{"route", "method", "status"}),
+		InFlight:     prometheus.NewGauge(prometheus.GaugeOpts{Name: "in_flight"}),
+		RequestSize:  prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "request_size"}, []string{"route", "method"}),
+		ResponseSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "response_size"}, []string{"route", "method"}),
+		All:          prometheus.NewCounter(prometheus.CounterOpts{Name: "all"}),
+		Ok:           prometheus.NewCounter(prometheus.CounterOpts{Name: "ok"}),
+		Bad:          prometheus.NewCounter(prometheus.CounterOpts{Name: "bad"}),
+	}
+}
+
+func TestHTTPMetricsLabels(t *testing.T) {
+	metrics := newTestHTTPMetrics()
+	requests := []struct {
+		method string
+		path   string
+		route  string
+		status int
+		body   string
+	}{
+		{"GET", "/profile/1", "/profile/{id}", http.StatusOK, ""},
+		{"GET", "/profile/2", "/profile/{id}", http.StatusOK, ""},
+		{"GET", "/profile/3", "/profile/{id}", http.StatusNotFound, ""},
+		{"POST", "/post", "/post", http.StatusCreated, `{"id":"1"}`},
+		{"POST", "/post", "/post", http.StatusBadGateway, `{"id":"2"}`},
+		{"GET", "/login", "/login", http.StatusFound, ""},
+		{"GET", "/wp-admin.php", "", http.StatusNotFound, ""},
+		{"GET", "/.env", "", http.StatusNotFound, ""},
+	}
+	for _, request := range requests {
+		request := request
+		handler := metrics.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if request.route != "" {
+				SetRoute(r.Context(), request.route)
+			}
+			w.WriteHeader(request.status)
+			w.Write([]byte("body"))
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(request.method, request.path, strings.NewReader(request.body)))
+	}
+
+	tests := []struct {
+		route  string
+		method string
+		class  string
+		want   float64
+	}{
+		{"/profile/{id}", "GET", "2xx", 2},
+		{"/profile/{id}", "GET", "4xx", 1},
+		{"/post", "POST", "2xx", 1},
+		{"/post", "POST", "5xx", 1},
+		{"/login", "GET", "3xx", 1},
+		{"unmatched", "GET", "4xx", 2},
+	}
+	for _, test := range tests {
+		if got := testutil.ToFloat64(metrics.Requests.WithLabelValues(test.route, test.method, test.class)); got != test.want {
+			t.Errorf("got %v requests for %s %s %s, want %v", got, test.method, test.route, test.class, test.want)
+		}
+	}
+	// Raw paths never become labels, so the series stay bounded.
+	if got := testutil.CollectAndCount(metrics.Requests); got != len(tests) {
+		t.Errorf("got %d request series, want %d", got, len(tests))
+	}
+	if got := testutil.CollectAndCount(metrics.Duration); got != len(tests) {
+		t.Errorf("got %d duration series, want %d", got, len(tests))
+	}
+	if got := testutil.CollectAndCount(metrics.ResponseSize); got != 4 {
+		t.Errorf("got %d response size series, want 4", got)
+	}
+
+	legacy := []struct {
+		name    string
+		counter prometheus.Counter
+		want    float64
+	}{
+		{"all", metrics.All, 8},
+		{"ok", metrics.Ok, 4},
+		{"bad", metrics.Bad, 4},
+	}
+	for _, test := range legacy {
+		if got := testutil.ToFloat64(test.counter); got != test.want {
+			t.Errorf("got %v %s requests, want %v", got, test.name, test.want)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.InFlight); got != 0 {
+		t.Errorf("got %v requests in flight after all finished", got)
+	}
+}
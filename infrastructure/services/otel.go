@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Samplers of the OpenTelemetry pipeline. Both keep SampleRatio of the
+// traces that start at the gateway; SamplerParent follows the caller's
+// decision for traces that arrive with a traceparent header.
+const (
+	SamplerRatio  = "ratio"
+	SamplerParent = "parent"
+)
+
+type OtelConfig struct {
+	ServiceName string
+	// Exporter receives finished spans in batches, an OTLPTraceExporter in
+	// production and an in-memory exporter in tests.
+	Exporter    sdktrace.SpanExporter
+	Sampler     string
+	SampleRatio float64
+	// SampleErrors exports spans that end with an error status even when
+	// the sampler dropped their trace.
+	SampleErrors bool
+}
+
+// OtelTracing is the OpenTelemetry counterpart of TracingMiddleware and
+// TracingInterceptor. Trace context travels in W3C traceparent and
+// tracestate headers, in both directions.
+type OtelTracing struct {
+	provider   *sdktrace.TracerProvider
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+func NewOtelTracing(config OtelConfig) (*OtelTracing, error) {
+	var sampler sdktrace.Sampler
+	switch config.Sampler {
+	case SamplerRatio:
+		sampler = sdktrace.TraceIDRatioBased(config.SampleRatio)
+	case SamplerParent:
+		sampler = sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))
+	default:
+		return nil, fmt.Errorf("unknown sampler %q", config.Sampler)
+	}
+	batcher := sdktrace.NewBatchSpanProcessor(config.Exporter)
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(config.ServiceName))),
+		sdktrace.WithSpanProcessor(batcher),
+	}
+	if config.SampleErrors {
+		sampler = recordDropped{sampler}
+		options = append(options, sdktrace.WithSpanProcessor(errorSpanProcessor{batcher}))
+	}
+	provider := sdktrace.NewTracerProvider(append(options, sdktrace.WithSampler(sampler))...)
+	return &OtelTracing{
+		provider:   provider,
+		tracer:     provider.Tracer("api-gateway"),
+		propagator: propagation.TraceContext{},
+	}, nil
+}
+
+// Middleware starts the server span of every request, continuing the trace
+// of the traceparent header when the request carries one.
+func (tracing *OtelTracing) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracing.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.tracer.Start(ctx, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPMethodKey.String(r.Method), semconv.HTTPTargetKey.String(r.URL.Path)))
+		defer span.End()
+
+		r, info := withRequestInfo(r.WithContext(ctx))
+		SetTraceId(r.Context(), span.SpanContext().TraceID().String())
+		recorder := NewResponseRecorder(w)
+		next.ServeHTTP(recorder, r)
+
+		info.mutex.Lock()
+		route := info.route
+		info.mutex.Unlock()
+		if route != "" {
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(semconv.HTTPRouteKey.String(route))
+		}
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(otelcodes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// UnaryClientInterceptor gives every call attempt to a backend its own
+// client span and sends traceparent and tracestate along in the outgoing
+// metadata.
+func (tracing *OtelTracing) UnaryClientInterceptor(service string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := tracing.tracer.Start(ctx, method,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.RPCSystemKey.String("grpc"), semconv.PeerServiceKey.String(service+"_service"), attribute.String("grpc.method", method)))
+		defer span.End()
+
+		md, _ := metadata.FromOutgoingContext(ctx)
+		md = md.Copy()
+		tracing.propagator.Inject(ctx, MetadataCarrier(md))
+		err := invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+		code := errorStatus(err).Code()
+		span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(code)))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(otelcodes.Error, err.Error())
+		}
+		return err
+	}
+}
+
+// Register makes the pipeline the global OpenTelemetry tracer provider and
+// propagator, for code without an OtelTracing at hand such as the auth
+// proxy.
+func (tracing *OtelTracing) Register() {
+	otel.SetTracerProvider(tracing.provider)
+	otel.SetTextMapPropagator(tracing.propagator)
+}
+
+// Flush exports every finished span without waiting for the next batch.
+func (tracing *OtelTracing) Flush(ctx context.Context) error {
+	return tracing.provider.ForceFlush(ctx)
+}
+
+// Close exports the spans still buffered and shuts the exporter down.
+func (tracing *OtelTracing) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return tracing.provider.Shutdown(ctx)
+}
+
+// recordDropped records the spans its sampler drops instead of discarding
+// them, so errorSpanProcessor still sees the ones that fail. They stay
+// unsampled for the batch processor and for backends.
+type recordDropped struct {
+	sdktrace.Sampler
+}
+
+func (sampler recordDropped) ShouldSample(parameters sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	result := sampler.Sampler.ShouldSample(parameters)
+	if result.Decision == sdktrace.Drop {
+		result.Decision = sdktrace.RecordOnly
+	}
+	return result
+}
+
+func (sampler recordDropped) Description() string {
+	return "RecordDropped{" + sampler.Sampler.Description() + "}"
+}
+
+// errorSpanProcessor hands unsampled spans that ended with an error to the
+// batch processor, marked as sampled so it exports them. The batch
+// processor is registered on its own as well and is flushed and shut down
+// there.
+type errorSpanProcessor struct {
+	batcher sdktrace.SpanProcessor
+}
+
+func (processor errorSpanProcessor) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+
+func (processor errorSpanProcessor) OnEnd(span sdktrace.ReadOnlySpan) {
+	if !span.SpanContext().IsSampled() && span.Status().Code == otelcodes.Error {
+		processor.batcher.OnEnd(sampledSpan{span})
+	}
+}
+
+func (processor errorSpanProcessor) Shutdown(context.Context) error {
+	return nil
+}
+
+func (processor errorSpanProcessor) ForceFlush(context.Context) error {
+	return nil
+}
+
+type sampledSpan struct {
+	sdktrace.ReadOnlySpan
+}
+
+func (span sampledSpan) SpanContext() trace.SpanContext {
+	return span.ReadOnlySpan.SpanContext().WithTraceFlags(trace.FlagsSampled)
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testTraceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanId  = "00f067aa0ba902b7"
+)
+
+func newTestOtelTracing(t *testing.T, config OtelConfig) (*OtelTracing, *tracetest.InMemoryExporter) {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	config.Exporter = exporter
+	tracing, err := NewOtelTracing(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tracing, exporter
+}
+
+func exportedSpans(t *testing.T, tracing *OtelTracing, exporter *tracetest.InMemoryExporter) tracetest.SpanStubs {
+	t.Helper()
+	if err := tracing.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return exporter.GetSpans()
+}
+
+// serveRoute answers through the middleware the way a matched route of the
+// gateway does.
+func serveRoute(tracing *OtelTracing, statusCode int, header http.Header) {
+	handler := tracing.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRoute(r.Context(), "/profile/{id}")
+		w.WriteHeader(statusCode)
+	}))
+	request := httptest.NewRequest("GET", "/profile/1", nil)
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+}
+
+func TestOtelMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     http.Header
+		statusCode int
+		traceId    string
+		parentId   string
+		traceState string
+		code       otelcodes.Code
+	}{
+		{"new trace", nil, http.StatusOK, "", "", "", otelcodes.Unset},
+		{"continued trace", http.Header{
+			"Traceparent": {"00-" + testTraceId + "-" + testSpanId + "-01"},
+			"Tracestate":  {"vendor=value"},
+		}, http.StatusOK, testTraceId, testSpanId, "vendor=value", otelcodes.Unset},
+		{"client error", nil, http.StatusNotFound, "", "", "", otelcodes.Unset},
+		{"server error", nil, http.StatusBadGateway, "", "", "", otelcodes.Error},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracing, exporter := newTestOtelTracing(t, OtelConfig{Sampler: SamplerParent, SampleRatio: 1})
+			serveRoute(tracing, test.statusCode, test.header)
+
+			spans := exportedSpans(t, tracing, exporter)
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(spans))
+			}
+			span := spans[0]
+			if span.Name != "GET /profile/{id}" || span.SpanKind != trace.SpanKindServer {
+				t.Errorf("got %s span %q", span.SpanKind, span.Name)
+			}
+			if test.traceId != "" && span.SpanContext.TraceID().String() != test.traceId {
+				t.Errorf("got trace %s, want %s", span.SpanContext.TraceID(), test.traceId)
+			}
+			if test.parentId == "" && span.Parent.IsValid() {
+				t.Errorf("got parent %s, want none", span.Parent.SpanID())
+			}
+			if test.parentId != "" && span.Parent.SpanID().String() != test.parentId {
+				t.Errorf("got parent %s, want %s", span.Parent.SpanID(), test.parentId)
+			}
+			if got := span.SpanContext.TraceState().String(); got != test.traceState {
+				t.Errorf("got trace state %q, want %q", got, test.traceState)
+			}
+			if span.Status.Code != test.code {
+				t.Errorf("got status %s, want %s", span.Status.Code, test.code)
+			}
+		})
+	}
+}
+
+func TestOtelSampling(t *testing.T) {
+	sampledParent := http.Header{"Traceparent": {"00-" + testTraceId + "-" + testSpanId + "-01"}}
+	unsampledParent := http.Header{"Traceparent": {"00-" + testTraceId + "-" + testSpanId + "-00"}}
+	tests := []struct {
+		name       string
+		config     OtelConfig
+		header     http.Header
+		statusCode int
+		exported   bool
+	}{
+		{"ratio keeps all", OtelConfig{Sampler: SamplerRatio, SampleRatio: 1}, nil, http.StatusOK, true},
+		{"ratio keeps none", OtelConfig{Sampler: SamplerRatio, SampleRatio: 0}, nil, http.StatusOK, false},
+		{"ratio ignores the caller", OtelConfig{Sampler: SamplerRatio, SampleRatio: 1}, unsampledParent, http.StatusOK, true},
+		{"parent sampled", OtelConfig{Sampler: SamplerParent, SampleRatio: 0}, sampledParent, http.StatusOK, true},
+		{"parent not sampled", OtelConfig{Sampler: SamplerParent, SampleRatio: 1}, unsampledParent, http.StatusOK, false},
+		{"parent uses the ratio for new traces", OtelConfig{Sampler: SamplerParent, SampleRatio: 0}, nil, http.StatusOK, false},
+		{"error kept", OtelConfig{Sampler: SamplerRatio, SampleRatio: 0, SampleErrors: true}, nil, http.StatusInternalServerError, true},
+		{"error of an unsampled parent kept", OtelConfig{Sampler: SamplerParent, SampleRatio: 1, SampleErrors: true}, unsampledParent, http.StatusServiceUnavailable, true},
+		{"success not kept by error sampling", OtelConfig{Sampler: SamplerRatio, SampleRatio: 0, SampleErrors: true}, nil, http.StatusOK, false},
+		{"error dropped without error sampling", OtelConfig{Sampler: SamplerRatio, SampleRatio: 0}, nil, http.StatusInternalServerError, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracing, exporter := newTestOtelTracing(t, test.config)
+			serveRoute(tracing, test.statusCode, test.header)
+			if got := len(exportedSpans(t, tracing, exporter)) == 1; got != test.exported {
+				t.Errorf("got exported %v, want %v", got, test.exported)
+			}
+		})
+	}
+}
+
+func TestOtelInterceptor(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code otelcodes.Code
+	}{
+		{"ok", nil, otelcodes.Unset},
+		{"failed", status.Error(codes.Unavailable, "connection refused"), otelcodes.Error},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracing, exporter := newTestOtelTracing(t, OtelConfig{Sampler: SamplerParent, SampleRatio: 1})
+			ctx, parent := tracing.tracer.Start(context.Background(), "parent")
+			ctx = metadata.AppendToOutgoingContext(ctx, "x-request-id", "request-1")
+
+			var outgoing metadata.MD
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				outgoing, _ = metadata.FromOutgoingContext(ctx)
+				return test.err
+			}
+			err := tracing.UnaryClientInterceptor("profile")(ctx, "/profile.ProfileService/Get", nil, nil, nil, invoker)
+			if err != test.err {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			parent.End()
+
+			spans := exportedSpans(t, tracing, exporter)
+			if len(spans) != 2 {
+				t.Fatalf("got %d spans, want 2", len(spans))
+			}
+			client := spans[0]
+			if client.SpanKind != trace.SpanKindClient || client.Parent.SpanID() != parent.SpanContext().SpanID() {
+				t.Errorf("got %s span with parent %s, want a client span of %s", client.SpanKind, client.Parent.SpanID(), parent.SpanContext().SpanID())
+			}
+			if client.Status.Code != test.code {
+				t.Errorf("got status %s, want %s", client.Status.Code, test.code)
+			}
+			want := "00-" + client.SpanContext.TraceID().String() + "-" + client.SpanContext.SpanID().String() + "-01"
+			if got := outgoing.Get("traceparent"); len(got) != 1 || got[0] != want {
+				t.Errorf("got traceparent %v, want %s", got, want)
+			}
+			if got := outgoing.Get("x-request-id"); len(got) != 1 {
+				t.Errorf("got x-request-id %v, want it kept", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http"
+)
+
+// OTLPConfig locates the collector. Endpoint is "host:port" for gRPC and a
+// base URL such as "http://collector:4318" for HTTP, where each signal is
+// posted to its own path below it.
+type OTLPConfig struct {
+	Protocol string
+	Endpoint string
+	// Headers go with every export, as gRPC metadata or HTTP headers.
+	Headers map[string]string
+	TLS     ClientTLS
+	Timeout time.Duration
+}
+
+// otlpTransport sends protobuf encoded export requests to the collector
+// over gRPC or HTTP.
+type otlpTransport struct {
+	config OTLPConfig
+	conn   *grpc.ClientConn
+	client *http.Client
+}
+
+func newOTLPTransport(config OTLPConfig) (*otlpTransport, error) {
+	transport := &otlpTransport{config: config}
+	switch config.Protocol {
+	case OTLPProtocolGRPC:
+		transportCredentials, err := config.TLS.TransportCredentials()
+		if err != nil {
+			return nil, fmt.Errorf("otlp TLS: %w", err)
+		}
+		// Dialing is lazy, so a collector that is not up yet only fails
+		// exports.
+		transport.conn, err = grpc.Dial(config.Endpoint, grpc.WithTransportCredentials(transportCredentials))
+		if err != nil {
+			return nil, err
+		}
+	case OTLPProtocolHTTP:
+		tlsConfig, err := config.TLS.Config()
+		if err != nil {
+			return nil, fmt.Errorf("otlp TLS: %w", err)
+		}
+		transport.client = &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		}}
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %q", config.Protocol)
+	}
+	return transport, nil
+}
+
+// export sends request with the gRPC method of its signal, or posts it to
+// path below the HTTP endpoint. Only gRPC fills response; partial success
+// details are not acted on.
+func (transport *otlpTransport) export(ctx context.Context, method string, path string, request proto.Message, response proto.Message) error {
+	if transport.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, transport.config.Timeout)
+		defer cancel()
+	}
+	if transport.conn != nil {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(transport.config.Headers))
+		return transport.conn.Invoke(ctx, method, request, response)
+	}
+
+	body, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(transport.config.Endpoint, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, value := range transport.config.Headers {
+		httpRequest.Header.Set(key, value)
+	}
+	httpRequest.Header.Set("Content-Type", "application/x-protobuf")
+	httpResponse, err := transport.client.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(httpResponse.Body, 64<<10))
+	if httpResponse.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp %s: %s", path, httpResponse.Status)
+	}
+	return nil
+}
+
+func (transport *otlpTransport) close() error {
+	if transport.conn != nil {
+		return transport.conn.Close()
+	}
+	transport.client.CloseIdleConnections()
+	return nil
+}
+
+// OTLPTraceExporter sends spans to an OpenTelemetry collector.
+type OTLPTraceExporter struct {
+	transport *otlpTransport
+}
+
+func NewOTLPTraceExporter(config OTLPConfig) (*OTLPTraceExporter, error) {
+	transport, err := newOTLPTransport(config)
+	if err != nil {
+		return nil, err
+	}
+	return &OTLPTraceExporter{transport: transport}, nil
+}
+
+func (exporter *OTLPTraceExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	return exporter.transport.export(ctx, "/opentelemetry.proto.collector.trace.v1.TraceService/Export", "/v1/traces",
+		otlpTraceRequest(spans), &collectortrace.ExportTraceServiceResponse{})
+}
+
+func (exporter *OTLPTraceExporter) Shutdown(ctx context.Context) error {
+	return exporter.transport.close()
+}
+
+type otlpScopeKey struct {
+	resource *resource.Resource
+	scope    instrumentation.Scope
+}
+
+// otlpTraceRequest groups spans by resource and instrumentation scope, as
+// the protocol nests them.
+func otlpTraceRequest(spans []sdktrace.ReadOnlySpan) *collectortrace.ExportTraceServiceRequest {
+	request := &collectortrace.ExportTraceServiceRequest{}
+	resources := map[*resource.Resource]*tracepb.ResourceSpans{}
+	scopes := map[otlpScopeKey]*tracepb.ScopeSpans{}
+	for _, span := range spans {
+		resourceSpans, ok := resources[span.Resource()]
+		if !ok {
+			resourceSpans = &tracepb.ResourceSpans{
+				Resource:  &resourcepb.Resource{Attributes: otlpAttributes(span.Resource().Attributes())},
+				SchemaUrl: span.Resource().SchemaURL(),
+			}
+			resources[span.Resource()] = resourceSpans
+			request.ResourceSpans = append(request.ResourceSpans, resourceSpans)
+		}
+		key := otlpScopeKey{span.Resource(), span.InstrumentationScope()}
+		scopeSpans, ok := scopes[key]
+		if !ok {
+			scopeSpans = &tracepb.ScopeSpans{
+				Scope:     &commonpb.InstrumentationScope{Name: key.scope.Name, Version: key.scope.Version},
+				SchemaUrl: key.scope.SchemaURL,
+			}
+			scopes[key] = scopeSpans
+			resourceSpans.ScopeSpans = append(resourceSpans.ScopeSpans, scopeSpans)
+		}
+		scopeSpans.Spans = append(scopeSpans.Spans, otlpSpan(span))
+	}
+	return request
+}
+
+func otlpSpan(span sdktrace.ReadOnlySpan) *tracepb.Span {
+	traceId := span.SpanContext().TraceID()
+	spanId := span.SpanContext().SpanID()
+	converted := &tracepb.Span{
+		TraceId:                traceId[:],
+		SpanId:                 spanId[:],
+		TraceState:             span.SpanContext().TraceState().String(),
+		Name:                   span.Name(),
+		Kind:                   tracepb.Span_SpanKind(span.SpanKind()),
+		StartTimeUnixNano:      uint64(span.StartTime().UnixNano()),
+		EndTimeUnixNano:        uint64(span.EndTime().UnixNano()),
+		Attributes:             otlpAttributes(span.Attributes()),
+		DroppedAttributesCount: uint32(span.DroppedAttributes()),
+		DroppedEventsCount:     uint32(span.DroppedEvents()),
+		DroppedLinksCount:      uint32(span.DroppedLinks()),
+		Status:                 &tracepb.Status{Message: span.Status().Description},
+	}
+	if parent := span.Parent(); parent.IsValid() {
+		parentId := parent.SpanID()
+		converted.ParentSpanId = parentId[:]
+	}
+	switch span.Status().Code {
+	case otelcodes.Ok:
+		converted.Status.Code = tracepb.Status_STATUS_CODE_OK
+	case otelcodes.Error:
+		converted.Status.Code = tracepb.Status_STATUS_CODE_ERROR
+	}
+	for _, event := range span.Events() {
+		converted.Events = append(converted.Events, &tracepb.Span_Event{
+			TimeUnixNano:           uint64(event.Time.UnixNano()),
+			Name:                   event.Name,
+			Attributes:             otlpAttributes(event.Attributes),
+			DroppedAttributesCount: uint32(event.DroppedAttributeCount),
+		})
+	}
+	for _, link := range span.Links() {
+		linkTraceId := link.SpanContext.TraceID()
+		linkSpanId := link.SpanContext.SpanID()
+		converted.Links = append(converted.Links, &tracepb.Span_Link{
+			TraceId:                linkTraceId[:],
+			SpanId:                 linkSpanId[:],
+			TraceState:             link.SpanContext.TraceState().String(),
+			Attributes:             otlpAttributes(link.Attributes),
+			DroppedAttributesCount: uint32(link.DroppedAttributeCount),
+		})
+	}
+	return converted
+}
+
+func otlpAttributes(attributes []attribute.KeyValue) []*commonpb.KeyValue {
+	converted := make([]*commonpb.KeyValue, 0, len(attributes))
+	for _, kv := range attributes {
+		converted = append(converted, &commonpb.KeyValue{Key: string(kv.Key), Value: otlpValue(kv.Value)})
+	}
+	return converted
+}
+
+func otlpValue(value attribute.Value) *commonpb.AnyValue {
+	switch value.Type() {
+	case attribute.BOOL:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: value.AsBool()}}
+	case attribute.INT64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: value.AsInt64()}}
+	case attribute.FLOAT64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: value.AsFloat64()}}
+	case attribute.STRING:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value.AsString()}}
+	}
+	// Slices are sent the way the SDK prints them.
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value.Emit()}}
+}
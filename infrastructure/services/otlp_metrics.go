@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+// OTLPMetricsExporter pushes the gateway's Prometheus metrics to an
+// OpenTelemetry collector, so both pipelines report the same instruments.
+// Counters become monotonic sums, histograms keep their buckets, and every
+// value is cumulative since the exporter started.
+type OTLPMetricsExporter struct {
+	transport   *otlpTransport
+	gatherer    prometheus.Gatherer
+	serviceName string
+	interval    time.Duration
+	start       time.Time
+	now         func() time.Time
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+func NewOTLPMetricsExporter(config OTLPConfig, serviceName string, gatherer prometheus.Gatherer, interval time.Duration) (*OTLPMetricsExporter, error) {
+	transport, err := newOTLPTransport(config)
+	if err != nil {
+		return nil, err
+	}
+	return &OTLPMetricsExporter{
+		transport:   transport,
+		gatherer:    gatherer,
+		serviceName: serviceName,
+		interval:    interval,
+		start:       time.Now(),
+		now:         time.Now,
+	}, nil
+}
+
+// Start pushes the metrics once per interval until Close. Failed pushes
+// are logged; the next one carries the totals again.
+func (exporter *OTLPMetricsExporter) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	exporter.cancel = cancel
+	exporter.done = make(chan struct{})
+	go func() {
+		defer close(exporter.done)
+		ticker := time.NewTicker(exporter.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := exporter.Export(ctx); err != nil {
+					log.Printf("failed to export metrics: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Export gathers and pushes the metrics once.
+func (exporter *OTLPMetricsExporter) Export(ctx context.Context) error {
+	families, err := exporter.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	return exporter.transport.export(ctx, "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export", "/v1/metrics",
+		exporter.request(families), &collectormetrics.ExportMetricsServiceResponse{})
+}
+
+// Close stops the pushes, sends a last one and closes the connection.
+func (exporter *OTLPMetricsExporter) Close() error {
+	if exporter.cancel != nil {
+		exporter.cancel()
+		<-exporter.done
+	}
+	err := exporter.Export(context.Background())
+	if closeErr := exporter.transport.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (exporter *OTLPMetricsExporter) request(families []*dto.MetricFamily) *collectormetrics.ExportMetricsServiceRequest {
+	start := uint64(exporter.start.UnixNano())
+	now := uint64(exporter.now().UnixNano())
+	scope := &metricspb.ScopeMetrics{Scope: &commonpb.InstrumentationScope{Name: "api-gateway"}}
+	for _, family := range families {
+		metric := &metricspb.Metric{Name: family.GetName(), Description: family.GetHelp()}
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			sum := &metricspb.Sum{IsMonotonic: true, AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE}
+			for _, m := range family.GetMetric() {
+				sum.DataPoints = append(sum.DataPoints, numberDataPoint(m, m.GetCounter().GetValue(), start, now))
+			}
+			metric.Data = &metricspb.Metric_Sum{Sum: sum}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			gauge := &metricspb.Gauge{}
+			for _, m := range family.GetMetric() {
+				value := m.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = m.GetUntyped().GetValue()
+				}
+				gauge.DataPoints = append(gauge.DataPoints, numberDataPoint(m, value, start, now))
+			}
+			metric.Data = &metricspb.Metric_Gauge{Gauge: gauge}
+		case dto.MetricType_HISTOGRAM:
+			histogram := &metricspb.Histogram{AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE}
+			for _, m := range family.GetMetric() {
+				histogram.DataPoints = append(histogram.DataPoints, histogramDataPoint(m, start, now))
+			}
+			metric.Data = &metricspb.Metric_Histogram{Histogram: histogram}
+		case dto.MetricType_SUMMARY:
+			summary := &metricspb.Summary{}
+			for _, m := range family.GetMetric() {
+				point := &metricspb.SummaryDataPoint{
+					Attributes:        labelAttributes(m),
+					StartTimeUnixNano: start,
+					TimeUnixNano:      now,
+					Count:             m.GetSummary().GetSampleCount(),
+					Sum:               m.GetSummary().GetSampleSum(),
+				}
+				for _, quantile := range m.GetSummary().GetQuantile() {
+					point.QuantileValues = append(point.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+						Quantile: quantile.GetQuantile(),
+						Value:    quantile.GetValue(),
+					})
+				}
+				summary.DataPoints = append(summary.DataPoints, point)
+			}
+			metric.Data = &metricspb.Metric_Summary{Summary: summary}
+		default:
+			continue
+		}
+		scope.Metrics = append(scope.Metrics, metric)
+	}
+	return &collectormetrics.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{{
+				Key:   "service.name",
+				Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: exporter.serviceName}},
+			}}},
+			ScopeMetrics: []*metricspb.ScopeMetrics{scope},
+		}},
+	}
+}
+
+func numberDataPoint(m *dto.Metric, value float64, start, now uint64) *metricspb.NumberDataPoint {
+	return &metricspb.NumberDataPoint{
+		Attributes:        labelAttributes(m),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      now,
+		Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+	}
+}
+
+// histogramDataPoint turns Prometheus' cumulative buckets into per bucket
+// counts. The last count is the overflow bucket above the highest bound.
+func histogramDataPoint(m *dto.Metric, start, now uint64) *metricspb.HistogramDataPoint {
+	histogram := m.GetHistogram()
+	sum := histogram.GetSampleSum()
+	point := &metricspb.HistogramDataPoint{
+		Attributes:        labelAttributes(m),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      now,
+		Count:             histogram.GetSampleCount(),
+		Sum:               &sum,
+	}
+	var below uint64
+	for _, bucket := range histogram.GetBucket() {
+		if math.IsInf(bucket.GetUpperBound(), 1) {
+			continue
+		}
+		point.ExplicitBounds = append(point.ExplicitBounds, bucket.GetUpperBound())
+		point.BucketCounts = append(point.BucketCounts, bucket.GetCumulativeCount()-below)
+		below = bucket.GetCumulativeCount()
+	}
+	point.BucketCounts = append(point.BucketCounts, histogram.GetSampleCount()-below)
+	return point
+}
+
+func labelAttributes(m *dto.Metric) []*commonpb.KeyValue {
+	attributes := make([]*commonpb.KeyValue, 0, len(m.GetLabel()))
+	for _, label := range m.GetLabel() {
+		attributes = append(attributes, &commonpb.KeyValue{
+			Key:   label.GetName(),
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: label.GetValue()}},
+		})
+	}
+	return attributes
+}
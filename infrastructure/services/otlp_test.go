@@ -0,0 +1,273 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeCollector keeps every export it receives together with the x-tenant
+// header that came with it.
+type fakeCollector struct {
+	mutex   sync.Mutex
+	traces  []*collectortrace.ExportTraceServiceRequest
+	metrics []*collectormetrics.ExportMetricsServiceRequest
+	tenants []string
+}
+
+func (collector *fakeCollector) receive(request proto.Message, tenant string) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	switch request := request.(type) {
+	case *collectortrace.ExportTraceServiceRequest:
+		collector.traces = append(collector.traces, request)
+	case *collectormetrics.ExportMetricsServiceRequest:
+		collector.metrics = append(collector.metrics, request)
+	}
+	collector.tenants = append(collector.tenants, tenant)
+}
+
+type fakeTraceService struct {
+	collectortrace.UnimplementedTraceServiceServer
+	collector *fakeCollector
+}
+
+func (service fakeTraceService) Export(ctx context.Context, request *collectortrace.ExportTraceServiceRequest) (*collectortrace.ExportTraceServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	service.collector.receive(request, firstValue(md.Get("x-tenant")))
+	return &collectortrace.ExportTraceServiceResponse{}, nil
+}
+
+type fakeMetricsService struct {
+	collectormetrics.UnimplementedMetricsServiceServer
+	collector *fakeCollector
+}
+
+func (service fakeMetricsService) Export(ctx context.Context, request *collectormetrics.ExportMetricsServiceRequest) (*collectormetrics.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	service.collector.receive(request, firstValue(md.Get("x-tenant")))
+	return &collectormetrics.ExportMetricsServiceResponse{}, nil
+}
+
+func firstValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// startFakeCollector serves OTLP over protocol and returns the endpoint to
+// configure.
+func startFakeCollector(t *testing.T, protocol string) (*fakeCollector, string) {
+	t.Helper()
+	collector := &fakeCollector{}
+	if protocol == OTLPProtocolGRPC {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := grpc.NewServer()
+		collectortrace.RegisterTraceServiceServer(server, fakeTraceService{collector: collector})
+		collectormetrics.RegisterMetricsServiceServer(server, fakeMetricsService{collector: collector})
+		go server.Serve(listener)
+		t.Cleanup(server.Stop)
+		return collector, listener.Addr().String()
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		var request proto.Message
+		switch r.URL.Path {
+		case "/v1/traces":
+			request = &collectortrace.ExportTraceServiceRequest{}
+		case "/v1/metrics":
+			request = &collectormetrics.ExportMetricsServiceRequest{}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := proto.Unmarshal(body, request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		collector.receive(request, r.Header.Get("X-Tenant"))
+	}))
+	t.Cleanup(server.Close)
+	return collector, server.URL
+}
+
+func TestOTLPTraceExporter(t *testing.T) {
+	for _, protocol := range []string{OTLPProtocolGRPC, OTLPProtocolHTTP} {
+		t.Run(protocol, func(t *testing.T) {
+			collector, endpoint := startFakeCollector(t, protocol)
+			exporter, err := NewOTLPTraceExporter(OTLPConfig{
+				Protocol: protocol,
+				Endpoint: endpoint,
+				Headers:  map[string]string{"x-tenant": "gateway"},
+				Timeout:  time.Second,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tracing, err := NewOtelTracing(OtelConfig{ServiceName: "api_gateway", Exporter: exporter, Sampler: SamplerParent, SampleRatio: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			serveRoute(tracing, http.StatusBadGateway, http.Header{"Traceparent": {"00-" + testTraceId + "-" + testSpanId + "-01"}})
+			if err := tracing.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			collector.mutex.Lock()
+			defer collector.mutex.Unlock()
+			if len(collector.traces) != 1 || collector.tenants[0] != "gateway" {
+				t.Fatalf("got %d exports with tenants %v, want 1 from gateway", len(collector.traces), collector.tenants)
+			}
+			resourceSpans := collector.traces[0].ResourceSpans
+			if len(resourceSpans) != 1 || len(resourceSpans[0].ScopeSpans) != 1 || len(resourceSpans[0].ScopeSpans[0].Spans) != 1 {
+				t.Fatalf("got %v, want a single span", resourceSpans)
+			}
+			if !hasStringAttribute(resourceSpans[0].Resource.Attributes, "service.name", "api_gateway") {
+				t.Errorf("got resource %v, want service.name api_gateway", resourceSpans[0].Resource.Attributes)
+			}
+			span := resourceSpans[0].ScopeSpans[0].Spans[0]
+			if span.Name != "GET /profile/{id}" || span.Kind != tracepb.Span_SPAN_KIND_SERVER {
+				t.Errorf("got %s span %q", span.Kind, span.Name)
+			}
+			if got, want := span.TraceId, mustDecodeHex(t, testTraceId); !bytes.Equal(got, want) {
+				t.Errorf("got trace %x, want %x", got, want)
+			}
+			if got, want := span.ParentSpanId, mustDecodeHex(t, testSpanId); !bytes.Equal(got, want) {
+				t.Errorf("got parent %x, want %x", got, want)
+			}
+			if span.Status.Code != tracepb.Status_STATUS_CODE_ERROR {
+				t.Errorf("got status %s, want error", span.Status.Code)
+			}
+			if span.StartTimeUnixNano == 0 || span.EndTimeUnixNano < span.StartTimeUnixNano {
+				t.Errorf("got times %d to %d", span.StartTimeUnixNano, span.EndTimeUnixNano)
+			}
+		})
+	}
+}
+
+func TestOTLPExportRejected(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+	exporter, err := NewOTLPTraceExporter(OTLPConfig{Protocol: OTLPProtocolHTTP, Endpoint: collector.URL, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Shutdown(context.Background())
+	spans := tracetest.SpanStubs{{Name: "GET /profile/{id}"}}.Snapshots()
+	if err := exporter.ExportSpans(context.Background(), spans); err == nil {
+		t.Error("got no error from a failing collector")
+	}
+}
+
+func TestOTLPMetricsExporter(t *testing.T) {
+	for _, protocol := range []string{OTLPProtocolGRPC, OTLPProtocolHTTP} {
+		t.Run(protocol, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total", Help: "Requests"}, []string{"route"})
+			inFlight := prometheus.NewGauge(prometheus.GaugeOpts{Name: "in_flight", Help: "In flight"})
+			duration := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "duration_seconds", Help: "Duration", Buckets: []float64{0.1, 1}})
+			registry.MustRegister(requests, inFlight, duration)
+			requests.WithLabelValues("/profile/{id}").Add(3)
+			inFlight.Set(7)
+			for _, value := range []float64{0.05, 0.5, 5} {
+				duration.Observe(value)
+			}
+
+			collector, endpoint := startFakeCollector(t, protocol)
+			exporter, err := NewOTLPMetricsExporter(OTLPConfig{
+				Protocol: protocol,
+				Endpoint: endpoint,
+				Headers:  map[string]string{"x-tenant": "gateway"},
+				Timeout:  time.Second,
+			}, "api_gateway", registry, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := exporter.Export(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if err := exporter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			collector.mutex.Lock()
+			defer collector.mutex.Unlock()
+			if len(collector.metrics) != 2 || collector.tenants[0] != "gateway" {
+				t.Fatalf("got %d exports with tenants %v, want 2 from gateway", len(collector.metrics), collector.tenants)
+			}
+			metrics := map[string]*metricspb.Metric{}
+			for _, metric := range collector.metrics[0].ResourceMetrics[0].ScopeMetrics[0].Metrics {
+				metrics[metric.Name] = metric
+			}
+
+			sum := metrics["requests_total"].GetSum()
+			if sum == nil || !sum.IsMonotonic || sum.AggregationTemporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+				t.Fatalf("got %v, want a cumulative monotonic sum", metrics["requests_total"])
+			}
+			if point := sum.DataPoints[0]; point.GetAsDouble() != 3 || !hasStringAttribute(point.Attributes, "route", "/profile/{id}") {
+				t.Errorf("got counter point %v", point)
+			}
+			if gauge := metrics["in_flight"].GetGauge(); gauge == nil || gauge.DataPoints[0].GetAsDouble() != 7 {
+				t.Errorf("got %v, want gauge 7", metrics["in_flight"])
+			}
+			histogram := metrics["duration_seconds"].GetHistogram()
+			if histogram == nil {
+				t.Fatalf("got %v, want a histogram", metrics["duration_seconds"])
+			}
+			point := histogram.DataPoints[0]
+			if point.Count != 3 || point.GetSum() != 5.55 {
+				t.Errorf("got count %d and sum %v, want 3 and 5.55", point.Count, point.GetSum())
+			}
+			if !reflect.DeepEqual(point.ExplicitBounds, []float64{0.1, 1}) || !reflect.DeepEqual(point.BucketCounts, []uint64{1, 1, 1}) {
+				t.Errorf("got bounds %v and counts %v, want [0.1 1] and [1 1 1]", point.ExplicitBounds, point.BucketCounts)
+			}
+		})
+	}
+}
+
+func hasStringAttribute(attributes []*commonpb.KeyValue, key, value string) bool {
+	for _, attribute := range attributes {
+		if attribute.Key == key && attribute.Value.GetStringValue() == value {
+			return true
+		}
+	}
+	return false
+}
+
+func mustDecodeHex(t *testing.T, value string) []byte {
+	t.Helper()
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+)
+
+const maxPolicyBodySize = 1 << 20
+
+// Policy says who may call a route. The zero value only requires an
+// authenticated caller.
+type Policy struct {
+	Public bool
+	// Roles, when set, requires the caller to hold at least one of them.
+	Roles []string
+	// OwnerPathParam names a path parameter that must equal the caller id.
+	OwnerPathParam string
+	// OwnerBodyField names a JSON body field, dotted for nested objects,
+	// that must equal the caller id.
+	OwnerBodyField string
+}
+
+var (
+	Public        = Policy{Public: true}
+	Authenticated = Policy{}
+)
+
+func RequireRole(roles ...string) Policy {
+	return Policy{Roles: roles}
+}
+
+func OwnerOfPathParam(param string) Policy {
+	return Policy{OwnerPathParam: param}
+}
+
+func OwnerOfBodyField(field string) Policy {
+	return Policy{OwnerBodyField: field}
+}
+
+type RoutePolicy struct {
+	Method  string
+	Pattern string
+	Policy  Policy
+}
+
+type compiledPolicy struct {
+	RoutePolicy
+	segments []string
+}
+
+// PolicyTable enforces route policies before a request reaches the mux.
+type PolicyTable struct {
+	policies      []compiledPolicy
+	defaultPolicy Policy
+	authenticator *Authenticator
+}
+
+// NewPolicyTable builds the table. defaultPolicy covers requests that match
+// no entry.
+func NewPolicyTable(policies []RoutePolicy, defaultPolicy Policy, authenticator *Authenticator) *PolicyTable {
+	table := &PolicyTable{
+		policies:      make([]compiledPolicy, 0, len(policies)),
+		defaultPolicy: defaultPolicy,
+		authenticator: authenticator,
+	}
+	for _, policy := range policies {
+		table.policies = append(table.policies, compiledPolicy{
+			RoutePolicy: policy,
+			segments:    splitPath(policy.Pattern),
+		})
+	}
+	return table
+}
+
+// CheckCoverage fails when a registered route has no explicit policy, so a
+// new endpoint cannot ship without an authorization decision.
+func (table *PolicyTable) CheckCoverage(routes []RoutePolicy) error {
+	missing := make([]string, 0)
+	for _, route := range routes {
+		found := false
+		for _, policy := range table.policies {
+			if policy.Method == route.Method && policy.Pattern == route.Pattern {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, route.Method+" "+route.Pattern)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("routes without an authorization policy: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (table *PolicyTable) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy, pathParams := table.match(r.Method, r.URL.Path)
+		if policy.Public {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, authErr := table.authenticator.authenticate(r)
+		if authErr != nil {
+			table.authenticator.writeError(w, r, authErr)
+			return
+		}
+		if len(policy.Roles) > 0 && !hasAnyRole(principal, policy.Roles) {
+			writeForbidden(w, r, "insufficient role")
+			return
+		}
+		if policy.OwnerPathParam != "" && pathParams[policy.OwnerPathParam] != principal.Id {
+			writeForbidden(w, r, "caller does not own this resource")
+			return
+		}
+		if policy.OwnerBodyField != "" {
+			owner, err := bodyField(r, policy.OwnerBodyField)
+			if err != nil {
+				WriteStatus(w, r, codes.InvalidArgument, err.Error())
+				return
+			}
+			if owner != principal.Id {
+				writeForbidden(w, r, "caller does not own this resource")
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(ContextWithPrincipal(r.Context(), principal)))
+	})
+}
+
+// match picks the most specific policy: literal segments win over
+// parameters, which mirrors the custom routes registered on the mux.
+func (table *PolicyTable) match(method string, path string) (Policy, map[string]string) {
+	segments := splitPath(path)
+	var best *compiledPolicy
+	var bestParams map[string]string
+	bestScore := -1
+	for i := range table.policies {
+		policy := &table.policies[i]
+		if policy.Method != method || len(policy.segments) != len(segments) {
+			continue
+		}
+		params, score, ok := matchSegments(policy.segments, segments)
+		if ok && score > bestScore {
+			best, bestParams, bestScore = policy, params, score
+		}
+	}
+	if best == nil {
+		return table.defaultPolicy, map[string]string{}
+	}
+	return best.Policy, bestParams
+}
+
+func matchSegments(pattern []string, segments []string) (map[string]string, int, bool) {
+	params := map[string]string{}
+	score := 0
+	for i, segment := range pattern {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if segments[i] == "" {
+				return nil, 0, false
+			}
+			params[segment[1:len(segment)-1]] = segments[i]
+			continue
+		}
+		if segment != segments[i] {
+			return nil, 0, false
+		}
+		// Earlier literal segments weigh more than later ones.
+		score += 1 << (len(pattern) - i)
+	}
+	return params, score, true
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+func hasAnyRole(principal *Principal, roles []string) bool {
+	for _, role := range roles {
+		if principal.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+type bodyEntry struct {
+	key   string
+	value json.RawMessage
+}
+
+// bodyField reads a string field from the JSON body and restores the body
+// for the handler. Keys match case-insensitively and the last occurrence
+// wins, exactly as encoding/json resolves them when the handler decodes.
+func bodyField(r *http.Request, field string) (string, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPolicyBodySize))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("reading request body: %w", err)
+	}
+
+	value := json.RawMessage(body)
+	for _, key := range strings.Split(field, ".") {
+		object := make([]bodyEntry, 0)
+		decoder := json.NewDecoder(bytes.NewReader(value))
+		if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+			return "", fmt.Errorf("request body field %q is missing", field)
+		}
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return "", fmt.Errorf("malformed request body: %w", err)
+			}
+			var raw json.RawMessage
+			if err := decoder.Decode(&raw); err != nil {
+				return "", fmt.Errorf("malformed request body: %w", err)
+			}
+			object = append(object, bodyEntry{key: token.(string), value: raw})
+		}
+		value = nil
+		for _, entry := range object {
+			if strings.EqualFold(entry.key, key) {
+				value = entry.value
+			}
+		}
+		if value == nil {
+			return "", fmt.Errorf("request body field %q is missing", field)
+		}
+	}
+
+	var result string
+	if err := json.Unmarshal(value, &result); err != nil {
+		return "", fmt.Errorf("request body field %q must be a string", field)
+	}
+	return result, nil
+}
+
+func writeForbidden(w http.ResponseWriter, r *http.Request, message string) {
+	WriteStatus(w, r, codes.PermissionDenied, message)
+}
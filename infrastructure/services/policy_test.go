@@ -0,0 +1,104 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const testSecret = "test-secret-with-enough-entropy"
+
+// testToken signs an HS256 token for id that expires in an hour.
+func testToken(t testing.TB, id string, roles ...string) string {
+	t.Helper()
+	claims := jwt.MapClaims{
+		"id":       id,
+		"username": "user-" + id,
+		"iat":      time.Now().Unix(),
+		"exp":      time.Now().Add(time.Hour).Unix(),
+	}
+	if len(roles) > 0 {
+		claims["roles"] = roles
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func testAuthenticator(t testing.TB) *Authenticator {
+	t.Helper()
+	verifier, err := NewTokenVerifier(VerifierConfig{Algorithms: []string{"HS256"}, Secret: []byte(testSecret)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := NewAuthenticator(verifier, AuthenticatorConfig{
+		TokenSources: []string{TokenSourceBearer, TokenSourceCookie},
+		CookieName:   "token",
+		Realm:        "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authenticator
+}
+
+func TestPolicyTableMiddleware(t *testing.T) {
+	table := NewPolicyTable([]RoutePolicy{
+		{Method: "GET", Pattern: "/post", Policy: Public},
+		{Method: "POST", Pattern: "/post", Policy: OwnerOfBodyField("userId")},
+		{Method: "PUT", Pattern: "/connection/user", Policy: OwnerOfBodyField("userId")},
+		{Method: "PUT", Pattern: "/profile/{id}", Policy: OwnerOfPathParam("id")},
+		{Method: "POST", Pattern: "/admin/users/{id}/revoke", Policy: RequireRole("admin")},
+	}, Authenticated, testAuthenticator(t))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		token  string
+		status int
+	}{
+		{"public route without token", "GET", "/post", "", "", http.StatusOK},
+		{"unlisted route defaults to authenticated", "GET", "/post/job/unlisted", "", "", http.StatusUnauthorized},
+		{"unlisted route with token", "GET", "/post/job/unlisted", "", testToken(t, "alice"), http.StatusOK},
+		{"post as owner", "POST", "/post", `{"id":"1","userId":"alice"}`, testToken(t, "alice"), http.StatusOK},
+		{"post as someone else", "POST", "/post", `{"id":"1","userId":"bob"}`, testToken(t, "alice"), http.StatusForbidden},
+		{"post with differently cased key", "POST", "/post", `{"UserId":"bob"}`, testToken(t, "alice"), http.StatusForbidden},
+		{"post without owner field", "POST", "/post", `{"id":"1"}`, testToken(t, "alice"), http.StatusBadRequest},
+		{"update connection user as owner", "PUT", "/connection/user", `{"userId":"alice","username":"a"}`, testToken(t, "alice"), http.StatusOK},
+		{"update connection user as someone else", "PUT", "/connection/user", `{"userId":"bob","username":"b"}`, testToken(t, "alice"), http.StatusForbidden},
+		{"path owner", "PUT", "/profile/alice", "{}", testToken(t, "alice"), http.StatusOK},
+		{"path owner mismatch", "PUT", "/profile/bob", "{}", testToken(t, "alice"), http.StatusForbidden},
+		{"role missing", "POST", "/admin/users/bob/revoke", "", testToken(t, "alice"), http.StatusForbidden},
+		{"role present", "POST", "/admin/users/bob/revoke", "", testToken(t, "alice", "admin"), http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var body string
+			handler := table.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				read, _ := io.ReadAll(r.Body)
+				body = string(read)
+			}))
+			request := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			if test.token != "" {
+				request.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Fatalf("got status %d, want %d: %s", recorder.Code, test.status, recorder.Body)
+			}
+			if test.status == http.StatusOK && body != test.body {
+				t.Errorf("handler got body %q, want %q", body, test.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,419 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	BalancerRoundRobin   = "round_robin"
+	BalancerLeastRequest = "least_request"
+
+	// dnsPrefix marks an address whose host name is resolved to one replica
+	// per A or AAAA record.
+	dnsPrefix = "dns:///"
+)
+
+var ErrNoReplicas = errors.New("no replicas available")
+
+// OutlierConfig ejects replicas that keep failing while the rest of the
+// pool is healthy.
+type OutlierConfig struct {
+	// ConsecutiveFailures ejects a replica after that many transport
+	// failures in a row; 0 disables ejection.
+	ConsecutiveFailures int
+	// BaseEjectionTime grows with every ejection of the same replica, up to
+	// MaxEjectionTime.
+	BaseEjectionTime time.Duration
+	MaxEjectionTime  time.Duration
+	// MaxEjectionPercent caps the share of the pool that may be ejected.
+	MaxEjectionPercent int
+}
+
+type PoolConfig struct {
+	// Name identifies the backend in logs and readiness output.
+	Name string
+	// Addresses are "host:port" replicas or "dns:///host:port" names that
+	// expand to every address the name resolves to.
+	Addresses []string
+	Balancer  string
+	Outlier   OutlierConfig
+	// DNSRefresh is how often "dns:///" names are resolved again.
+	DNSRefresh  time.Duration
+	DialOptions []grpc.DialOption
+	// Interceptors wrap every unary call on the pool, outermost first. They
+	// see the logical call, so a retry may land on another replica. The pool
+	// makes no streaming calls.
+	Interceptors []grpc.UnaryClientInterceptor
+}
+
+type replica struct {
+	// active is first to keep it 64-bit aligned for atomic access.
+	active  int64
+	address string
+	conn    *grpc.ClientConn
+	// The fields below are guarded by the pool mutex.
+	failures     int
+	ejections    int
+	ejectedUntil time.Time
+}
+
+// Pool spreads calls over the replicas of one backend. It implements
+// grpc.ClientConnInterface so generated clients can use it in place of a
+// single connection.
+type Pool struct {
+	config      PoolConfig
+	interceptor grpc.UnaryClientInterceptor
+	mutex       sync.RWMutex
+	replicas    []*replica
+	// updating serializes replica set changes so a DNS refresh cannot
+	// reapply entries that were replaced while it resolved them.
+	updating sync.Mutex
+	// entries are the addresses replicas were resolved from, kept for DNS
+	// refreshes.
+	entries []string
+	closed  bool
+	next    uint32
+	now     func() time.Time
+	cancel  context.CancelFunc
+}
+
+func NewPool(config PoolConfig) (*Pool, error) {
+	if config.Balancer != BalancerRoundRobin && config.Balancer != BalancerLeastRequest {
+		return nil, fmt.Errorf("%s service: unknown balancer %q", config.Name, config.Balancer)
+	}
+	pool := &Pool{
+		config:      config,
+		interceptor: chainUnaryInterceptors(config.Interceptors),
+		now:         time.Now,
+	}
+	if err := pool.Update(context.Background(), config.Addresses); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pool.cancel = cancel
+	if config.DNSRefresh > 0 {
+		go pool.refreshLoop(ctx)
+	}
+	return pool, nil
+}
+
+// Update replaces the replica set with entries, which take the same form as
+// PoolConfig.Addresses. Connections to addresses that stay are kept along
+// with their outlier state.
+func (pool *Pool) Update(ctx context.Context, entries []string) error {
+	pool.updating.Lock()
+	defer pool.updating.Unlock()
+	return pool.update(ctx, entries)
+}
+
+// update applies entries; callers hold the updating mutex.
+func (pool *Pool) update(ctx context.Context, entries []string) error {
+	if pool.closed {
+		return fmt.Errorf("%s service: pool is closed", pool.config.Name)
+	}
+	addresses, err := resolveAddresses(ctx, entries)
+	if err != nil {
+		return fmt.Errorf("%s service: %w", pool.config.Name, err)
+	}
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	current := make(map[string]*replica, len(pool.replicas))
+	for _, replica := range pool.replicas {
+		current[replica.address] = replica
+	}
+	replicas := make([]*replica, 0, len(addresses))
+	var dialed []*replica
+	for _, address := range addresses {
+		if existing, ok := current[address]; ok {
+			replicas = append(replicas, existing)
+			delete(current, address)
+			continue
+		}
+		conn, err := grpc.Dial(address, pool.config.DialOptions...)
+		if err != nil {
+			for _, added := range dialed {
+				added.conn.Close()
+			}
+			return fmt.Errorf("failed to start gRPC connection to %s service at %s: %w", pool.config.Name, address, err)
+		}
+		added := &replica{address: address, conn: conn}
+		replicas = append(replicas, added)
+		dialed = append(dialed, added)
+	}
+	for _, removed := range current {
+		// In-flight calls on a closing connection fail with Canceled; the
+		// replica is gone, so that is the best we can do.
+		removed.conn.Close()
+	}
+	if len(current) > 0 || len(dialed) > 0 {
+		log.Printf("%s service replicas: %s", pool.config.Name, strings.Join(addresses, ", "))
+	}
+	pool.entries = entries
+	pool.replicas = replicas
+	return nil
+}
+
+// Close stops DNS refreshes and closes every connection.
+func (pool *Pool) Close() error {
+	if pool.cancel != nil {
+		pool.cancel()
+	}
+	pool.updating.Lock()
+	defer pool.updating.Unlock()
+	pool.closed = true
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	var firstErr error
+	for _, replica := range pool.replicas {
+		if err := replica.conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	pool.replicas = nil
+	return firstErr
+}
+
+func (pool *Pool) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...grpc.CallOption) error {
+	if pool.interceptor == nil {
+		return pool.invoke(ctx, method, args, reply, nil, opts...)
+	}
+	return pool.interceptor(ctx, method, args, reply, nil, pool.invoke, opts...)
+}
+
+// NewStream refuses streaming calls. The interceptors only cover unary
+// calls, so a stream would skip the breaker, retries, deadlines and
+// tracing; no backend method streams today.
+func (pool *Pool) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return nil, status.Error(codes.Unimplemented, fmt.Sprintf("%s service: streaming call %s is not supported", pool.config.Name, method))
+}
+
+func (pool *Pool) invoke(ctx context.Context, method string, args, reply interface{}, _ *grpc.ClientConn, opts ...grpc.CallOption) error {
+	replica, err := pool.pick()
+	if err != nil {
+		return err
+	}
+	AddBackend(ctx, method)
+	atomic.AddInt64(&replica.active, 1)
+	err = replica.conn.Invoke(ctx, method, args, reply, opts...)
+	atomic.AddInt64(&replica.active, -1)
+	pool.record(ctx, replica, err)
+	return err
+}
+
+// pick chooses a replica among those not ejected. When every replica is
+// ejected it falls back to the whole pool rather than failing outright.
+func (pool *Pool) pick() (*replica, error) {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+	if len(pool.replicas) == 0 {
+		return nil, status.Error(codes.Unavailable, fmt.Sprintf("%s service: %v", pool.config.Name, ErrNoReplicas))
+	}
+	now := pool.now()
+	candidates := make([]*replica, 0, len(pool.replicas))
+	for _, replica := range pool.replicas {
+		if !now.Before(replica.ejectedUntil) {
+			candidates = append(candidates, replica)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = pool.replicas
+	}
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	if pool.config.Balancer == BalancerLeastRequest {
+		// Power of two choices: nearly as good as scanning every replica and
+		// avoids herding on the single least loaded one.
+		first := candidates[rand.Intn(len(candidates))]
+		second := candidates[rand.Intn(len(candidates))]
+		if atomic.LoadInt64(&second.active) < atomic.LoadInt64(&first.active) {
+			return second, nil
+		}
+		return first, nil
+	}
+	index := atomic.AddUint32(&pool.next, 1)
+	return candidates[int(index)%len(candidates)], nil
+}
+
+// record updates outlier state. Failures count by the same rule as the
+// circuit breakers, so a replica that hangs until the deadline is ejected.
+func (pool *Pool) record(ctx context.Context, replica *replica, err error) {
+	outlier := pool.config.Outlier
+	if outlier.ConsecutiveFailures <= 0 {
+		return
+	}
+	failed := backendFailure(ctx, err)
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if !failed {
+		replica.failures = 0
+		return
+	}
+	replica.failures++
+	if replica.failures < outlier.ConsecutiveFailures || !pool.mayEject() {
+		return
+	}
+	replica.failures = 0
+	replica.ejections++
+	ejection := outlier.BaseEjectionTime * time.Duration(replica.ejections)
+	if outlier.MaxEjectionTime > 0 && ejection > outlier.MaxEjectionTime {
+		ejection = outlier.MaxEjectionTime
+	}
+	replica.ejectedUntil = pool.now().Add(ejection)
+	log.Printf("ejecting %s service replica %s for %s after %d consecutive failures", pool.config.Name, replica.address, ejection, outlier.ConsecutiveFailures)
+}
+
+// mayEject keeps the share of ejected replicas under MaxEjectionPercent;
+// callers hold the mutex.
+func (pool *Pool) mayEject() bool {
+	now := pool.now()
+	ejected := 0
+	for _, replica := range pool.replicas {
+		if now.Before(replica.ejectedUntil) {
+			ejected++
+		}
+	}
+	return (ejected+1)*100 <= pool.config.Outlier.MaxEjectionPercent*len(pool.replicas)
+}
+
+// ReplicaStatus is the health of one replica as shown on the admin listener.
+type ReplicaStatus struct {
+	Address string `json:"address"`
+	Status  string `json:"status"`
+	Ejected bool   `json:"ejected,omitempty"`
+	Active  int64  `json:"active"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Probe asks every replica through the gRPC health protocol.
+func (pool *Pool) Probe(ctx context.Context) []ReplicaStatus {
+	pool.mutex.RLock()
+	replicas := append([]*replica(nil), pool.replicas...)
+	ejected := make([]bool, len(replicas))
+	now := pool.now()
+	for i, replica := range replicas {
+		ejected[i] = now.Before(replica.ejectedUntil)
+	}
+	pool.mutex.RUnlock()
+
+	statuses := make([]ReplicaStatus, len(replicas))
+	var wg sync.WaitGroup
+	for i := range replicas {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			statuses[i] = ReplicaStatus{Address: replicas[i].address, Status: "up", Ejected: ejected[i], Active: atomic.LoadInt64(&replicas[i].active)}
+			if err := GRPCHealthCheck(replicas[i].conn)(ctx); err != nil {
+				statuses[i].Status = "down"
+				statuses[i].Error = err.Error()
+			}
+		}(i)
+	}
+	wg.Wait()
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Address < statuses[j].Address })
+	return statuses
+}
+
+// refreshLoop resolves "dns:///" entries again so replicas follow the
+// records of their name.
+func (pool *Pool) refreshLoop(ctx context.Context) {
+	ticker := time.NewTicker(pool.config.DNSRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := pool.refresh(ctx); err != nil {
+				log.Printf("failed to refresh %s service replicas, keeping previous ones: %v", pool.config.Name, err)
+			}
+		}
+	}
+}
+
+func (pool *Pool) refresh(ctx context.Context) error {
+	pool.updating.Lock()
+	defer pool.updating.Unlock()
+	if !hasDNSAddress(pool.entries) {
+		return nil
+	}
+	return pool.update(ctx, pool.entries)
+}
+
+// resolveAddresses expands "dns:///" entries and removes duplicates.
+func resolveAddresses(ctx context.Context, entries []string) ([]string, error) {
+	seen := map[string]bool{}
+	addresses := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		resolved := []string{entry}
+		if strings.HasPrefix(entry, dnsPrefix) {
+			host, port, err := net.SplitHostPort(strings.TrimPrefix(entry, dnsPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("address %q: %w", entry, err)
+			}
+			ips, err := net.DefaultResolver.LookupHost(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			resolved = resolved[:0]
+			for _, ip := range ips {
+				resolved = append(resolved, net.JoinHostPort(ip, port))
+			}
+		}
+		for _, address := range resolved {
+			if !seen[address] {
+				seen[address] = true
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, ErrNoReplicas
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+func hasDNSAddress(entries []string) bool {
+	for _, entry := range entries {
+		if strings.HasPrefix(entry, dnsPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func chainUnaryInterceptors(interceptors []grpc.UnaryClientInterceptor) grpc.UnaryClientInterceptor {
+	if len(interceptors) == 0 {
+		return nil
+	}
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return interceptors[0](ctx, method, req, reply, cc, chainedInvoker(interceptors[1:], invoker), opts...)
+	}
+}
+
+func chainedInvoker(interceptors []grpc.UnaryClientInterceptor, invoker grpc.UnaryInvoker) grpc.UnaryInvoker {
+	if len(interceptors) == 0 {
+		return invoker
+	}
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return interceptors[0](ctx, method, req, reply, cc, chainedInvoker(interceptors[1:], invoker), opts...)
+	}
+}
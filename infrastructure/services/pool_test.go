@@ -0,0 +1,227 @@
+package services
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// stubBackend is a local replica that answers the gRPC health protocol and
+// counts the calls it serves.
+type stubBackend struct {
+	healthpb.UnimplementedHealthServer
+	address string
+	calls   int64
+	hang    int32
+	// conns counts the connections the stub accepted.
+	conns int64
+}
+
+type countingListener struct {
+	net.Listener
+	count *int64
+}
+
+func (listener countingListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err == nil {
+		atomic.AddInt64(listener.count, 1)
+	}
+	return conn, err
+}
+
+func startStub(t testing.TB, options ...grpc.ServerOption) *stubBackend {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &stubBackend{address: listener.Addr().String()}
+	server := grpc.NewServer(options...)
+	healthpb.RegisterHealthServer(server, stub)
+	go server.Serve(countingListener{Listener: listener, count: &stub.conns})
+	t.Cleanup(server.Stop)
+	return stub
+}
+
+func (stub *stubBackend) Check(ctx context.Context, request *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	atomic.AddInt64(&stub.calls, 1)
+	if atomic.LoadInt32(&stub.hang) == 1 {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func testPool(t testing.TB, config PoolConfig, stubs ...*stubBackend) *Pool {
+	t.Helper()
+	for _, stub := range stubs {
+		config.Addresses = append(config.Addresses, stub.address)
+	}
+	config.Name = "test"
+	config.DialOptions = append(config.DialOptions, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	return pool
+}
+
+func TestPoolEjectsHangingReplica(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     func() (context.Context, context.CancelFunc)
+		ejected bool
+	}{
+		{"deadline runs out", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 20*time.Millisecond)
+		}, true},
+		{"caller hangs up", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(20*time.Millisecond, cancel)
+			return ctx, cancel
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			healthy, hanging := startStub(t), startStub(t)
+			atomic.StoreInt32(&hanging.hang, 1)
+			pool := testPool(t, PoolConfig{
+				Balancer: BalancerRoundRobin,
+				Outlier: OutlierConfig{
+					ConsecutiveFailures: 2,
+					BaseEjectionTime:    time.Minute,
+					MaxEjectionPercent:  50,
+				},
+			}, healthy, hanging)
+			client := healthpb.NewHealthClient(pool)
+			for atomic.LoadInt64(&hanging.calls) < 2 {
+				ctx, cancel := test.ctx()
+				client.Check(ctx, &healthpb.HealthCheckRequest{})
+				cancel()
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			statuses := pool.Probe(ctx)
+			ejected := false
+			for _, status := range statuses {
+				if status.Address == hanging.address {
+					ejected = status.Ejected
+				}
+			}
+			if ejected != test.ejected {
+				t.Errorf("got ejected %v, want %v", ejected, test.ejected)
+			}
+		})
+	}
+}
+
+func TestPoolBalancesAcrossReplicas(t *testing.T) {
+	tests := []struct {
+		name     string
+		balancer string
+		replicas int
+		calls    int
+		// spread is the largest allowed difference between the busiest and
+		// the idlest replica.
+		spread int64
+	}{
+		{"round robin over one", BalancerRoundRobin, 1, 10, 0},
+		{"round robin over three", BalancerRoundRobin, 3, 30, 0},
+		{"least request over three", BalancerLeastRequest, 3, 300, 60},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stubs := make([]*stubBackend, test.replicas)
+			for i := range stubs {
+				stubs[i] = startStub(t)
+			}
+			client := healthpb.NewHealthClient(testPool(t, PoolConfig{Balancer: test.balancer}, stubs...))
+			for i := 0; i < test.calls; i++ {
+				if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			total, least, most := int64(0), int64(test.calls), int64(0)
+			for _, stub := range stubs {
+				calls := atomic.LoadInt64(&stub.calls)
+				total += calls
+				if calls < least {
+					least = calls
+				}
+				if calls > most {
+					most = calls
+				}
+				if conns := atomic.LoadInt64(&stub.conns); conns != 1 {
+					t.Errorf("replica %s accepted %d connections, want 1", stub.address, conns)
+				}
+			}
+			if total != int64(test.calls) || most-least > test.spread {
+				t.Errorf("got %d calls spread %d..%d, want %d within %d", total, least, most, test.calls, test.spread)
+			}
+		})
+	}
+}
+
+func TestPoolRejectsStreams(t *testing.T) {
+	stub := startStub(t)
+	client := healthpb.NewHealthClient(testPool(t, PoolConfig{Balancer: BalancerRoundRobin}, stub))
+	_, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("got %v, want Unimplemented", err)
+	}
+}
+
+// BenchmarkConnectionReuse compares dialing the backend for every call, as
+// the handlers once did, with the shared pool. conns/op is the number of
+// connections the backend accepted per call.
+func BenchmarkConnectionReuse(b *testing.B) {
+	request := &healthpb.HealthCheckRequest{}
+	benchmarks := []struct {
+		name string
+		call func(b *testing.B, stub *stubBackend) func()
+	}{
+		{"dial per call", func(b *testing.B, stub *stubBackend) func() {
+			return func() {
+				conn, err := grpc.Dial(stub.address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer conn.Close()
+				if _, err := healthpb.NewHealthClient(conn).Check(context.Background(), request); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}},
+		{"shared pool", func(b *testing.B, stub *stubBackend) func() {
+			client := healthpb.NewHealthClient(testPool(b, PoolConfig{Balancer: BalancerRoundRobin}, stub))
+			return func() {
+				if _, err := client.Check(context.Background(), request); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}},
+	}
+	for _, benchmark := range benchmarks {
+		b.Run(benchmark.name, func(b *testing.B) {
+			stub := startStub(b)
+			call := benchmark.call(b, stub)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				call()
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(atomic.LoadInt64(&stub.conns))/float64(b.N), "conns/op")
+		})
+	}
+}
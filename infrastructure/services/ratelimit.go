@@ -0,0 +1,292 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	RateLimitByUser   = "user"
+	RateLimitByIP     = "ip"
+	RateLimitByAPIKey = "apikey"
+
+	bucketSweepInterval = time.Minute
+)
+
+// RateLimit is a token bucket applied to every caller of a route. Callers
+// may burst up to Burst requests and then get Requests per Per.
+type RateLimit struct {
+	// Method is an HTTP method or "*" for any.
+	Method  string
+	Pattern string
+	// Key groups callers: RateLimitByUser, RateLimitByIP or RateLimitByAPIKey.
+	// User and API key limits fall back to the client IP for anonymous calls
+	// and unknown keys.
+	Key      string
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+// ParseRateLimit reads "<method> <pattern> <key> <requests>/<duration> [burst]",
+// for example "POST /login ip 5/1m 10".
+func ParseRateLimit(value string) (RateLimit, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 4 && len(fields) != 5 {
+		return RateLimit{}, fmt.Errorf("rate limit %q: want \"<method> <pattern> <key> <requests>/<duration> [burst]\"", value)
+	}
+	limit := RateLimit{Method: strings.ToUpper(fields[0]), Pattern: fields[1], Key: fields[2]}
+	switch limit.Key {
+	case RateLimitByUser, RateLimitByIP, RateLimitByAPIKey:
+	default:
+		return RateLimit{}, fmt.Errorf("rate limit %q: unknown key %q", value, limit.Key)
+	}
+	requests, per, found := strings.Cut(fields[3], "/")
+	if !found {
+		return RateLimit{}, fmt.Errorf("rate limit %q: rate must look like 10/1m", value)
+	}
+	var err error
+	if limit.Requests, err = strconv.Atoi(requests); err != nil || limit.Requests <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q: invalid request count %q", value, requests)
+	}
+	if limit.Per, err = time.ParseDuration(per); err != nil || limit.Per <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q: invalid duration %q", value, per)
+	}
+	limit.Burst = limit.Requests
+	if len(fields) == 5 {
+		if limit.Burst, err = strconv.Atoi(fields[4]); err != nil || limit.Burst <= 0 {
+			return RateLimit{}, fmt.Errorf("rate limit %q: invalid burst %q", value, fields[4])
+		}
+	}
+	return limit, nil
+}
+
+type RateLimiterConfig struct {
+	Limits []RateLimit
+	// TrustedProxies lists the networks whose X-Forwarded-For is believed.
+	TrustedProxies []string
+	APIKeyHeader   string
+	// APIKeys are the keys that get a bucket of their own. Any other key
+	// shares the bucket of its client IP, so a caller cannot escape the
+	// limit by sending a new key with every request.
+	APIKeys []string
+}
+
+type compiledLimit struct {
+	RateLimit
+	segments []string
+	// refill is the number of tokens added per second.
+	refill float64
+}
+
+type bucket struct {
+	limit   *compiledLimit
+	tokens  float64
+	updated time.Time
+}
+
+// RateLimiter throttles requests with one token bucket per route and caller.
+// Requests that match no limit are not throttled.
+type RateLimiter struct {
+	limits         []compiledLimit
+	trustedProxies []*net.IPNet
+	apiKeyHeader   string
+	apiKeys        map[string]bool
+	rejected       *prometheus.CounterVec
+	mutex          sync.Mutex
+	buckets        map[string]*bucket
+	sweptAt        time.Time
+	now            func() time.Time
+}
+
+// NewRateLimiter builds the limiter. rejected counts throttled requests and
+// must have the labels "route" and "key".
+func NewRateLimiter(config RateLimiterConfig, rejected *prometheus.CounterVec) (*RateLimiter, error) {
+	limiter := &RateLimiter{
+		limits:       make([]compiledLimit, 0, len(config.Limits)),
+		apiKeyHeader: config.APIKeyHeader,
+		apiKeys:      make(map[string]bool, len(config.APIKeys)),
+		rejected:     rejected,
+		buckets:      map[string]*bucket{},
+		now:          time.Now,
+	}
+	for _, limit := range config.Limits {
+		limiter.limits = append(limiter.limits, compiledLimit{
+			RateLimit: limit,
+			segments:  splitPath(limit.Pattern),
+			refill:    float64(limit.Requests) / limit.Per.Seconds(),
+		})
+	}
+	for _, key := range config.APIKeys {
+		limiter.apiKeys[key] = true
+	}
+	for _, cidr := range config.TrustedProxies {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", cidr, err)
+		}
+		limiter.trustedProxies = append(limiter.trustedProxies, network)
+	}
+	limiter.sweptAt = limiter.now()
+	return limiter, nil
+}
+
+func (limiter *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := limiter.match(r.Method, r.URL.Path)
+		if limit == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		kind, key := limiter.callerKey(r, limit.Key)
+		allowed, remaining, retryAfter, reset := limiter.take(limit, kind+":"+key)
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+		if !allowed {
+			limiter.rejected.WithLabelValues(limit.Method+" "+limit.Pattern, kind).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+			WriteStatus(w, r, codes.ResourceExhausted, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// match picks the most specific limit the same way PolicyTable does.
+func (limiter *RateLimiter) match(method string, path string) *compiledLimit {
+	segments := splitPath(path)
+	var best *compiledLimit
+	bestScore := -1
+	for i := range limiter.limits {
+		limit := &limiter.limits[i]
+		if (limit.Method != "*" && limit.Method != method) || len(limit.segments) != len(segments) {
+			continue
+		}
+		if _, score, ok := matchSegments(limit.segments, segments); ok && score > bestScore {
+			best, bestScore = limit, score
+		}
+	}
+	return best
+}
+
+// callerKey returns the kind of key actually used and its value.
+func (limiter *RateLimiter) callerKey(r *http.Request, kind string) (string, string) {
+	switch kind {
+	case RateLimitByUser:
+		if principal, ok := PrincipalFromContext(r.Context()); ok {
+			return RateLimitByUser, principal.Id
+		}
+	case RateLimitByAPIKey:
+		if key := r.Header.Get(limiter.apiKeyHeader); limiter.apiKeys[key] {
+			return RateLimitByAPIKey, key
+		}
+	}
+	return RateLimitByIP, limiter.ClientIP(r)
+}
+
+// ClientIP returns the address of the caller. X-Forwarded-For is walked from
+// the right and only trusted proxies may add to it, so a client cannot pick
+// its own address by sending the header.
+func (limiter *RateLimiter) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !limiter.trusted(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !limiter.trusted(hop) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+func (limiter *RateLimiter) trusted(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range limiter.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// take removes a token from the caller's bucket. It returns whether the
+// request may pass, the whole tokens left, how long until the next token and
+// how long until the bucket is full again.
+func (limiter *RateLimiter) take(limit *compiledLimit, key string) (bool, int, time.Duration, time.Duration) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.now()
+	if now.Sub(limiter.sweptAt) >= bucketSweepInterval {
+		limiter.sweep(now)
+	}
+	bucketKey := limit.Method + " " + limit.Pattern + "|" + key
+	b, ok := limiter.buckets[bucketKey]
+	if !ok {
+		b = &bucket{limit: limit, tokens: float64(limit.Burst), updated: now}
+		limiter.buckets[bucketKey] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*limit.refill)
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	reset := seconds((float64(limit.Burst) - b.tokens) / limit.refill)
+	retryAfter := time.Duration(0)
+	if !allowed {
+		retryAfter = seconds((1 - b.tokens) / limit.refill)
+	}
+	return allowed, int(b.tokens), retryAfter, reset
+}
+
+// sweep forgets buckets that have refilled completely, since a new bucket
+// starts full anyway. Callers hold the mutex.
+func (limiter *RateLimiter) sweep(now time.Time) {
+	for key, b := range limiter.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.limit.refill >= float64(b.limit.Burst) {
+			delete(limiter.buckets, key)
+		}
+	}
+	limiter.sweptAt = now
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
+func ceilSeconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
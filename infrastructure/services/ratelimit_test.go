@@ -0,0 +1,186 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRateLimiterAPIKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		// requests lists the remote address and API key of each call.
+		requests [][2]string
+		allowed  int
+	}{
+		{"new key per request shares the IP bucket", [][2]string{
+			{"10.0.0.1:1000", "random-1"},
+			{"10.0.0.1:1000", "random-2"},
+			{"10.0.0.1:1000", "random-3"},
+			{"10.0.0.1:1000", "random-4"},
+		}, 2},
+		{"no key is limited by IP", [][2]string{
+			{"10.0.0.1:1000", ""},
+			{"10.0.0.1:1000", ""},
+			{"10.0.0.1:1000", ""},
+		}, 2},
+		{"known keys get their own buckets", [][2]string{
+			{"10.0.0.1:1000", "board-a"},
+			{"10.0.0.1:1000", "board-a"},
+			{"10.0.0.1:1000", "board-b"},
+			{"10.0.0.1:1000", "board-b"},
+			{"10.0.0.1:1000", "board-a"},
+		}, 4},
+		{"known key from many addresses shares its bucket", [][2]string{
+			{"10.0.0.1:1000", "board-a"},
+			{"10.0.0.2:1000", "board-a"},
+			{"10.0.0.3:1000", "board-a"},
+		}, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter, err := NewRateLimiter(RateLimiterConfig{
+				Limits:       []RateLimit{{Method: "POST", Pattern: "/post/job", Key: RateLimitByAPIKey, Requests: 2, Per: time.Hour, Burst: 2}},
+				APIKeyHeader: "X-Api-Key",
+				APIKeys:      []string{"board-a", "board-b"},
+			}, prometheus.NewCounterVec(prometheus.CounterOpts{Name: "rejected"}, []string{"route", "key"}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			allowed := 0
+			for i, call := range test.requests {
+				request := httptest.NewRequest("POST", "/post/job", nil)
+				request.RemoteAddr = call[0]
+				if call[1] != "" {
+					request.Header.Set("X-Api-Key", call[1])
+				}
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, request)
+				switch recorder.Code {
+				case http.StatusOK:
+					allowed++
+				case http.StatusTooManyRequests:
+					if recorder.Header().Get("Retry-After") == "" {
+						t.Errorf("request %d: rejection without Retry-After", i)
+					}
+				default:
+					t.Fatalf("request %d: unexpected status %d", i, recorder.Code)
+				}
+			}
+			if allowed != test.allowed {
+				t.Errorf("allowed %d requests, want %d", allowed, test.allowed)
+			}
+		})
+	}
+}
+
+func TestRateLimiterHeaders(t *testing.T) {
+	rejected := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "rejected"}, []string{"route", "key"})
+	limiter, err := NewRateLimiter(RateLimiterConfig{
+		// One token every 20 seconds.
+		Limits: []RateLimit{{Method: "GET", Pattern: "/profile/{id}", Key: RateLimitByUser, Requests: 3, Per: time.Minute, Burst: 3}},
+	}, rejected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	limiter.now = func() time.Time { return now }
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	steps := []struct {
+		name       string
+		after      time.Duration
+		user       string
+		status     int
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		{"first request", 0, "", http.StatusOK, "2", "20", ""},
+		{"second request", 0, "", http.StatusOK, "1", "40", ""},
+		{"last token", 0, "", http.StatusOK, "0", "60", ""},
+		{"empty bucket", 0, "", http.StatusTooManyRequests, "0", "60", "20"},
+		{"half a token", 10 * time.Second, "", http.StatusTooManyRequests, "0", "50", "10"},
+		{"refilled token", 10 * time.Second, "", http.StatusOK, "0", "60", ""},
+		{"user has a bucket of its own", 0, "alice", http.StatusOK, "2", "20", ""},
+		{"user bucket", 0, "alice", http.StatusOK, "1", "40", ""},
+		{"user bucket", 0, "alice", http.StatusOK, "0", "60", ""},
+		{"user bucket empty", 0, "alice", http.StatusTooManyRequests, "0", "60", "20"},
+	}
+	for _, step := range steps {
+		now = now.Add(step.after)
+		request := httptest.NewRequest("GET", "/profile/7", nil)
+		request.RemoteAddr = "10.0.0.1:1000"
+		if step.user != "" {
+			request = request.WithContext(ContextWithPrincipal(request.Context(), &Principal{Id: step.user}))
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != step.status {
+			t.Fatalf("%s: got status %d, want %d", step.name, recorder.Code, step.status)
+		}
+		header := recorder.Header()
+		if header.Get("RateLimit-Limit") != "3" || header.Get("RateLimit-Remaining") != step.remaining || header.Get("RateLimit-Reset") != step.reset {
+			t.Errorf("%s: got limit %s, remaining %s, reset %s, want 3, %s, %s", step.name,
+				header.Get("RateLimit-Limit"), header.Get("RateLimit-Remaining"), header.Get("RateLimit-Reset"), step.remaining, step.reset)
+		}
+		if got := header.Get("Retry-After"); got != step.retryAfter {
+			t.Errorf("%s: got Retry-After %q, want %q", step.name, got, step.retryAfter)
+		}
+	}
+
+	if got := testutil.ToFloat64(rejected.WithLabelValues("GET /profile/{id}", RateLimitByIP)); got != 2 {
+		t.Errorf("got %v rejections by IP, want 2", got)
+	}
+	if got := testutil.ToFloat64(rejected.WithLabelValues("GET /profile/{id}", RateLimitByUser)); got != 1 {
+		t.Errorf("got %v rejections by user, want 1", got)
+	}
+
+	request := httptest.NewRequest("GET", "/post", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Header().Get("RateLimit-Limit") != "" {
+		t.Error("request without a limit got rate limit headers")
+	}
+}
+
+func TestRateLimiterClientIP(t *testing.T) {
+	limiter, err := NewRateLimiter(RateLimiterConfig{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1", "::1"}},
+		prometheus.NewCounterVec(prometheus.CounterOpts{Name: "rejected"}, []string{"route", "key"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{"direct client", "203.0.113.5:4000", nil, "203.0.113.5"},
+		{"untrusted peer cannot forward", "203.0.113.5:4000", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"trusted proxy", "10.0.0.2:4000", []string{"203.0.113.5"}, "203.0.113.5"},
+		{"chain of trusted proxies", "192.168.1.1:4000", []string{"203.0.113.5, 10.0.0.7"}, "203.0.113.5"},
+		{"client cannot prepend an address", "10.0.0.2:4000", []string{"198.51.100.1, 203.0.113.5, 10.0.0.7"}, "203.0.113.5"},
+		{"headers from several proxies", "10.0.0.2:4000", []string{"198.51.100.1, 203.0.113.5", "10.0.0.7"}, "203.0.113.5"},
+		{"trusted address on its own", "10.0.0.2:4000", []string{"10.0.0.9"}, "10.0.0.9"},
+		{"untrusted neighbour of a trusted address", "192.168.1.2:4000", []string{"203.0.113.5"}, "192.168.1.2"},
+		{"IPv6 proxy", "[::1]:4000", []string{"2001:db8::1"}, "2001:db8::1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "/", nil)
+			request.RemoteAddr = test.remoteAddr
+			for _, value := range test.forwardedFor {
+				request.Header.Add("X-Forwarded-For", value)
+			}
+			if got := limiter.ClientIP(request); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Recover turns a panic in next into a 500 answer and logs the stack, so a
+// single bad request cannot take the gateway down.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				// Deliberate abort of a response; let net/http handle it.
+				panic(recovered)
+			}
+			log.Printf("panic serving %s %s (request id %q): %v\n%s", r.Method, r.URL.Path, requestId(w, r), recovered, debug.Stack())
+			WriteStatus(w, r, codes.Internal, "internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
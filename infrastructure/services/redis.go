@@ -0,0 +1,183 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const maxIdleRedisConns = 8
+
+var errUnexpectedReply = errors.New("unexpected reply from redis")
+
+// redisError is an error reply sent by the server.
+type redisError string
+
+func (err redisError) Error() string {
+	return "redis: " + string(err)
+}
+
+// redisClient speaks just enough RESP to run simple commands over a small
+// pool of connections.
+type redisClient struct {
+	config RedisConfig
+	idle   chan *redisConn
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newRedisClient(config RedisConfig) *redisClient {
+	return &redisClient{config: config, idle: make(chan *redisConn, maxIdleRedisConns)}
+}
+
+// do runs one command. Replies are string, int64, nil or []interface{}.
+func (client *redisClient) do(ctx context.Context, args ...string) (interface{}, error) {
+	conn, err := client.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.roundTrip(ctx, client.config.Timeout, args)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		// The stream may hold half a reply; never reuse the connection.
+		conn.conn.Close()
+		return nil, err
+	}
+	client.put(conn)
+	return reply, err
+}
+
+func (client *redisClient) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-client.idle:
+		return conn, nil
+	default:
+	}
+	dialer := &net.Dialer{Timeout: client.config.Timeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", client.config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to redis at %s: %w", client.config.Address, err)
+	}
+	conn := &redisConn{conn: netConn, reader: bufio.NewReader(netConn)}
+	if client.config.Password != "" {
+		if _, err := conn.roundTrip(ctx, client.config.Timeout, []string{"AUTH", client.config.Password}); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	if client.config.Database != 0 {
+		if _, err := conn.roundTrip(ctx, client.config.Timeout, []string{"SELECT", strconv.Itoa(client.config.Database)}); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (client *redisClient) put(conn *redisConn) {
+	select {
+	case client.idle <- conn:
+	default:
+		conn.conn.Close()
+	}
+}
+
+func (client *redisClient) close() error {
+	for {
+		select {
+		case conn := <-client.idle:
+			conn.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (conn *redisConn) roundTrip(ctx context.Context, timeout time.Duration, args []string) (interface{}, error) {
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	command := make([]byte, 0, 64)
+	command = append(command, '*')
+	command = strconv.AppendInt(command, int64(len(args)), 10)
+	command = append(command, '\r', '\n')
+	for _, arg := range args {
+		command = append(command, '$')
+		command = strconv.AppendInt(command, int64(len(arg)), 10)
+		command = append(command, '\r', '\n')
+		command = append(command, arg...)
+		command = append(command, '\r', '\n')
+	}
+	if _, err := conn.conn.Write(command); err != nil {
+		return nil, err
+	}
+	return readReply(conn.reader)
+}
+
+func readReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errUnexpectedReply
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		length, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, errUnexpectedReply
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		value := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, value); err != nil {
+			return nil, err
+		}
+		return string(value[:length]), nil
+	case '*':
+		length, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, errUnexpectedReply
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, length)
+		for i := range values {
+			// Error replies inside an array are kept as values.
+			value, err := readReply(reader)
+			var replyErr redisError
+			if err != nil && !errors.As(err, &replyErr) {
+				return nil, err
+			}
+			if err != nil {
+				value = replyErr
+			}
+			values[i] = value
+		}
+		return values, nil
+	}
+	return nil, errUnexpectedReply
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxRequestIdLength bounds ids taken from callers so a client cannot bloat
+// every log line and backend call with its own value.
+const maxRequestIdLength = 128
+
+// RequestId makes sure every request carries an X-Request-Id. A valid id
+// sent by the caller is kept, anything else is replaced. The id is echoed to
+// the caller, forwarded to backends and included in error bodies.
+func RequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIdHeader)
+		if !validRequestId(id) {
+			id = newRequestId()
+			r.Header.Set(RequestIdHeader, id)
+		}
+		w.Header().Set(RequestIdHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestId(t *testing.T) {
+	tests := []struct {
+		name string
+		sent string
+		kept bool
+	}{
+		{"missing", "", false},
+		{"valid", "req-42.a:b_c", true},
+		{"invalid characters", "req 42\n", false},
+		{"too long", strings.Repeat("a", maxRequestIdLength+1), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var forwarded []string
+			// Without an access log, a panic must still answer with the id.
+			handler := RequestId(Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = OutgoingMetadata(r).Get("x-request-id")
+				panic("backend response was nil")
+			})))
+			request := httptest.NewRequest("GET", "/post", nil)
+			if test.sent != "" {
+				request.Header.Set(RequestIdHeader, test.sent)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			id := recorder.Header().Get(RequestIdHeader)
+			if !validRequestId(id) {
+				t.Fatalf("answered with invalid id %q", id)
+			}
+			if (id == test.sent) != test.kept {
+				t.Errorf("got id %q for sent %q, want kept %v", id, test.sent, test.kept)
+			}
+			if len(forwarded) != 1 || forwarded[0] != id {
+				t.Errorf("forwarded %v to backends, want %q", forwarded, id)
+			}
+			var body ErrorBody
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if recorder.Code != http.StatusInternalServerError || body.RequestId != id {
+				t.Errorf("got status %d with request id %q, want 500 with %q", recorder.Code, body.RequestId, id)
+			}
+		})
+	}
+}
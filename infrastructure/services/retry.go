@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryPolicy retries unary calls to idempotent methods that failed with a
+// transient error. The zero value disables retries.
+type RetryPolicy struct {
+	// MaxAttempts counts the first call; 1 or less means no retries.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is the fraction of each backoff that is randomised, from 0 to 1.
+	Jitter float64
+	// Methods lists the idempotent methods, either bare ("GetAll") or
+	// qualified by service ("PostService/GetAll").
+	Methods []string
+	// RetryableCodes defaults to Unavailable.
+	RetryableCodes []codes.Code
+	// BudgetTokens and BudgetRatio configure the retry budget of each
+	// backend: a failure costs one token, a success earns BudgetRatio tokens
+	// and retries stop while fewer than half of BudgetTokens are left.
+	BudgetTokens float64
+	BudgetRatio  float64
+}
+
+// RetryMetrics count retries per backend and method. Both vectors need the
+// labels "service" and "method".
+type RetryMetrics struct {
+	Retries         *prometheus.CounterVec
+	BudgetExhausted *prometheus.CounterVec
+}
+
+// retryBudget is the throttling scheme of the gRPC retry design: retries
+// are only allowed while the backend mostly succeeds.
+type retryBudget struct {
+	mutex     sync.Mutex
+	tokens    float64
+	maxTokens float64
+	ratio     float64
+}
+
+func (budget *retryBudget) onSuccess() {
+	budget.mutex.Lock()
+	budget.tokens = math.Min(budget.maxTokens, budget.tokens+budget.ratio)
+	budget.mutex.Unlock()
+}
+
+// onFailure records a failure and reports whether a retry is allowed.
+func (budget *retryBudget) onFailure() bool {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	budget.tokens = math.Max(0, budget.tokens-1)
+	return budget.tokens > budget.maxTokens/2
+}
+
+type retrier struct {
+	service   string
+	policy    RetryPolicy
+	methods   map[string]bool
+	retryable map[codes.Code]bool
+	budget    *retryBudget
+	metrics   RetryMetrics
+}
+
+// RetryInterceptor returns the interceptor for one backend; each backend
+// gets its own budget so a failing service cannot starve the others.
+func RetryInterceptor(service string, policy RetryPolicy, metrics RetryMetrics) grpc.UnaryClientInterceptor {
+	retrier := &retrier{
+		service:   service,
+		policy:    policy,
+		methods:   map[string]bool{},
+		retryable: map[codes.Code]bool{},
+		budget:    &retryBudget{tokens: policy.BudgetTokens, maxTokens: policy.BudgetTokens, ratio: policy.BudgetRatio},
+		metrics:   metrics,
+	}
+	for _, method := range policy.Methods {
+		retrier.methods[method] = true
+	}
+	for _, code := range policy.RetryableCodes {
+		retrier.retryable[code] = true
+	}
+	if len(retrier.retryable) == 0 {
+		retrier.retryable[codes.Unavailable] = true
+	}
+	return retrier.intercept
+}
+
+func (retrier *retrier) intercept(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if retrier.policy.MaxAttempts <= 1 || !retrier.idempotent(method) {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	retries := 0
+	defer func() {
+		if retries == 0 {
+			return
+		}
+		if span := opentracing.SpanFromContext(ctx); span != nil {
+			span.SetTag("grpc.retries", retries)
+		}
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("grpc.retries", retries))
+	}()
+	for attempt := 1; ; attempt++ {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil {
+			retrier.budget.onSuccess()
+			return nil
+		}
+		if !retrier.retryable[status.Code(err)] {
+			return err
+		}
+		allowed := retrier.budget.onFailure()
+		if attempt >= retrier.policy.MaxAttempts {
+			return err
+		}
+		if !allowed {
+			retrier.metrics.BudgetExhausted.WithLabelValues(retrier.service, method).Inc()
+			return err
+		}
+		if !sleepContext(ctx, retrier.backoff(attempt)) {
+			return err
+		}
+		retries++
+		retrier.metrics.Retries.WithLabelValues(retrier.service, method).Inc()
+	}
+}
+
+// idempotent matches "/pkg.PostService/GetAll" against "GetAll" and
+// "PostService/GetAll".
+func (retrier *retrier) idempotent(fullMethod string) bool {
+	service, name, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if index := strings.LastIndex(service, "."); index >= 0 {
+		service = service[index+1:]
+	}
+	return retrier.methods[name] || retrier.methods[service+"/"+name]
+}
+
+// backoff grows exponentially with the attempt and randomises the top
+// Jitter fraction so retries from many requests spread out.
+func (retrier *retrier) backoff(attempt int) time.Duration {
+	policy := retrier.policy
+	backoff := float64(policy.InitialBackoff) * math.Pow(policy.Multiplier, float64(attempt-1))
+	if policy.MaxBackoff > 0 {
+		backoff = math.Min(backoff, float64(policy.MaxBackoff))
+	}
+	jitter := math.Min(math.Max(policy.Jitter, 0), 1)
+	return time.Duration(backoff * (1 - jitter*rand.Float64()))
+}
+
+// sleepContext waits for d and reports false when ctx ends first, or would
+// end before the next attempt could start.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= d {
+		return false
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestRetryMetrics() RetryMetrics {
+	return RetryMetrics{
+		Retries:         prometheus.NewCounterVec(prometheus.CounterOpts{Name: "retries"}, []string{"service", "method"}),
+		BudgetExhausted: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "budget_exhausted"}, []string{"service", "method"}),
+	}
+}
+
+// failingInvoker answers with errs in turn and succeeds once they run out.
+func failingInvoker(calls *int, errs ...error) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		*calls++
+		if *calls <= len(errs) {
+			return errs[*calls-1]
+		}
+		return nil
+	}
+}
+
+func TestRetryInterceptor(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	exhausted := status.Error(codes.ResourceExhausted, "slow down")
+	policy := RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		Multiplier:     2,
+		Methods:        []string{"GetAll", "PostService/Get"},
+		BudgetTokens:   10,
+		BudgetRatio:    0.1,
+	}
+	tests := []struct {
+		name   string
+		policy func(*RetryPolicy)
+		method string
+		errs   []error
+		calls  int
+		code   codes.Code
+	}{
+		{"recovers after retries", nil, "/post.PostService/GetAll", []error{unavailable, unavailable}, 3, codes.OK},
+		{"method qualified by service", nil, "/post.PostService/Get", []error{unavailable}, 2, codes.OK},
+		{"same method of another service", nil, "/profile.ProfileService/Get", []error{unavailable}, 1, codes.Unavailable},
+		{"not idempotent", nil, "/post.PostService/Create", []error{unavailable}, 1, codes.Unavailable},
+		{"not retryable", nil, "/post.PostService/GetAll", []error{status.Error(codes.NotFound, "no post")}, 1, codes.NotFound},
+		{"stops at MaxAttempts", nil, "/post.PostService/GetAll", []error{unavailable, unavailable, unavailable, unavailable}, 3, codes.Unavailable},
+		{"retries disabled", func(policy *RetryPolicy) { policy.MaxAttempts = 1 }, "/post.PostService/GetAll", []error{unavailable}, 1, codes.Unavailable},
+		{"custom retryable codes", func(policy *RetryPolicy) { policy.RetryableCodes = []codes.Code{codes.ResourceExhausted} }, "/post.PostService/GetAll", []error{exhausted, unavailable}, 2, codes.Unavailable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := policy
+			if test.policy != nil {
+				test.policy(&policy)
+			}
+			metrics := newTestRetryMetrics()
+			calls := 0
+			err := RetryInterceptor("post", policy, metrics)(context.Background(), test.method, nil, nil, nil, failingInvoker(&calls, test.errs...))
+			if status.Code(err) != test.code {
+				t.Errorf("got %v, want %s", err, test.code)
+			}
+			if calls != test.calls {
+				t.Errorf("got %d calls, want %d", calls, test.calls)
+			}
+			if got := testutil.ToFloat64(metrics.Retries.WithLabelValues("post", test.method)); got != float64(test.calls-1) {
+				t.Errorf("got %v retries counted, want %d", got, test.calls-1)
+			}
+		})
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	metrics := newTestRetryMetrics()
+	// Retries stop once two or fewer of the four tokens are left.
+	interceptor := RetryInterceptor("post", RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Millisecond,
+		Multiplier:     1,
+		Methods:        []string{"GetAll"},
+		BudgetTokens:   4,
+		BudgetRatio:    0.5,
+	}, metrics)
+	const method = "/post.PostService/GetAll"
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	steps := []struct {
+		name      string
+		errs      []error
+		calls     int
+		exhausted float64
+	}{
+		{"retried while the budget lasts", []error{unavailable, unavailable, unavailable, unavailable, unavailable}, 2, 1},
+		{"no retry on an exhausted budget", []error{unavailable, unavailable}, 1, 2},
+		{"successes refill the budget", nil, 1, 2},
+		{"success", nil, 1, 2},
+		{"success", nil, 1, 2},
+		{"success", nil, 1, 2},
+		{"success", nil, 1, 2},
+		{"success", nil, 1, 2},
+		{"retried again", []error{unavailable}, 2, 2},
+	}
+	for _, step := range steps {
+		calls := 0
+		interceptor(context.Background(), method, nil, nil, nil, failingInvoker(&calls, step.errs...))
+		if calls != step.calls {
+			t.Errorf("%s: got %d calls, want %d", step.name, calls, step.calls)
+		}
+		if got := testutil.ToFloat64(metrics.BudgetExhausted.WithLabelValues("post", method)); got != step.exhausted {
+			t.Errorf("%s: got %v exhausted budgets, want %v", step.name, got, step.exhausted)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 500 * time.Millisecond, Multiplier: 2}
+	tests := []struct {
+		name   string
+		jitter float64
+		// low is the shortest backoff allowed as a fraction of the full one.
+		low float64
+	}{
+		{"no jitter", 0, 1},
+		{"jitter", 0.2, 0.8},
+		{"jitter clamped to 1", 5, 0},
+	}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 500 * time.Millisecond, 500 * time.Millisecond}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := policy
+			policy.Jitter = test.jitter
+			retrier := &retrier{policy: policy}
+			for attempt, full := range want {
+				low := time.Duration(float64(full) * test.low)
+				for i := 0; i < 200; i++ {
+					if got := retrier.backoff(attempt + 1); got < low || got > full {
+						t.Fatalf("attempt %d: got backoff %s, want %s to %s", attempt+1, got, low, full)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRetryStopsBeforeTheDeadline(t *testing.T) {
+	interceptor := RetryInterceptor("post", RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Second,
+		Multiplier:     1,
+		Methods:        []string{"GetAll"},
+		BudgetTokens:   10,
+		BudgetRatio:    0.1,
+	}, newTestRetryMetrics())
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	calls := 0
+	started := time.Now()
+	err := interceptor(ctx, "/post.PostService/GetAll", nil, nil, nil, failingInvoker(&calls, status.Error(codes.Unavailable, "connection refused")))
+	if status.Code(err) != codes.Unavailable || calls != 1 {
+		t.Errorf("got %v after %d calls, want Unavailable after 1", err, calls)
+	}
+	if elapsed := time.Since(started); elapsed >= 100*time.Millisecond {
+		t.Errorf("gave up after %s, want at once", elapsed)
+	}
+}
+
+func TestRetrySpanTags(t *testing.T) {
+	tracer := mocktracer.New()
+	otelTracing, exporter := newTestOtelTracing(t, OtelConfig{Sampler: SamplerParent, SampleRatio: 1})
+	ctx, otelSpan := otelTracing.tracer.Start(context.Background(), "GET /post")
+	span := tracer.StartSpan("GET /post")
+	ctx = opentracing.ContextWithSpan(ctx, span)
+
+	interceptor := RetryInterceptor("post", RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		Multiplier:     1,
+		Methods:        []string{"GetAll"},
+		BudgetTokens:   10,
+		BudgetRatio:    0.1,
+	}, newTestRetryMetrics())
+	calls := 0
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	if err := interceptor(ctx, "/post.PostService/GetAll", nil, nil, nil, failingInvoker(&calls, unavailable, unavailable)); err != nil {
+		t.Fatal(err)
+	}
+	span.Finish()
+	otelSpan.End()
+
+	if got := tracer.FinishedSpans()[0].Tag("grpc.retries"); got != 2 {
+		t.Errorf("got OpenTracing tag grpc.retries %v, want 2", got)
+	}
+	spans := exportedSpans(t, otelTracing, exporter)
+	retries := false
+	for _, attribute := range spans[0].Attributes {
+		retries = retries || (attribute.Key == "grpc.retries" && attribute.Value.AsInt64() == 2)
+	}
+	if !retries {
+		t.Errorf("got otel attributes %v, want grpc.retries 2", spans[0].Attributes)
+	}
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RevocationStore remembers tokens that were invalidated before they expired.
+// Entries only need to outlive the tokens they cover, so every write carries
+// the time after which it may be forgotten.
+type RevocationStore interface {
+	// RevokeToken invalidates the token with the given jti until it expires.
+	RevokeToken(ctx context.Context, tokenId string, expiresAt time.Time) error
+	// RevokeUser invalidates every token of userId issued before before.
+	// The entry is kept until forgetAfter.
+	RevokeUser(ctx context.Context, userId string, before time.Time, forgetAfter time.Time) error
+	// IsRevoked reports whether a token was revoked by either mechanism.
+	IsRevoked(ctx context.Context, tokenId string, userId string, issuedAt time.Time) (bool, error)
+	Close() error
+}
+
+// RevokeSession invalidates the principal's token. Tokens without a jti
+// cannot be told apart, so every session of the user ends instead.
+// maxTokenLifetime bounds how long an entry is needed when the token
+// carries no expiry.
+func RevokeSession(ctx context.Context, store RevocationStore, principal *Principal, maxTokenLifetime time.Duration) error {
+	now := time.Now()
+	expiresAt := principal.ExpiresAt
+	if expiresAt.IsZero() {
+		expiresAt = now.Add(maxTokenLifetime)
+	}
+	if principal.TokenId != "" {
+		return store.RevokeToken(ctx, principal.TokenId, expiresAt)
+	}
+	return store.RevokeUser(ctx, principal.Id, now, now.Add(maxTokenLifetime))
+}
+
+type revocationEntry struct {
+	value       time.Time
+	forgetAfter time.Time
+}
+
+// MemoryRevocationStore keeps revocations in process. It suits tests and
+// single-replica deployments; replicas do not see each other's entries.
+type MemoryRevocationStore struct {
+	mutex  sync.RWMutex
+	tokens map[string]revocationEntry
+	users  map[string]revocationEntry
+	now    func() time.Time
+}
+
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		tokens: map[string]revocationEntry{},
+		users:  map[string]revocationEntry{},
+		now:    time.Now,
+	}
+}
+
+func (store *MemoryRevocationStore) RevokeToken(ctx context.Context, tokenId string, expiresAt time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.prune()
+	store.tokens[tokenId] = revocationEntry{forgetAfter: expiresAt}
+	return nil
+}
+
+func (store *MemoryRevocationStore) RevokeUser(ctx context.Context, userId string, before time.Time, forgetAfter time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.prune()
+	if previous, ok := store.users[userId]; ok && previous.value.After(before) {
+		before = previous.value
+	}
+	store.users[userId] = revocationEntry{value: before, forgetAfter: forgetAfter}
+	return nil
+}
+
+func (store *MemoryRevocationStore) IsRevoked(ctx context.Context, tokenId string, userId string, issuedAt time.Time) (bool, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	now := store.now()
+	if entry, ok := store.tokens[tokenId]; ok && tokenId != "" && now.Before(entry.forgetAfter) {
+		return true, nil
+	}
+	if entry, ok := store.users[userId]; ok && now.Before(entry.forgetAfter) {
+		return issuedAt.Before(entry.value), nil
+	}
+	return false, nil
+}
+
+func (store *MemoryRevocationStore) Close() error {
+	return nil
+}
+
+// prune drops expired entries; callers hold the write lock.
+func (store *MemoryRevocationStore) prune() {
+	now := store.now()
+	for key, entry := range store.tokens {
+		if !now.Before(entry.forgetAfter) {
+			delete(store.tokens, key)
+		}
+	}
+	for key, entry := range store.users {
+		if !now.Before(entry.forgetAfter) {
+			delete(store.users, key)
+		}
+	}
+}
+
+// RedisRevocationStore shares revocations between replicas through any
+// server that speaks the Redis protocol. Keys expire with the tokens they
+// cover, so the store needs no cleanup.
+type RedisRevocationStore struct {
+	client *redisClient
+	prefix string
+	now    func() time.Time
+}
+
+type RedisConfig struct {
+	Address  string
+	Password string
+	Database int
+	Timeout  time.Duration
+	// KeyPrefix namespaces the keys, e.g. "gateway:revoked:".
+	KeyPrefix string
+}
+
+func NewRedisRevocationStore(config RedisConfig) (*RedisRevocationStore, error) {
+	client := newRedisClient(config)
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	if _, err := client.do(ctx, "PING"); err != nil {
+		client.close()
+		return nil, err
+	}
+	return &RedisRevocationStore{client: client, prefix: config.KeyPrefix, now: time.Now}, nil
+}
+
+func (store *RedisRevocationStore) RevokeToken(ctx context.Context, tokenId string, expiresAt time.Time) error {
+	ttl := expiresAt.Sub(store.now())
+	if ttl <= 0 {
+		return nil
+	}
+	_, err := store.client.do(ctx, "SET", store.tokenKey(tokenId), "1", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+func (store *RedisRevocationStore) RevokeUser(ctx context.Context, userId string, before time.Time, forgetAfter time.Time) error {
+	ttl := forgetAfter.Sub(store.now())
+	if ttl <= 0 {
+		return nil
+	}
+	_, err := store.client.do(ctx, "SET", store.userKey(userId), strconv.FormatInt(before.UnixNano(), 10), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+func (store *RedisRevocationStore) IsRevoked(ctx context.Context, tokenId string, userId string, issuedAt time.Time) (bool, error) {
+	reply, err := store.client.do(ctx, "MGET", store.tokenKey(tokenId), store.userKey(userId))
+	if err != nil {
+		return false, err
+	}
+	values, _ := reply.([]interface{})
+	if len(values) != 2 {
+		return false, errUnexpectedReply
+	}
+	if tokenId != "" && values[0] != nil {
+		return true, nil
+	}
+	before, ok := values[1].(string)
+	if !ok {
+		return false, nil
+	}
+	cutoff, err := strconv.ParseInt(before, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	// Cutoffs are stored in nanoseconds.
+	return issuedAt.Before(time.Unix(0, cutoff)), nil
+}
+
+func (store *RedisRevocationStore) Close() error {
+	return store.client.close()
+}
+
+func (store *RedisRevocationStore) tokenKey(tokenId string) string {
+	return store.prefix + "jti:" + tokenId
+}
+
+func (store *RedisRevocationStore) userKey(userId string) string {
+	return store.prefix + "user:" + userId
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startFakeRedis serves PING, SET and MGET from memory, enough for the
+// revocation store. Expiry options of SET are ignored.
+func startFakeRedis(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	var mutex sync.Mutex
+	values := map[string]string{}
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			reply, err := readReply(reader)
+			if err != nil {
+				return
+			}
+			args, _ := reply.([]interface{})
+			if len(args) == 0 {
+				return
+			}
+			mutex.Lock()
+			switch strings.ToUpper(args[0].(string)) {
+			case "PING":
+				fmt.Fprint(conn, "+PONG\r\n")
+			case "SET":
+				values[args[1].(string)] = args[2].(string)
+				fmt.Fprint(conn, "+OK\r\n")
+			case "MGET":
+				fmt.Fprintf(conn, "*%d\r\n", len(args)-1)
+				for _, key := range args[1:] {
+					if value, ok := values[key.(string)]; ok {
+						fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value), value)
+					} else {
+						fmt.Fprint(conn, "$-1\r\n")
+					}
+				}
+			default:
+				fmt.Fprint(conn, "-ERR unknown command\r\n")
+			}
+			mutex.Unlock()
+		}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestRevokeUserCutoff(t *testing.T) {
+	second := time.Now().Truncate(time.Second)
+	stores := []struct {
+		name  string
+		store func(t *testing.T) RevocationStore
+	}{
+		{"memory", func(t *testing.T) RevocationStore { return NewMemoryRevocationStore() }},
+		{"redis", func(t *testing.T) RevocationStore {
+			store, err := NewRedisRevocationStore(RedisConfig{Address: startFakeRedis(t), Timeout: time.Second, KeyPrefix: "test:"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		}},
+	}
+	tests := []struct {
+		name     string
+		cutoff   time.Time
+		issuedAt time.Time
+		revoked  bool
+	}{
+		{"issued a second earlier", second.Add(300 * time.Millisecond), second.Add(-time.Second), true},
+		{"issued earlier in the same second", second.Add(300 * time.Millisecond), second, true},
+		{"issued the next second", second.Add(300 * time.Millisecond), second.Add(time.Second), false},
+		{"issued at the cutoff", second, second, false},
+		{"issued just before the cutoff", second, second.Add(-time.Millisecond), true},
+	}
+	for _, store := range stores {
+		for _, test := range tests {
+			t.Run(store.name+"/"+test.name, func(t *testing.T) {
+				revocations := store.store(t)
+				ctx := context.Background()
+				if err := revocations.RevokeUser(ctx, "alice", test.cutoff, time.Now().Add(time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+				revoked, err := revocations.IsRevoked(ctx, "", "alice", test.issuedAt)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if revoked != test.revoked {
+					t.Errorf("got revoked %v, want %v", revoked, test.revoked)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// CertificateReloader serves a key pair from disk and loads it again when
+// either file changes, so rotated certificates apply without a restart. The
+// files are checked on handshakes, at most once per interval.
+type CertificateReloader struct {
+	certFile    string
+	keyFile     string
+	interval    time.Duration
+	mutex       sync.Mutex
+	certificate *tls.Certificate
+	modTime     time.Time
+	checkedAt   time.Time
+	now         func() time.Time
+}
+
+func NewCertificateReloader(certFile, keyFile string, interval time.Duration) (*CertificateReloader, error) {
+	reloader := &CertificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+		now:      time.Now,
+	}
+	modTime, err := reloader.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := reloader.load(modTime); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (reloader *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return reloader.current(), nil
+}
+
+func (reloader *CertificateReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return reloader.current(), nil
+}
+
+// current returns the loaded pair. A pair that fails to load, typically
+// because only one of the files was replaced so far, keeps the previous one
+// in use until the next check.
+func (reloader *CertificateReloader) current() *tls.Certificate {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+	now := reloader.now()
+	if now.Sub(reloader.checkedAt) < reloader.interval {
+		return reloader.certificate
+	}
+	reloader.checkedAt = now
+	modTime, err := reloader.latestModTime()
+	if err != nil {
+		log.Printf("failed to check certificate %s, keeping the previous one: %v", reloader.certFile, err)
+		return reloader.certificate
+	}
+	if modTime.Equal(reloader.modTime) {
+		return reloader.certificate
+	}
+	if err := reloader.load(modTime); err != nil {
+		log.Printf("failed to reload certificate %s, keeping the previous one: %v", reloader.certFile, err)
+		return reloader.certificate
+	}
+	log.Printf("reloaded certificate %s", reloader.certFile)
+	return reloader.certificate
+}
+
+func (reloader *CertificateReloader) load(modTime time.Time) error {
+	certificate, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return err
+	}
+	reloader.certificate = &certificate
+	reloader.modTime = modTime
+	reloader.checkedAt = reloader.now()
+	return nil
+}
+
+func (reloader *CertificateReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{reloader.certFile, reloader.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// ServerTLSConfig is the configuration of the public listener. HTTP/2 is
+// negotiated through ALPN when enabled.
+func ServerTLSConfig(reloader *CertificateReloader, http2 bool) *tls.Config {
+	protocols := []string{"http/1.1"}
+	if http2 {
+		protocols = []string{"h2", "http/1.1"}
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+		NextProtos:     protocols,
+	}
+}
+
+// ClientTLS describes how the gateway connects to one backend. With a client
+// certificate it is mutual TLS.
+type ClientTLS struct {
+	Enabled bool
+	// CAFile verifies the backend; the system pool is used when empty.
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name checked against the backend certificate,
+	// for backends reached through addresses their certificate does not list.
+	ServerName string
+	// Reload is how often the client certificate files are checked.
+	Reload time.Duration
+}
+
+// Config returns nil when TLS is disabled.
+func (config ClientTLS) Config() (*tls.Config, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: config.ServerName,
+	}
+	if config.CAFile != "" {
+		content, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("client certificate and key must be set together")
+	}
+	if config.CertFile != "" {
+		reloader, err := NewCertificateReloader(config.CertFile, config.KeyFile, config.Reload)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = reloader.GetClientCertificate
+	}
+	return tlsConfig, nil
+}
+
+// TransportCredentials are the gRPC credentials for the backend, plaintext
+// when TLS is disabled.
+func (config ClientTLS) TransportCredentials() (credentials.TransportCredentials, error) {
+	tlsConfig, err := config.Config()
+	if err != nil || tlsConfig == nil {
+		return insecure.NewCredentials(), err
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
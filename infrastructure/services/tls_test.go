@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// testCertificate is a generated key pair written to disk as PEM.
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	certFile    string
+	keyFile     string
+}
+
+// newTestCertificate issues a certificate for names, signed by issuer or
+// self-signed as a CA when issuer is nil.
+func newTestCertificate(t *testing.T, name string, issuer *testCertificate, names ...string) *testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parent, signer := template, key
+	if issuer == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, signer = issuer.certificate, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated := &testCertificate{
+		certificate: certificate,
+		key:         key,
+		certFile:    filepath.Join(t.TempDir(), name+".crt"),
+		keyFile:     filepath.Join(t.TempDir(), name+".key"),
+	}
+	writePEM(t, generated.certFile, "CERTIFICATE", der)
+	writePEM(t, generated.keyFile, "EC PRIVATE KEY", keyDer)
+	return generated
+}
+
+func writePEM(t *testing.T, file string, kind string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	ca := newTestCertificate(t, "ca", nil)
+	server := newTestCertificate(t, "gateway", ca, "gateway.test")
+	reloader, err := NewCertificateReloader(server.certFile, server.keyFile, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.certificate)
+
+	tests := []struct {
+		name     string
+		http2    bool
+		protocol string
+	}{
+		{"http2 enabled", true, "HTTP/2.0"},
+		{"http2 disabled", false, "HTTP/1.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listener := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.Proto))
+			}))
+			listener.TLS = ServerTLSConfig(reloader, test.http2)
+			listener.EnableHTTP2 = test.http2
+			listener.StartTLS()
+			defer listener.Close()
+
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{RootCAs: roots, ServerName: "gateway.test"},
+				ForceAttemptHTTP2: true,
+			}}
+			response, err := client.Get(listener.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			if response.Proto != test.protocol {
+				t.Errorf("got %s, want %s", response.Proto, test.protocol)
+			}
+		})
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	ca := newTestCertificate(t, "ca", nil)
+	otherCA := newTestCertificate(t, "other-ca", nil)
+	backendCertificate := newTestCertificate(t, "backend", ca, "backend.internal")
+	gateway := newTestCertificate(t, "gateway", ca)
+	stranger := newTestCertificate(t, "stranger", otherCA)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.certificate)
+	backendPair, err := tls.LoadX509KeyPair(backendCertificate.certFile, backendCertificate.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := startStub(t, grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{backendPair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})))
+
+	tests := []struct {
+		name   string
+		config ClientTLS
+		ok     bool
+	}{
+		{"mutual TLS", ClientTLS{Enabled: true, CAFile: ca.certFile, CertFile: gateway.certFile, KeyFile: gateway.keyFile, ServerName: "backend.internal"}, true},
+		{"no client certificate", ClientTLS{Enabled: true, CAFile: ca.certFile, ServerName: "backend.internal"}, false},
+		{"client certificate from another CA", ClientTLS{Enabled: true, CAFile: ca.certFile, CertFile: stranger.certFile, KeyFile: stranger.keyFile, ServerName: "backend.internal"}, false},
+		{"backend not trusted", ClientTLS{Enabled: true, CAFile: otherCA.certFile, CertFile: gateway.certFile, KeyFile: gateway.keyFile, ServerName: "backend.internal"}, false},
+		{"wrong server name", ClientTLS{Enabled: true, CAFile: ca.certFile, CertFile: gateway.certFile, KeyFile: gateway.keyFile, ServerName: "profile.internal"}, false},
+		{"plaintext to a TLS backend", ClientTLS{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			creds, err := test.config.TransportCredentials()
+			if err != nil {
+				t.Fatal(err)
+			}
+			pool, err := NewPool(PoolConfig{
+				Name:        "backend",
+				Addresses:   []string{backend.address},
+				Balancer:    BalancerRoundRobin,
+				DialOptions: []grpc.DialOption{grpc.WithTransportCredentials(creds)},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pool.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_, err = healthpb.NewHealthClient(pool).Check(ctx, &healthpb.HealthCheckRequest{})
+			if (err == nil) != test.ok {
+				t.Errorf("got error %v, want ok %v", err, test.ok)
+			}
+		})
+	}
+}
+
+func TestClientTLSConfigErrors(t *testing.T) {
+	ca := newTestCertificate(t, "ca", nil)
+	gateway := newTestCertificate(t, "gateway", ca)
+	tests := []struct {
+		name   string
+		config ClientTLS
+	}{
+		{"missing CA file", ClientTLS{Enabled: true, CAFile: filepath.Join(t.TempDir(), "missing.crt")}},
+		{"CA file without certificates", ClientTLS{Enabled: true, CAFile: gateway.keyFile}},
+		{"certificate without key", ClientTLS{Enabled: true, CertFile: gateway.certFile}},
+		{"key without certificate", ClientTLS{Enabled: true, KeyFile: gateway.keyFile}},
+		{"key of another certificate", ClientTLS{Enabled: true, CertFile: gateway.certFile, KeyFile: ca.keyFile}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.config.Config(); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestCertificateReloader(t *testing.T) {
+	ca := newTestCertificate(t, "ca", nil)
+	first := newTestCertificate(t, "first", ca)
+	second := newTestCertificate(t, "second", ca)
+	reloader, err := NewCertificateReloader(first.certFile, first.keyFile, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	reloader.now = func() time.Time { return now }
+
+	replace := func(from, to string) {
+		content, err := os.ReadFile(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(to, content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		// Make the change visible on file systems with coarse timestamps.
+		later := now.Add(time.Hour)
+		os.Chtimes(to, later, later)
+	}
+	steps := []struct {
+		name    string
+		change  func()
+		advance time.Duration
+		want    string
+	}{
+		{"initial pair", func() {}, 0, "first"},
+		{"rotated within the interval", func() {
+			replace(second.certFile, first.certFile)
+			replace(second.keyFile, first.keyFile)
+		}, 30 * time.Second, "first"},
+		{"rotated after the interval", func() {}, time.Minute, "second"},
+	}
+	for _, step := range steps {
+		step.change()
+		now = now.Add(step.advance)
+		certificate, err := reloader.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leaf.Subject.CommonName != step.want {
+			t.Errorf("%s: got %s, want %s", step.name, leaf.Subject.CommonName, step.want)
+		}
+	}
+}
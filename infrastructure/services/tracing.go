@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// TracingMiddleware starts the server span of every request, continuing the
+// caller's trace when the request carries one. Handler and backend spans
+// become its children through the request context.
+func TracingMiddleware(tracer opentracing.Tracer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parent, _ := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
+		span := tracer.StartSpan("HTTP "+r.Method, ext.RPCServerOption(parent))
+		defer span.Finish()
+		ext.HTTPMethod.Set(span, r.Method)
+		ext.HTTPUrl.Set(span, r.URL.Path)
+
+		r, info := withRequestInfo(r)
+		SetTraceId(r.Context(), TraceId(tracer, span.Context()))
+		recorder := NewResponseRecorder(w)
+		next.ServeHTTP(recorder, r.WithContext(opentracing.ContextWithSpan(r.Context(), span)))
+
+		info.mutex.Lock()
+		route := info.route
+		info.mutex.Unlock()
+		if route != "" {
+			span.SetOperationName(r.Method + " " + route)
+		}
+		ext.HTTPStatusCode.Set(span, uint16(recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			ext.Error.Set(span, true)
+		}
+	})
+}
+
+// TracingInterceptor gives every call attempt to a backend its own client
+// span and sends the span context along in the outgoing metadata, so the
+// trace continues inside the backend.
+func TracingInterceptor(tracer opentracing.Tracer, service string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		options := []opentracing.StartSpanOption{ext.SpanKindRPCClient}
+		if parent := opentracing.SpanFromContext(ctx); parent != nil {
+			options = append(options, opentracing.ChildOf(parent.Context()))
+		}
+		span := tracer.StartSpan(method, options...)
+		defer span.Finish()
+		ext.PeerService.Set(span, service+"_service")
+		span.SetTag("grpc.method", method)
+
+		md, _ := metadata.FromOutgoingContext(ctx)
+		md = md.Copy()
+		if err := tracer.Inject(span.Context(), opentracing.TextMap, MetadataCarrier(md)); err != nil {
+			span.LogKV("event", "inject failed", "error", err.Error())
+		}
+		err := invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+		code := errorStatus(err).Code()
+		span.SetTag("grpc.code", code.String())
+		if err != nil {
+			ext.Error.Set(span, true)
+			span.LogKV("event", "error", "message", err.Error())
+		}
+		return err
+	}
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestTracingSpans(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		statusCode int
+		code       string
+	}{
+		{"ok", nil, http.StatusOK, "OK"},
+		{"backend failed", status.Error(codes.Unavailable, "connection refused"), http.StatusServiceUnavailable, "Unavailable"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Spans must come from the tracer handed in, never from the
+			// global one.
+			global := mocktracer.New()
+			previous := opentracing.GlobalTracer()
+			opentracing.SetGlobalTracer(global)
+			defer opentracing.SetGlobalTracer(previous)
+
+			tracer := mocktracer.New()
+			caller := tracer.StartSpan("caller")
+			var outgoing metadata.MD
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				outgoing, _ = metadata.FromOutgoingContext(ctx)
+				return test.err
+			}
+			interceptor := TracingInterceptor(tracer, "post")
+			handler := TracingMiddleware(tracer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				SetRoute(r.Context(), "/post/{id}")
+				ctx := metadata.AppendToOutgoingContext(r.Context(), "x-request-id", "request-1")
+				if err := interceptor(ctx, "/post.PostService/Get", nil, nil, nil, invoker); err != nil {
+					WriteBackendError(w, r, err)
+				}
+			}))
+			request := httptest.NewRequest("GET", "/post/1", nil)
+			if err := tracer.Inject(caller.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(request.Header)); err != nil {
+				t.Fatal(err)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+
+			if spans := global.FinishedSpans(); len(spans) != 0 {
+				t.Errorf("global tracer recorded %d spans", len(spans))
+			}
+			spans := tracer.FinishedSpans()
+			if len(spans) != 2 {
+				t.Fatalf("got %d spans, want 2", len(spans))
+			}
+			client, server := spans[0], spans[1]
+			callerContext := caller.Context().(mocktracer.MockSpanContext)
+
+			if server.OperationName != "GET /post/{id}" || server.ParentID != callerContext.SpanID || server.SpanContext.TraceID != callerContext.TraceID {
+				t.Errorf("got server span %q with parent %d in trace %d, want GET /post/{id} under %d in %d",
+					server.OperationName, server.ParentID, server.SpanContext.TraceID, callerContext.SpanID, callerContext.TraceID)
+			}
+			if client.ParentID != server.SpanContext.SpanID || client.SpanContext.TraceID != callerContext.TraceID {
+				t.Errorf("client span has parent %d in trace %d, want %d in %d", client.ParentID, client.SpanContext.TraceID, server.SpanContext.SpanID, callerContext.TraceID)
+			}
+
+			wantTags := []struct {
+				span *mocktracer.MockSpan
+				key  string
+				want interface{}
+			}{
+				{server, "span.kind", ext.SpanKindRPCServerEnum},
+				{server, "http.method", "GET"},
+				{server, "http.url", "/post/1"},
+				{server, "http.status_code", uint16(test.statusCode)},
+				{client, "span.kind", ext.SpanKindRPCClientEnum},
+				{client, "peer.service", "post_service"},
+				{client, "grpc.method", "/post.PostService/Get"},
+				{client, "grpc.code", test.code},
+			}
+			for _, tag := range wantTags {
+				if got := tag.span.Tag(tag.key); got != tag.want {
+					t.Errorf("%s span: got tag %s %v, want %v", tag.span.OperationName, tag.key, got, tag.want)
+				}
+			}
+			for _, span := range spans {
+				if failed, _ := span.Tag("error").(bool); failed != (test.err != nil) {
+					t.Errorf("%s span: got error tag %v, want %v", span.OperationName, failed, test.err != nil)
+				}
+			}
+
+			injected, err := tracer.Extract(opentracing.TextMap, MetadataCarrier(outgoing))
+			if err != nil {
+				t.Fatalf("no span context in the outgoing metadata %v: %v", outgoing, err)
+			}
+			if injected.(mocktracer.MockSpanContext).SpanID != client.SpanContext.SpanID {
+				t.Errorf("outgoing metadata carries span %d, want the client span %d", injected.(mocktracer.MockSpanContext).SpanID, client.SpanContext.SpanID)
+			}
+			if got := outgoing.Get("x-request-id"); len(got) != 1 {
+				t.Errorf("got x-request-id %v, want it kept", got)
+			}
+		})
+	}
+}
+
+func TestTracingInterceptorWithoutParent(t *testing.T) {
+	tracer := mocktracer.New()
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+	if err := TracingInterceptor(tracer, "profile")(context.Background(), "/profile.ProfileService/Get", nil, nil, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+	spans := tracer.FinishedSpans()
+	if len(spans) != 1 || spans[0].ParentID != 0 {
+		t.Fatalf("got %d spans, want one root span", len(spans))
+	}
+}
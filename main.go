@@ -3,10 +3,26 @@ package main
 import (
 	"api-gateway/startup"
 	"api-gateway/startup/config"
+	"errors"
+	"flag"
+	"log"
+	"os"
 )
 
 func main() {
-	config := config.NewConfig()
+	config, err := config.NewConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if config.PrintConfig {
+		if err := config.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	server := startup.NewServer(config)
 	server.Start()
 }
@@ -0,0 +1,123 @@
+package startup
+
+import (
+	"api-gateway/infrastructure/services"
+	cfg "api-gateway/startup/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// version and commit are set at build time:
+//
+//	go build -ldflags "-X api-gateway/startup.version=1.4.0 -X api-gateway/startup.commit=$(git rev-parse HEAD)"
+var (
+	version = "dev"
+	commit  = ""
+)
+
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"goVersion"`
+}
+
+// currentBuildInfo falls back to the VCS revision Go stamps into binaries
+// built from a checkout when no commit was passed at build time.
+func currentBuildInfo() buildInfo {
+	info := buildInfo{Version: version, Commit: commit, GoVersion: runtime.Version()}
+	if info.Commit != "" {
+		return info
+	}
+	info.Commit = "unknown"
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			if setting.Key == "vcs.revision" {
+				info.Commit = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+// newRegistry holds every gateway metric plus the Go runtime and process
+// collectors and a build info gauge.
+func newRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	info := currentBuildInfo()
+	buildInfoGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_build_info",
+		Help: "Always 1, labelled with the version, commit and Go version of the running gateway",
+	}, []string{"version", "commit", "goversion"})
+	buildInfoGauge.WithLabelValues(info.Version, info.Commit, info.GoVersion).Set(1)
+	registry.MustRegister(buildInfoGauge)
+	return registry
+}
+
+// checkAdminExposure refuses an admin listener that anyone who can reach
+// the host could use: one bound beyond loopback without credentials.
+func checkAdminExposure(config *cfg.Config) error {
+	if config.AdminPort == "" {
+		return nil
+	}
+	if config.AdminUsername != "" && config.AdminPassword == "" {
+		return errors.New("adminPassword is required when adminUsername is set")
+	}
+	if config.AdminUsername != "" || isLoopback(config.AdminHost) {
+		return nil
+	}
+	return fmt.Errorf("the admin listener binds to %q beyond loopback and needs adminUsername and adminPassword", config.AdminHost)
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// adminHandler serves the operator endpoints on the admin listener, away
+// from the public port.
+func (server *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(server.registry, promhttp.HandlerOpts{Registry: server.registry}))
+	mux.HandleFunc("/buildinfo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(currentBuildInfo())
+	})
+	// Secrets are redacted by Print.
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		server.config.Print(w)
+	})
+	// /readyz on the public port only says whether each dependency is up.
+	if server.health != nil {
+		mux.HandleFunc("/dependencies", server.health.Dependencies)
+	}
+	if server.config.AdminPprofEnabled {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	var handler http.Handler = mux
+	if server.config.AdminUsername != "" {
+		handler = services.BasicAuth(server.config.AdminUsername, server.config.AdminPassword, "api-gateway admin", handler)
+	}
+	return handler
+}
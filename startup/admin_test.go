@@ -0,0 +1,124 @@
+package startup
+
+import (
+	"api-gateway/infrastructure/api"
+	"api-gateway/infrastructure/services"
+	cfg "api-gateway/startup/config"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckAdminExposure(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		port     string
+		username string
+		password string
+		ok       bool
+	}{
+		{"loopback without credentials", "127.0.0.1", "9000", "", "", true},
+		{"localhost without credentials", "localhost", "9000", "", "", true},
+		{"ipv6 loopback without credentials", "::1", "9000", "", "", true},
+		{"all interfaces without credentials", "", "9000", "", "", false},
+		{"public address without credentials", "0.0.0.0", "9000", "", "", false},
+		{"public address with credentials", "0.0.0.0", "9000", "ops", "hunter2", true},
+		{"user without password", "127.0.0.1", "9000", "ops", "", false},
+		{"listener disabled", "0.0.0.0", "", "", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkAdminExposure(&cfg.Config{AdminHost: test.host, AdminPort: test.port, AdminUsername: test.username, AdminPassword: test.password})
+			if (err == nil) != test.ok {
+				t.Errorf("got error %v, want ok %v", err, test.ok)
+			}
+		})
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		config cfg.Config
+		path   string
+		auth   bool
+		status int
+	}{
+		{"metrics", cfg.Config{}, "/metrics", false, http.StatusOK},
+		{"pprof off", cfg.Config{}, "/debug/pprof/", false, http.StatusNotFound},
+		{"pprof on", cfg.Config{AdminPprofEnabled: true}, "/debug/pprof/", false, http.StatusOK},
+		{"credentials missing", cfg.Config{AdminUsername: "ops", AdminPassword: "hunter2"}, "/metrics", false, http.StatusUnauthorized},
+		{"credentials sent", cfg.Config{AdminUsername: "ops", AdminPassword: "hunter2"}, "/buildinfo", true, http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := test.config
+			server := &Server{config: &config, registry: newRegistry()}
+			request := httptest.NewRequest("GET", test.path, nil)
+			if test.auth {
+				request.SetBasicAuth("ops", "hunter2")
+			}
+			recorder := httptest.NewRecorder()
+			server.adminHandler().ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Errorf("got status %d, want %d", recorder.Code, test.status)
+			}
+		})
+	}
+}
+
+func TestReadinessDetailStaysOnAdmin(t *testing.T) {
+	health := api.NewHealthHandler([]api.Dependency{{
+		Name: "post",
+		Replicas: func(ctx context.Context) []services.ReplicaStatus {
+			return []services.ReplicaStatus{
+				{Address: "10.0.3.7:8000", Status: "up"},
+				{Address: "10.0.3.8:8000", Status: "down", Error: "dial tcp 10.0.3.8:8000: connect: connection refused"},
+			}
+		},
+	}, {
+		Name:     "auth",
+		Optional: true,
+		Check:    func(ctx context.Context) error { return errors.New("dial tcp 10.0.9.1:8001: i/o timeout") },
+	}}, time.Second, func() bool { return true })
+	server := &Server{config: &cfg.Config{}, registry: newRegistry(), health: health}
+
+	tests := []struct {
+		name    string
+		serve   func(w http.ResponseWriter, r *http.Request)
+		path    string
+		shows   []string
+		omitted []string
+	}{
+		{"public readiness", func(w http.ResponseWriter, r *http.Request) { health.Readyz(w, r, nil) }, "/readyz",
+			[]string{`"status":"degraded"`, `"post":"up"`, `"auth":"down"`},
+			[]string{"10.0.", "refused", "timeout"}},
+		{"admin dependencies", server.adminHandler().ServeHTTP, "/dependencies",
+			[]string{"10.0.3.8:8000", "connection refused", "10.0.9.1:8001: i/o timeout"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			test.serve(recorder, httptest.NewRequest("GET", test.path, nil))
+			if recorder.Code != http.StatusOK {
+				t.Errorf("got status %d, want %d", recorder.Code, http.StatusOK)
+			}
+			body := recorder.Body.String()
+			for _, want := range test.shows {
+				if !strings.Contains(body, want) {
+					t.Errorf("%s missing from %s", want, body)
+				}
+			}
+			for _, leaked := range test.omitted {
+				if strings.Contains(body, leaked) {
+					t.Errorf("%s leaked in %s", leaked, body)
+				}
+			}
+		})
+	}
+}
@@ -1,50 +1,240 @@
 package config
 
 import (
-	"fmt"
-	"os"
+	"net"
+	"time"
 )
 
+// Config is assembled from layers: built-in defaults, an optional YAML or
+// JSON file, environment variables and finally command-line flags. Each field
+// names its key in every layer through struct tags; see load.go.
 type Config struct {
-	Port           string
-	ProfileHost    string
-	ProfilePort    string
-	PostHost       string
-	PostPort       string
-	AuthHost       string
-	AuthPort       string
-	ConnectionHost string
-	ConnectionPort string
+	Port            string        `key:"port" env:"GATEWAY_PORT" default:"8000" required:"true" validate:"port" usage:"public HTTP port"`
+	ProfileHost     string        `key:"profileHost" env:"PROFILE_SERVICE_HOST" default:"localhost" required:"true" usage:"profile service host"`
+	ProfilePort     string        `key:"profilePort" env:"PROFILE_SERVICE_PORT" default:"8001" required:"true" validate:"port" usage:"profile service port"`
+	PostHost        string        `key:"postHost" env:"POST_SERVICE_HOST" default:"localhost" required:"true" usage:"post service host"`
+	PostPort        string        `key:"postPort" env:"POST_SERVICE_PORT" default:"8002" required:"true" validate:"port" usage:"post service port"`
+	AuthHost        string        `key:"authHost" env:"AUTHENTICATION_SERVICE_HOST" default:"localhost" required:"true" usage:"authentication service host"`
+	AuthPort        string        `key:"authPort" env:"AUTHENTICATION_SERVICE_PORT" default:"8003" required:"true" validate:"port" usage:"authentication service port"`
+	ConnectionHost  string        `key:"connectionHost" env:"CONNECTION_SERVICE_HOST" default:"localhost" required:"true" usage:"connection service host"`
+	ConnectionPort  string        `key:"connectionPort" env:"CONNECTION_SERVICE_PORT" default:"8004" required:"true" validate:"port" usage:"connection service port"`
+	ShutdownDelay   time.Duration `key:"shutdownDelay" env:"GATEWAY_SHUTDOWN_DELAY" default:"5s" usage:"time to keep serving as not-ready before draining, so load balancers stop routing to the gateway"`
+	ShutdownTimeout time.Duration `key:"shutdownTimeout" env:"GATEWAY_SHUTDOWN_TIMEOUT" default:"20s" usage:"deadline for draining in-flight requests"`
+	// OptionalDependencies names backends ("profile", "post", "connection",
+	// "auth") whose outage degrades readiness instead of failing it.
+	OptionalDependencies []string      `key:"optionalDependencies" env:"GATEWAY_OPTIONAL_DEPENDENCIES" usage:"comma separated backends that may be down while ready"`
+	ReadinessTimeout     time.Duration `key:"readinessTimeout" env:"GATEWAY_READINESS_TIMEOUT" default:"2s" usage:"deadline for all readiness probes"`
+
+	// RouteTimeouts entries read "<method> <pattern> <timeout>" and override
+	// DefaultRouteTimeout for custom and generated routes alike.
+	RouteTimeouts       []string      `key:"routeTimeouts" env:"GATEWAY_ROUTE_TIMEOUTS" default:"POST /post/image 60s" usage:"per route deadlines for backend calls"`
+	DefaultRouteTimeout time.Duration `key:"defaultRouteTimeout" env:"GATEWAY_DEFAULT_ROUTE_TIMEOUT" default:"10s" usage:"deadline for routes without an entry in routeTimeouts, none when 0"`
+
+	// The listener serves HTTPS when a certificate is set. Certificate files
+	// are checked for changes at most once per reload interval.
+	TLSCertFile       string        `key:"tlsCertFile" env:"GATEWAY_TLS_CERT_FILE" usage:"PEM certificate chain of the public listener, plain HTTP when empty"`
+	TLSKeyFile        string        `key:"tlsKeyFile" env:"GATEWAY_TLS_KEY_FILE" usage:"PEM private key of the public listener"`
+	TLSReloadInterval time.Duration `key:"tlsReloadInterval" env:"GATEWAY_TLS_RELOAD_INTERVAL" default:"1m" usage:"how often certificate files are checked for changes"`
+	HTTP2Enabled      bool          `key:"http2Enabled" env:"GATEWAY_HTTP2_ENABLED" default:"true" usage:"offer HTTP/2 on the TLS listener"`
+
+	ProfileTLSEnabled    bool   `key:"profileTlsEnabled" env:"PROFILE_SERVICE_TLS_ENABLED" default:"false" usage:"connect to the profile service over TLS"`
+	ProfileTLSCAFile     string `key:"profileTlsCaFile" env:"PROFILE_SERVICE_TLS_CA_FILE" usage:"CA bundle verifying the profile service, system roots when empty"`
+	ProfileTLSCertFile   string `key:"profileTlsCertFile" env:"PROFILE_SERVICE_TLS_CERT_FILE" usage:"client certificate for mutual TLS with the profile service"`
+	ProfileTLSKeyFile    string `key:"profileTlsKeyFile" env:"PROFILE_SERVICE_TLS_KEY_FILE" usage:"client key for mutual TLS with the profile service"`
+	ProfileTLSServerName string `key:"profileTlsServerName" env:"PROFILE_SERVICE_TLS_SERVER_NAME" usage:"name expected in the profile service certificate, the dialed host when empty"`
+
+	PostTLSEnabled    bool   `key:"postTlsEnabled" env:"POST_SERVICE_TLS_ENABLED" default:"false" usage:"connect to the post service over TLS"`
+	PostTLSCAFile     string `key:"postTlsCaFile" env:"POST_SERVICE_TLS_CA_FILE" usage:"CA bundle verifying the post service, system roots when empty"`
+	PostTLSCertFile   string `key:"postTlsCertFile" env:"POST_SERVICE_TLS_CERT_FILE" usage:"client certificate for mutual TLS with the post service"`
+	PostTLSKeyFile    string `key:"postTlsKeyFile" env:"POST_SERVICE_TLS_KEY_FILE" usage:"client key for mutual TLS with the post service"`
+	PostTLSServerName string `key:"postTlsServerName" env:"POST_SERVICE_TLS_SERVER_NAME" usage:"name expected in the post service certificate, the dialed host when empty"`
+
+	ConnectionTLSEnabled    bool   `key:"connectionTlsEnabled" env:"CONNECTION_SERVICE_TLS_ENABLED" default:"false" usage:"connect to the connection service over TLS"`
+	ConnectionTLSCAFile     string `key:"connectionTlsCaFile" env:"CONNECTION_SERVICE_TLS_CA_FILE" usage:"CA bundle verifying the connection service, system roots when empty"`
+	ConnectionTLSCertFile   string `key:"connectionTlsCertFile" env:"CONNECTION_SERVICE_TLS_CERT_FILE" usage:"client certificate for mutual TLS with the connection service"`
+	ConnectionTLSKeyFile    string `key:"connectionTlsKeyFile" env:"CONNECTION_SERVICE_TLS_KEY_FILE" usage:"client key for mutual TLS with the connection service"`
+	ConnectionTLSServerName string `key:"connectionTlsServerName" env:"CONNECTION_SERVICE_TLS_SERVER_NAME" usage:"name expected in the connection service certificate, the dialed host when empty"`
+
+	AuthTLSEnabled    bool   `key:"authTlsEnabled" env:"AUTHENTICATION_SERVICE_TLS_ENABLED" default:"false" usage:"connect to the authentication service over TLS"`
+	AuthTLSCAFile     string `key:"authTlsCaFile" env:"AUTHENTICATION_SERVICE_TLS_CA_FILE" usage:"CA bundle verifying the authentication service, system roots when empty"`
+	AuthTLSCertFile   string `key:"authTlsCertFile" env:"AUTHENTICATION_SERVICE_TLS_CERT_FILE" usage:"client certificate for mutual TLS with the authentication service"`
+	AuthTLSKeyFile    string `key:"authTlsKeyFile" env:"AUTHENTICATION_SERVICE_TLS_KEY_FILE" usage:"client key for mutual TLS with the authentication service"`
+	AuthTLSServerName string `key:"authTlsServerName" env:"AUTHENTICATION_SERVICE_TLS_SERVER_NAME" usage:"name expected in the authentication service certificate, the dialed host when empty"`
+
+	// Backend address lists replace the matching host and port when set.
+	// Entries are "host:port" replicas or "dns:///host:port" names that
+	// expand to every address the name resolves to.
+	ProfileAddresses    []string `key:"profileAddresses" env:"PROFILE_SERVICE_ADDRESSES" usage:"comma separated profile service replicas"`
+	PostAddresses       []string `key:"postAddresses" env:"POST_SERVICE_ADDRESSES" usage:"comma separated post service replicas"`
+	ConnectionAddresses []string `key:"connectionAddresses" env:"CONNECTION_SERVICE_ADDRESSES" usage:"comma separated connection service replicas"`
+
+	LoadBalancing              string        `key:"loadBalancing" env:"GRPC_LOAD_BALANCING" default:"round_robin" validate:"round_robin|least_request" usage:"how calls are spread over replicas: round_robin or least_request"`
+	OutlierConsecutiveFailures int           `key:"outlierConsecutiveFailures" env:"GRPC_OUTLIER_CONSECUTIVE_FAILURES" default:"5" usage:"consecutive failures that eject a replica, 0 disables ejection"`
+	OutlierBaseEjectionTime    time.Duration `key:"outlierBaseEjectionTime" env:"GRPC_OUTLIER_BASE_EJECTION_TIME" default:"30s" usage:"ejection time, multiplied by the number of times the replica was ejected"`
+	OutlierMaxEjectionTime     time.Duration `key:"outlierMaxEjectionTime" env:"GRPC_OUTLIER_MAX_EJECTION_TIME" default:"5m" usage:"upper bound of a single ejection"`
+	OutlierMaxEjectionPercent  int           `key:"outlierMaxEjectionPercent" env:"GRPC_OUTLIER_MAX_EJECTION_PERCENT" default:"50" usage:"largest share of a backend's replicas ejected at once"`
+	DNSRefreshInterval         time.Duration `key:"dnsRefreshInterval" env:"GRPC_DNS_REFRESH_INTERVAL" default:"30s" usage:"how often dns:/// replica names are resolved again, never when 0"`
+
+	// The admin listener serves /metrics, /buildinfo, /config and pprof,
+	// which must not be reachable from the public port. It only leaves
+	// loopback when credentials protect it.
+	AdminHost         string `key:"adminHost" env:"GATEWAY_ADMIN_HOST" default:"127.0.0.1" usage:"address the admin listener binds to; any but loopback requires adminUsername and adminPassword"`
+	AdminPort         string `key:"adminPort" env:"GATEWAY_ADMIN_PORT" default:"9000" validate:"port" usage:"port of the admin listener, disabled when empty"`
+	AdminUsername     string `key:"adminUsername" env:"GATEWAY_ADMIN_USERNAME" usage:"basic auth user for the admin listener, no auth when empty"`
+	AdminPassword     string `key:"adminPassword" env:"GATEWAY_ADMIN_PASSWORD" secret:"true" usage:"basic auth password for the admin listener"`
+	AdminPprofEnabled bool   `key:"adminPprofEnabled" env:"GATEWAY_ADMIN_PPROF_ENABLED" default:"false" usage:"serve pprof under /debug/pprof on the admin listener"`
+
+	AccessLogEnabled     bool    `key:"accessLogEnabled" env:"ACCESS_LOG_ENABLED" default:"true" usage:"write a JSON line per request to stdout"`
+	LogLevel             string  `key:"logLevel" env:"LOG_LEVEL" default:"info" validate:"debug|info|warn|error" usage:"least severe access log line written: 2xx/3xx are info, 4xx warn, 5xx error"`
+	LogSuccessSampleRate float64 `key:"logSuccessSampleRate" env:"LOG_SUCCESS_SAMPLE_RATE" default:"1" validate:"fraction" usage:"fraction of successful requests logged, 0 to 1"`
+
+	// TracingProvider otel replaces the Jaeger tracer with OpenTelemetry:
+	// W3C traceparent headers, the sampler below and OTLP export. Handler
+	// spans are only recorded by the opentracing provider.
+	TracingProvider     string            `key:"tracingProvider" env:"TRACING_PROVIDER" default:"opentracing" validate:"opentracing|otel" usage:"tracing pipeline: opentracing (Jaeger) or otel (OTLP)"`
+	TracingSampler      string            `key:"tracingSampler" env:"TRACING_SAMPLER" default:"parent" validate:"ratio|parent" usage:"otel sampler: ratio samples tracingSampleRatio of all traces, parent follows the caller and uses the ratio for new traces"`
+	TracingSampleRatio  float64           `key:"tracingSampleRatio" env:"TRACING_SAMPLE_RATIO" default:"1" validate:"fraction" usage:"fraction of traces the otel sampler keeps, 0 to 1"`
+	TracingSampleErrors bool              `key:"tracingSampleErrors" env:"TRACING_SAMPLE_ERRORS" default:"true" usage:"export otel spans that end in an error even when their trace was not sampled"`
+	OTLPProtocol        string            `key:"otlpProtocol" env:"OTEL_EXPORTER_OTLP_PROTOCOL" default:"grpc" validate:"grpc|http" usage:"transport to the OpenTelemetry collector: grpc or http"`
+	OTLPEndpoint        string            `key:"otlpEndpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" default:"localhost:4317" usage:"collector address, host:port for grpc or a base URL such as http://localhost:4318 for http"`
+	OTLPHeaders         map[string]string `key:"otlpHeaders" env:"OTEL_EXPORTER_OTLP_HEADERS" secret:"true" usage:"comma separated key=value headers sent with every export"`
+	OTLPTLSEnabled      bool              `key:"otlpTlsEnabled" env:"OTLP_TLS_ENABLED" default:"false" usage:"connect to the collector over TLS"`
+	OTLPTLSCAFile       string            `key:"otlpTlsCaFile" env:"OTLP_TLS_CA_FILE" usage:"CA bundle verifying the collector, system roots when empty"`
+	OTLPTimeout         time.Duration     `key:"otlpTimeout" env:"OTLP_TIMEOUT" default:"10s" usage:"deadline for a single export"`
+	OTLPMetricsInterval time.Duration     `key:"otlpMetricsInterval" env:"OTLP_METRICS_INTERVAL" default:"0s" usage:"how often metrics are pushed to the collector as well, never when 0"`
+
+	// DiscoveryProvider other than static replaces backend addresses with
+	// the ones it reports and keeps following them without a restart.
+	DiscoveryProvider string            `key:"discoveryProvider" env:"DISCOVERY_PROVIDER" default:"static" validate:"static|file|srv" usage:"where backend replicas come from: static, file or srv"`
+	DiscoveryFile     string            `key:"discoveryFile" env:"DISCOVERY_FILE" usage:"YAML or JSON file mapping backends to replica lists"`
+	DiscoverySRVNames map[string]string `key:"discoverySrvNames" env:"DISCOVERY_SRV_NAMES" usage:"comma separated backend=SRV name pairs"`
+	DiscoveryInterval time.Duration     `key:"discoveryInterval" env:"DISCOVERY_INTERVAL" default:"10s" usage:"how often the discovery provider is polled, once at startup when 0"`
+
+	// RetryMethods names idempotent RPCs, bare ("GetAll") or qualified by
+	// service ("PostService/GetAll"); only those are retried.
+	RetryMethods        []string      `key:"retryMethods" env:"GRPC_RETRY_METHODS" default:"Get,GetAll,GetByName,GetAllJobs,SearchJobsByPosition,GetChatMessages,GetConnectionsUsernamesFor,GetRequestsUsernamesFor,GetBlockedConnectionsUsernames" usage:"idempotent RPCs that may be retried"`
+	RetryMaxAttempts    int           `key:"retryMaxAttempts" env:"GRPC_RETRY_MAX_ATTEMPTS" default:"3" usage:"attempts per call including the first, 1 disables retries"`
+	RetryInitialBackoff time.Duration `key:"retryInitialBackoff" env:"GRPC_RETRY_INITIAL_BACKOFF" default:"50ms" usage:"wait before the first retry"`
+	RetryMaxBackoff     time.Duration `key:"retryMaxBackoff" env:"GRPC_RETRY_MAX_BACKOFF" default:"1s" usage:"upper bound of the wait between retries"`
+	RetryMultiplier     float64       `key:"retryMultiplier" env:"GRPC_RETRY_MULTIPLIER" default:"2" usage:"growth of the wait between retries"`
+	RetryJitter         float64       `key:"retryJitter" env:"GRPC_RETRY_JITTER" default:"0.2" validate:"fraction" usage:"randomised fraction of each wait, 0 to 1"`
+	RetryBudgetTokens   float64       `key:"retryBudgetTokens" env:"GRPC_RETRY_BUDGET_TOKENS" default:"10" validate:"positive" usage:"retry budget per backend; retries stop below half of it"`
+	RetryBudgetRatio    float64       `key:"retryBudgetRatio" env:"GRPC_RETRY_BUDGET_RATIO" default:"0.1" validate:"positive" usage:"budget tokens earned by each successful call"`
+
+	BreakerFailureThreshold int           `key:"breakerFailureThreshold" env:"BREAKER_FAILURE_THRESHOLD" default:"5" usage:"consecutive backend failures that open its circuit breaker, 0 disables breakers"`
+	BreakerOpenTimeout      time.Duration `key:"breakerOpenTimeout" env:"BREAKER_OPEN_TIMEOUT" default:"30s" usage:"how long an open breaker rejects calls before trying the backend again"`
+	BreakerHalfOpenRequests int           `key:"breakerHalfOpenRequests" env:"BREAKER_HALF_OPEN_REQUESTS" default:"1" usage:"trial calls that must succeed to close a breaker"`
+
+	JWTAlgorithms       []string      `key:"jwtAlgorithms" env:"JWT_ALGORITHMS" default:"HS256" required:"true" usage:"accepted JWT signing algorithms"`
+	JWTSecret           string        `key:"jwtSecret" env:"JWT_SECRET" secret:"true" usage:"HMAC secret for HS256 tokens, required when an HS algorithm is accepted"`
+	JWTPublicKeyFile    string        `key:"jwtPublicKeyFile" env:"JWT_PUBLIC_KEY_FILE" usage:"PEM encoded RSA or EC public key for RS256/ES256 tokens"`
+	JWKSSource          string        `key:"jwksSource" env:"JWT_JWKS_SOURCE" usage:"JWKS file path or URL"`
+	JWKSRefreshInterval time.Duration `key:"jwksRefreshInterval" env:"JWT_JWKS_REFRESH_INTERVAL" default:"5m" usage:"how often the JWKS document is reloaded"`
+	JWTIssuer           string        `key:"jwtIssuer" env:"JWT_ISSUER" usage:"required iss claim, unchecked when empty"`
+	JWTAudience         string        `key:"jwtAudience" env:"JWT_AUDIENCE" usage:"required aud claim, unchecked when empty"`
+	JWTClockSkew        time.Duration `key:"jwtClockSkew" env:"JWT_CLOCK_SKEW" default:"30s" usage:"tolerance for exp and nbf checks"`
+
+	AuthTokenSources       []string `key:"authTokenSources" env:"AUTH_TOKEN_SOURCES" default:"cookie,bearer" required:"true" usage:"where to read the JWT from, in order of precedence (cookie, bearer)"`
+	AuthCookieName         string   `key:"authCookieName" env:"AUTH_COOKIE_NAME" default:"token" required:"true" usage:"cookie that carries the JWT"`
+	AuthCookieRequiresCSRF bool     `key:"authCookieRequiresCsrf" env:"AUTH_COOKIE_REQUIRES_CSRF" default:"false" usage:"reject cookie auth on state-changing methods without a CSRF check"`
+	AllowedOrigins         []string `key:"allowedOrigins" env:"GATEWAY_ALLOWED_ORIGINS" default:"http://localhost:4200,http://localhost:4200/**" usage:"origins allowed by CORS"`
+
+	AuthProxyTimeout      time.Duration `key:"authProxyTimeout" env:"AUTH_PROXY_TIMEOUT" default:"10s" validate:"positive" usage:"deadline for requests proxied to the auth service"`
+	AuthProxyCookieDomain string        `key:"authProxyCookieDomain" env:"AUTH_PROXY_COOKIE_DOMAIN" usage:"domain for cookies set by the auth service, host-only when empty"`
+	AuthProxyCookiePath   string        `key:"authProxyCookiePath" env:"AUTH_PROXY_COOKIE_PATH" default:"/" usage:"path for cookies set by the auth service, kept when empty"`
+	AuthProxyCookieSecure bool          `key:"authProxyCookieSecure" env:"AUTH_PROXY_COOKIE_SECURE" default:"false" usage:"mark cookies set by the auth service Secure"`
+
+	CSRFEnabled      bool     `key:"csrfEnabled" env:"CSRF_ENABLED" default:"true" usage:"require a CSRF token on cookie-authenticated state-changing requests"`
+	CSRFSecret       string   `key:"csrfSecret" env:"CSRF_SECRET" secret:"true" usage:"key that signs CSRF tokens, shared by all replicas; required when CSRF checks are enabled"`
+	CSRFCookieName   string   `key:"csrfCookieName" env:"CSRF_COOKIE_NAME" default:"XSRF-TOKEN" required:"true" usage:"cookie that carries the CSRF token"`
+	CSRFHeaderName   string   `key:"csrfHeaderName" env:"CSRF_HEADER_NAME" default:"X-XSRF-TOKEN" required:"true" usage:"header that must echo the CSRF token"`
+	CSRFCookieSecure bool     `key:"csrfCookieSecure" env:"CSRF_COOKIE_SECURE" default:"false" usage:"mark the CSRF cookie Secure"`
+	CSRFExemptPaths  []string `key:"csrfExemptPaths" env:"CSRF_EXEMPT_PATHS" default:"/login,/refresh" usage:"paths, or prefixes ending in *, that skip the CSRF check"`
+
+	RevocationStore            string        `key:"revocationStore" env:"REVOCATION_STORE" default:"memory" validate:"memory|redis" usage:"where revoked tokens are kept: memory or redis"`
+	RevocationMaxTokenLifetime time.Duration `key:"revocationMaxTokenLifetime" env:"REVOCATION_MAX_TOKEN_LIFETIME" default:"24h" usage:"longest lifetime of an issued token, how long per-user revocations are kept"`
+	RedisAddress               string        `key:"redisAddress" env:"REDIS_ADDRESS" default:"localhost:6379" usage:"host:port of the Redis-compatible revocation store"`
+	RedisPassword              string        `key:"redisPassword" env:"REDIS_PASSWORD" secret:"true" usage:"Redis password, no AUTH when empty"`
+	RedisDatabase              int           `key:"redisDatabase" env:"REDIS_DATABASE" default:"0" usage:"Redis database number"`
+	RedisTimeout               time.Duration `key:"redisTimeout" env:"REDIS_TIMEOUT" default:"500ms" validate:"positive" usage:"deadline for a single Redis command"`
+
+	// RateLimits entries read "<method> <pattern> <key> <requests>/<duration> [burst]"
+	// where key is user, ip or apikey.
+	RateLimits              []string `key:"rateLimits" env:"RATE_LIMITS" default:"POST /login ip 10/1m,GET /refresh ip 30/1m,GET /profile/search/{name} ip 60/1m,POST /post/comment user 30/1m 10" usage:"per route token bucket limits"`
+	RateLimitEnabled        bool     `key:"rateLimitEnabled" env:"RATE_LIMIT_ENABLED" default:"true" usage:"throttle the routes listed in rateLimits"`
+	RateLimitTrustedProxies []string `key:"rateLimitTrustedProxies" env:"RATE_LIMIT_TRUSTED_PROXIES" usage:"addresses or CIDRs allowed to set X-Forwarded-For"`
+	RateLimitAPIKeyHeader   string   `key:"rateLimitApiKeyHeader" env:"RATE_LIMIT_API_KEY_HEADER" default:"X-Api-Key" usage:"header identifying API key callers"`
+	RateLimitAPIKeys        []string `key:"rateLimitApiKeys" env:"RATE_LIMIT_API_KEYS" secret:"true" usage:"API keys limited per key; callers with other keys are limited by IP"`
+
+	// AuthzDefaultPolicy applies to requests that match no entry of the
+	// policy table. Startup already refuses routes without an entry, so this
+	// only covers paths that no route serves.
+	AuthzDefaultPolicy string `key:"authzDefaultPolicy" env:"AUTHZ_DEFAULT_POLICY" default:"authenticated" validate:"public|authenticated" usage:"policy for requests without an entry: public or authenticated"`
+
+	ConfigFile  string `key:"-" env:"GATEWAY_CONFIG_FILE" flag:"config" usage:"optional YAML or JSON config file"`
+	PrintConfig bool   `key:"-" flag:"print-config" usage:"print the effective configuration and exit"`
+
+	// sources records which layer supplied each field, keyed by field name.
+	sources map[string]string
 }
 
-func NewConfig() *Config {
-	if _, err := os.Stat("/.dockerenv"); err == nil {
-		fmt.Println("docker")
-
-		return &Config{
-			Port:           os.Getenv("GATEWAY_PORT"),
-			ProfileHost:    os.Getenv("PROFILE_SERVICE_HOST"),
-			ProfilePort:    os.Getenv("PROFILE_SERVICE_PORT"),
-			PostHost:       os.Getenv("POST_SERVICE_HOST"),
-			PostPort:       os.Getenv("POST_SERVICE_PORT"),
-			AuthHost:       os.Getenv("AUTHENTICATION_SERVICE_HOST"),
-			AuthPort:       os.Getenv("AUTHENTICATION_SERVICE_PORT"),
-			ConnectionHost: os.Getenv("CONNECTION_SERVICE_HOST"),
-			ConnectionPort: os.Getenv("CONNECTION_SERVICE_PORT"),
-		}
-	} else {
-		fmt.Println("local")
-
-		return &Config{
-			Port:           "8000",
-			ProfileHost:    "localhost",
-			ProfilePort:    "8001",
-			PostHost:       "localhost",
-			PostPort:       "8002",
-			AuthHost:       "localhost",
-			AuthPort:       "8003",
-			ConnectionHost: "localhost",
-			ConnectionPort: "8004",
+func (config *Config) IsOptional(dependency string) bool {
+	for _, optional := range config.OptionalDependencies {
+		if optional == dependency {
+			return true
 		}
 	}
+	return false
+}
+
+// BackendAddresses returns the replicas of a gRPC backend ("profile", "post"
+// or "connection"), falling back to its single host and port.
+func (config *Config) BackendAddresses(backend string) []string {
+	switch backend {
+	case "profile":
+		return addresses(config.ProfileAddresses, config.ProfileHost, config.ProfilePort)
+	case "post":
+		return addresses(config.PostAddresses, config.PostHost, config.PostPort)
+	case "connection":
+		return addresses(config.ConnectionAddresses, config.ConnectionHost, config.ConnectionPort)
+	}
+	return nil
+}
+
+func addresses(list []string, host, port string) []string {
+	if len(list) > 0 {
+		return list
+	}
+	return []string{net.JoinHostPort(host, port)}
+}
+
+// BackendTLS is the client TLS setup for one backend.
+type BackendTLS struct {
+	Enabled    bool
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+}
+
+// BackendTLS returns the TLS setup of a backend ("profile", "post",
+// "connection" or "auth").
+func (config *Config) BackendTLS(backend string) BackendTLS {
+	switch backend {
+	case "profile":
+		return BackendTLS{config.ProfileTLSEnabled, config.ProfileTLSCAFile, config.ProfileTLSCertFile, config.ProfileTLSKeyFile, config.ProfileTLSServerName}
+	case "post":
+		return BackendTLS{config.PostTLSEnabled, config.PostTLSCAFile, config.PostTLSCertFile, config.PostTLSKeyFile, config.PostTLSServerName}
+	case "connection":
+		return BackendTLS{config.ConnectionTLSEnabled, config.ConnectionTLSCAFile, config.ConnectionTLSCertFile, config.ConnectionTLSKeyFile, config.ConnectionTLSServerName}
+	case "auth":
+		return BackendTLS{config.AuthTLSEnabled, config.AuthTLSCAFile, config.AuthTLSCertFile, config.AuthTLSKeyFile, config.AuthTLSServerName}
+	}
+	return BackendTLS{}
+}
+
+// Source returns the layer that set the named field, for example "default",
+// "file:/etc/gateway.yaml", "env:GATEWAY_PORT" or "flag:-port".
+func (config *Config) Source(name string) string {
+	return config.sources[name]
 }
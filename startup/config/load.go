@@ -0,0 +1,441 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "<redacted>"
+
+// field describes one Config field and where each layer looks for it. The
+// layers are driven by struct tags:
+//
+//	key      name in the config file; "-" keeps the field out of the file
+//	env      environment variable; "-" keeps the field out of the environment
+//	flag     command-line flag, derived from key when omitted
+//	default  built-in default
+//	required the value must not be empty after all layers are applied
+//	validate "port" checks for a TCP port number, "positive" for a number
+//	         above zero, "fraction" for a number from 0 to 1, "a|b" for one
+//	         of the values
+//	secret   the value is redacted when the config is printed
+type field struct {
+	name     string
+	key      string
+	env      string
+	flag     string
+	def      string
+	usage    string
+	required bool
+	validate string
+	secret   bool
+	value    reflect.Value
+}
+
+// NewConfig applies defaults, the file named by -config or
+// GATEWAY_CONFIG_FILE, the environment and args in that order, then
+// validates the result.
+func NewConfig(args []string) (*Config, error) {
+	config := &Config{sources: map[string]string{}}
+	fields := config.fields()
+
+	flags := flag.NewFlagSet("api-gateway", flag.ContinueOnError)
+	flagValues := make(map[string]*rawFlag, len(fields))
+	for _, f := range fields {
+		if f.flag == "-" {
+			continue
+		}
+		value := &rawFlag{isBool: f.value.Kind() == reflect.Bool}
+		flags.Var(value, f.flag, f.usage)
+		flagValues[f.name] = value
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	for _, f := range fields {
+		if err := config.set(f, f.def, "default"); err != nil {
+			return nil, err
+		}
+	}
+	if err := config.applyFile(fields, flagValues); err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		if f.env == "-" {
+			continue
+		}
+		if value, ok := os.LookupEnv(f.env); ok {
+			if err := config.set(f, value, "env:"+f.env); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, f := range fields {
+		if value, ok := flagValues[f.name]; ok && value.set {
+			if err := config.set(f, value.value, "flag:-"+f.flag); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := config.validate(fields); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (config *Config) applyFile(fields []field, flagValues map[string]*rawFlag) error {
+	path := config.ConfigFile
+	if value, ok := flagValues["ConfigFile"]; ok && value.set {
+		path = value.value
+	} else if value, ok := os.LookupEnv("GATEWAY_CONFIG_FILE"); ok {
+		path = value
+	}
+	if path == "" {
+		return nil
+	}
+	config.ConfigFile = path
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	// JSON is valid YAML, so one decoder covers both formats.
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	byKey := make(map[string]field, len(fields))
+	for _, f := range fields {
+		if f.key != "-" {
+			byKey[f.key] = f
+		}
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		f, ok := byKey[key]
+		if !ok {
+			return fmt.Errorf("config file %s: unknown key %q", path, key)
+		}
+		if err := config.set(f, fileValue(values[key]), "file:"+path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileValue flattens a decoded YAML value into the same textual form used by
+// environment variables and flags.
+func fileValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fileValue(item))
+		}
+		return strings.Join(items, ",")
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		items := make([]string, 0, len(v))
+		for _, key := range keys {
+			items = append(items, key+"="+fileValue(v[key]))
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (config *Config) set(f field, raw string, source string) error {
+	if err := setValue(f.value, raw); err != nil {
+		return fmt.Errorf("%s: invalid value %q for %s: %v", source, raw, f.name, err)
+	}
+	config.sources[f.name] = source
+	return nil
+}
+
+func setValue(value reflect.Value, raw string) error {
+	switch value.Interface().(type) {
+	case time.Duration:
+		if raw == "" {
+			value.SetInt(0)
+			return nil
+		}
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(duration))
+		return nil
+	case []string:
+		items := make([]string, 0)
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items))
+		return nil
+	case map[string]string:
+		items := map[string]string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			parts := strings.SplitN(item, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("expected key=value, got %q", item)
+			}
+			items[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+		value.Set(reflect.ValueOf(items))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		if raw == "" {
+			value.SetBool(false)
+			return nil
+		}
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(parsed)
+	case reflect.Int, reflect.Int64:
+		if raw == "" {
+			value.SetInt(0)
+			return nil
+		}
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		value.SetInt(parsed)
+	case reflect.Float64:
+		if raw == "" {
+			value.SetFloat(0)
+			return nil
+		}
+		parsed, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		value.SetFloat(parsed)
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+	return nil
+}
+
+// isEmpty treats empty lists and maps like unset values.
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
+
+// validate reports every problem at once so a broken deployment can be fixed
+// in a single pass.
+func (config *Config) validate(fields []field) error {
+	problems := make([]string, 0)
+	for _, f := range fields {
+		empty := isEmpty(f.value)
+		if f.required && empty {
+			problems = append(problems, fmt.Sprintf("%s is required but empty (set by %s)", f.describe(), config.sources[f.name]))
+			continue
+		}
+		if f.value.Kind() == reflect.Int64 && f.value.Int() < 0 && f.validate != "positive" {
+			problems = append(problems, fmt.Sprintf("%s must not be negative (set by %s)", f.describe(), config.sources[f.name]))
+		}
+		if strings.Contains(f.validate, "|") && !empty {
+			allowed := strings.Split(f.validate, "|")
+			valid := false
+			for _, value := range allowed {
+				valid = valid || f.value.String() == value
+			}
+			if !valid {
+				problems = append(problems, fmt.Sprintf("%s must be one of %s, got %q (set by %s)", f.describe(), strings.Join(allowed, ", "), f.value.String(), config.sources[f.name]))
+			}
+		}
+		if f.validate == "positive" && number(f.value) <= 0 {
+			problems = append(problems, fmt.Sprintf("%s must be above zero, got %s (set by %s)", f.describe(), display(f.value), config.sources[f.name]))
+		}
+		if f.validate == "fraction" && (number(f.value) < 0 || number(f.value) > 1) {
+			problems = append(problems, fmt.Sprintf("%s must be from 0 to 1, got %s (set by %s)", f.describe(), display(f.value), config.sources[f.name]))
+		}
+		if f.validate == "port" && !empty {
+			port, err := strconv.Atoi(f.value.String())
+			if err != nil || port < 1 || port > 65535 {
+				problems = append(problems, fmt.Sprintf("%s must be a port number, got %q (set by %s)", f.describe(), f.value.String(), config.sources[f.name]))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// number reads the numeric fields that "positive" and "fraction" apply to.
+func number(value reflect.Value) float64 {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int64:
+		return float64(value.Int())
+	case reflect.Float64:
+		return value.Float()
+	}
+	return 0
+}
+
+func display(value reflect.Value) string {
+	if duration, ok := value.Interface().(time.Duration); ok {
+		return duration.String()
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+func (f field) describe() string {
+	names := []string{}
+	if f.key != "-" {
+		names = append(names, "key "+f.key)
+	}
+	if f.env != "-" {
+		names = append(names, "env "+f.env)
+	}
+	if f.flag != "-" {
+		names = append(names, "flag -"+f.flag)
+	}
+	return fmt.Sprintf("%s (%s)", f.name, strings.Join(names, ", "))
+}
+
+// Print writes the effective configuration as YAML, annotating each value
+// with its source and redacting secrets.
+func (config *Config) Print(w io.Writer) error {
+	document := &yaml.Node{Kind: yaml.MappingNode}
+	for _, f := range config.fields() {
+		if f.key == "-" {
+			continue
+		}
+		value := &yaml.Node{}
+		if err := value.Encode(f.value.Interface()); err != nil {
+			return err
+		}
+		if f.value.Type() == reflect.TypeOf(time.Duration(0)) {
+			value = &yaml.Node{Kind: yaml.ScalarNode, Value: time.Duration(f.value.Int()).String()}
+		}
+		if f.secret && !isEmpty(f.value) {
+			value = &yaml.Node{Kind: yaml.ScalarNode, Value: redacted}
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: f.key}
+		if value.Kind == yaml.ScalarNode || len(value.Content) == 0 {
+			value.LineComment = config.sources[f.name]
+		} else {
+			key.LineComment = config.sources[f.name]
+		}
+		document.Content = append(document.Content, key, value)
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func (config *Config) fields() []field {
+	value := reflect.ValueOf(config).Elem()
+	fields := make([]field, 0, value.NumField())
+	for i := 0; i < value.NumField(); i++ {
+		structField := value.Type().Field(i)
+		key, ok := structField.Tag.Lookup("key")
+		if !ok {
+			continue
+		}
+		f := field{
+			name:     structField.Name,
+			key:      key,
+			env:      structField.Tag.Get("env"),
+			flag:     structField.Tag.Get("flag"),
+			def:      structField.Tag.Get("default"),
+			usage:    structField.Tag.Get("usage"),
+			required: structField.Tag.Get("required") == "true",
+			validate: structField.Tag.Get("validate"),
+			secret:   structField.Tag.Get("secret") == "true",
+			value:    value.Field(i),
+		}
+		if f.env == "" {
+			f.env = "-"
+		}
+		if f.flag == "" {
+			f.flag = kebab(key)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// kebab turns a camelCase key into a flag name such as "profile-host".
+func kebab(key string) string {
+	var builder strings.Builder
+	for i, r := range key {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				builder.WriteByte('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+// rawFlag keeps a flag as text until every layer has been read, and
+// remembers whether it was given at all.
+type rawFlag struct {
+	value  string
+	set    bool
+	isBool bool
+}
+
+func (f *rawFlag) String() string {
+	return f.value
+}
+
+func (f *rawFlag) Set(value string) error {
+	f.value = value
+	f.set = true
+	return nil
+}
+
+func (f *rawFlag) IsBoolFlag() bool {
+	return f.isBool
+}
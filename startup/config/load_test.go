@@ -0,0 +1,149 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "port: \"8100\"\nprofilePort: \"8101\"\npostPort: \"8102\"\nauthProxyTimeout: 3s\n")
+	t.Setenv("GATEWAY_CONFIG_FILE", path)
+	t.Setenv("PROFILE_SERVICE_PORT", "8201")
+	t.Setenv("POST_SERVICE_PORT", "8202")
+
+	config, err := NewConfig([]string{"-post-port", "8302"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		got    interface{}
+		want   interface{}
+		source string
+	}{
+		{"AuthPort", config.AuthPort, "8003", "default"},
+		{"Port", config.Port, "8100", "file:" + path},
+		{"AuthProxyTimeout", config.AuthProxyTimeout, 3 * time.Second, "file:" + path},
+		{"ProfilePort", config.ProfilePort, "8201", "env:PROFILE_SERVICE_PORT"},
+		{"PostPort", config.PostPort, "8302", "flag:-post-port"},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, test.got, test.want)
+		}
+		if got := config.Source(test.name); got != test.source {
+			t.Errorf("%s: got source %q, want %q", test.name, got, test.source)
+		}
+	}
+}
+
+func TestNewConfigFileFlag(t *testing.T) {
+	path := writeConfigFile(t, "{\"port\": \"8100\", \"rateLimitApiKeys\": [\"a\", \"b\"]}")
+	t.Setenv("GATEWAY_CONFIG_FILE", writeConfigFile(t, "port: \"8200\"\n"))
+
+	config, err := NewConfig([]string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Port != "8100" || config.ConfigFile != path {
+		t.Errorf("got port %s from %s, want 8100 from %s", config.Port, config.ConfigFile, path)
+	}
+	if len(config.RateLimitAPIKeys) != 2 || config.RateLimitAPIKeys[1] != "b" {
+		t.Errorf("got API keys %v, want [a b]", config.RateLimitAPIKeys)
+	}
+}
+
+func TestNewConfigRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		err  string
+	}{
+		{"unknown file key", "prot: \"8100\"\n", nil, nil, `unknown key "prot"`},
+		{"required field empty", "", map[string]string{"GATEWAY_PORT": ""}, nil, "Port (key port, env GATEWAY_PORT, flag -port) is required but empty (set by env:GATEWAY_PORT)"},
+		{"port out of range", "authPort: \"70000\"\n", nil, nil, `AuthPort (key authPort, env AUTHENTICATION_SERVICE_PORT, flag -auth-port) must be a port number, got "70000"`},
+		{"port not a number", "", nil, []string{"-port", "http"}, `must be a port number, got "http" (set by flag:-port)`},
+		{"unknown choice", "", map[string]string{"LOG_LEVEL": "trace"}, nil, `LogLevel (key logLevel, env LOG_LEVEL, flag -log-level) must be one of debug, info, warn, error, got "trace"`},
+		{"negative duration", "", nil, []string{"-shutdown-timeout", "-1s"}, "ShutdownTimeout (key shutdownTimeout, env GATEWAY_SHUTDOWN_TIMEOUT, flag -shutdown-timeout) must not be negative"},
+		{"zero auth proxy timeout", "authProxyTimeout: 0s\n", nil, nil, "AuthProxyTimeout (key authProxyTimeout, env AUTH_PROXY_TIMEOUT, flag -auth-proxy-timeout) must be above zero, got 0s"},
+		{"negative redis timeout", "", map[string]string{"REDIS_TIMEOUT": "-5ms"}, nil, "RedisTimeout (key redisTimeout, env REDIS_TIMEOUT, flag -redis-timeout) must be above zero, got -5ms (set by env:REDIS_TIMEOUT)"},
+		{"retry jitter above 1", "", nil, []string{"-retry-jitter", "1.5"}, "RetryJitter (key retryJitter, env GRPC_RETRY_JITTER, flag -retry-jitter) must be from 0 to 1, got 1.5"},
+		{"negative sample ratio", "tracingSampleRatio: -0.1\n", nil, nil, "TracingSampleRatio (key tracingSampleRatio, env TRACING_SAMPLE_RATIO, flag -tracing-sample-ratio) must be from 0 to 1, got -0.1"},
+		{"empty retry budget", "", map[string]string{"GRPC_RETRY_BUDGET_TOKENS": "0"}, nil, "RetryBudgetTokens (key retryBudgetTokens, env GRPC_RETRY_BUDGET_TOKENS, flag -retry-budget-tokens) must be above zero, got 0"},
+		{"zero retry budget ratio", "", nil, []string{"-retry-budget-ratio", "0"}, "RetryBudgetRatio (key retryBudgetRatio, env GRPC_RETRY_BUDGET_RATIO, flag -retry-budget-ratio) must be above zero, got 0"},
+		{"unparsable value", "", map[string]string{"REDIS_TIMEOUT": "soon"}, nil, `env:REDIS_TIMEOUT: invalid value "soon" for RedisTimeout`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.file != "" {
+				t.Setenv("GATEWAY_CONFIG_FILE", writeConfigFile(t, test.file))
+			}
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+			_, err := NewConfig(test.args)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want one containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestNewConfigReportsEveryProblem(t *testing.T) {
+	_, err := NewConfig([]string{"-port", "0", "-redis-timeout", "0s", "-retry-jitter", "2"})
+	if err == nil {
+		t.Fatal("got no error")
+	}
+	for _, name := range []string{"Port", "RedisTimeout", "RetryJitter"} {
+		if !strings.Contains(err.Error(), "\n  "+name+" ") {
+			t.Errorf("got error %q, want a line for %s", err, name)
+		}
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	t.Setenv("JWT_SECRET", "hunter2")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "api-key=hunter3")
+	config, err := NewConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := config.Print(&out); err != nil {
+		t.Fatal(err)
+	}
+	printed := out.String()
+	for _, secret := range []string{"hunter2", "hunter3"} {
+		if strings.Contains(printed, secret) {
+			t.Errorf("printed config contains the secret %q:\n%s", secret, printed)
+		}
+	}
+	tests := []string{
+		"jwtSecret: <redacted> # env:JWT_SECRET\n",
+		"otlpHeaders: <redacted> # env:OTEL_EXPORTER_OTLP_HEADERS\n",
+		"csrfSecret: \"\" # default\n",
+		"rateLimitApiKeys: [] # default\n",
+		"port: \"8000\" # default\n",
+		"authProxyTimeout: 10s # default\n",
+	}
+	for _, want := range tests {
+		if !strings.Contains(printed, want) {
+			t.Errorf("printed config lacks %q:\n%s", want, printed)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package startup
+
+import (
+	"api-gateway/infrastructure/services"
+	"net/http"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// routePolicies decides who may call each route. NewServer refuses to start
+// when a custom route or a route generated from the backend protos is missing
+// from this table.
+var routePolicies = []services.RoutePolicy{
+	{Method: "POST", Pattern: "/login", Policy: services.Public},
+	{Method: "GET", Pattern: "/refresh", Policy: services.Public},
+	// Logout revokes the caller's token, so it needs to know the caller.
+	{Method: "POST", Pattern: "/logout", Policy: services.Authenticated},
+	{Method: "POST", Pattern: "/admin/users/{id}/revoke", Policy: services.RequireRole("admin")},
+	{Method: "GET", Pattern: "/csrf", Policy: services.Public},
+	{Method: "GET", Pattern: "/healthz", Policy: services.Public},
+	{Method: "GET", Pattern: "/readyz", Policy: services.Public},
+
+	{Method: "GET", Pattern: "/profile", Policy: services.Public},
+	{Method: "GET", Pattern: "/profile/{id}", Policy: services.Public},
+	// Registration creates the profile before the user can log in.
+	{Method: "POST", Pattern: "/profile", Policy: services.Public},
+	{Method: "PUT", Pattern: "/profile/{id}", Policy: services.OwnerOfPathParam("id")},
+	{Method: "GET", Pattern: "/profile/search/{name}", Policy: services.Public},
+
+	{Method: "GET", Pattern: "/post", Policy: services.Public},
+	{Method: "GET", Pattern: "/post/{id}", Policy: services.Public},
+	{Method: "POST", Pattern: "/post", Policy: services.OwnerOfBodyField("userId")},
+	{Method: "GET", Pattern: "/post/job", Policy: services.Public},
+	// External job boards authenticate with the API key in the body, which
+	// the post service validates.
+	{Method: "POST", Pattern: "/post/job", Policy: services.Public},
+	{Method: "POST", Pattern: "/post/job/apikey", Policy: services.Authenticated},
+	{Method: "GET", Pattern: "/post/job/{search}", Policy: services.Public},
+	{Method: "POST", Pattern: "/post/job/dislinkt", Policy: services.Authenticated},
+	{Method: "POST", Pattern: "/post/like", Policy: services.Authenticated},
+	{Method: "POST", Pattern: "/post/dislike", Policy: services.Authenticated},
+	{Method: "POST", Pattern: "/post/comment", Policy: services.Authenticated},
+	{Method: "POST", Pattern: "/post/image", Policy: services.Authenticated},
+
+	{Method: "POST", Pattern: "/connection", Policy: services.OwnerOfBodyField("requestSenderId")},
+	{Method: "POST", Pattern: "/connection/request", Policy: services.OwnerOfBodyField("requestSenderId")},
+	{Method: "PUT", Pattern: "/connection/approve", Policy: services.OwnerOfBodyField("requestSenderId")},
+	{Method: "GET", Pattern: "/connection/usernames/{id}", Policy: services.OwnerOfPathParam("id")},
+	{Method: "GET", Pattern: "/connection/requests/{id}", Policy: services.OwnerOfPathParam("id")},
+	// Called during registration, before the user has a token.
+	{Method: "POST", Pattern: "/connection/user", Policy: services.Public},
+	{Method: "POST", Pattern: "/connection/block", Policy: services.OwnerOfBodyField("requestSenderId")},
+	{Method: "GET", Pattern: "/connection/blocked/usernames/{id}", Policy: services.OwnerOfPathParam("id")},
+	{Method: "PUT", Pattern: "/connection/user", Policy: services.OwnerOfBodyField("userId")},
+}
+
+// generatedRoutes lists the HTTP bindings declared in the protos linked into
+// the gateway, which are the routes grpc-gateway registers on the mux.
+func generatedRoutes(files *protoregistry.Files) []services.RoutePolicy {
+	routes := make([]services.RoutePolicy, 0)
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		for i := 0; i < file.Services().Len(); i++ {
+			methods := file.Services().Get(i).Methods()
+			for j := 0; j < methods.Len(); j++ {
+				options, ok := methods.Get(j).Options().(*descriptorpb.MethodOptions)
+				if !ok || !proto.HasExtension(options, annotations.E_Http) {
+					continue
+				}
+				rule := proto.GetExtension(options, annotations.E_Http).(*annotations.HttpRule)
+				routes = appendHTTPRule(routes, rule)
+				for _, binding := range rule.GetAdditionalBindings() {
+					routes = appendHTTPRule(routes, binding)
+				}
+			}
+		}
+		return true
+	})
+	return routes
+}
+
+func appendHTTPRule(routes []services.RoutePolicy, rule *annotations.HttpRule) []services.RoutePolicy {
+	var method, pattern string
+	switch binding := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		method, pattern = http.MethodGet, binding.Get
+	case *annotations.HttpRule_Put:
+		method, pattern = http.MethodPut, binding.Put
+	case *annotations.HttpRule_Post:
+		method, pattern = http.MethodPost, binding.Post
+	case *annotations.HttpRule_Delete:
+		method, pattern = http.MethodDelete, binding.Delete
+	case *annotations.HttpRule_Patch:
+		method, pattern = http.MethodPatch, binding.Patch
+	case *annotations.HttpRule_Custom:
+		method, pattern = binding.Custom.GetKind(), binding.Custom.GetPath()
+	default:
+		return routes
+	}
+	// The policy table writes a single segment variable as {id}, protos may
+	// spell it {id=*}.
+	pattern = strings.ReplaceAll(pattern, "=*}", "}")
+	return append(routes, services.RoutePolicy{Method: method, Pattern: pattern})
+}
@@ -0,0 +1,116 @@
+package startup
+
+import (
+	"api-gateway/infrastructure/services"
+	"reflect"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestGeneratedRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  map[string]*annotations.HttpRule
+		routes []services.RoutePolicy
+	}{
+		{
+			name:   "no bindings",
+			rules:  map[string]*annotations.HttpRule{"Get": nil},
+			routes: []services.RoutePolicy{},
+		},
+		{
+			name: "get and post",
+			rules: map[string]*annotations.HttpRule{
+				"Get":  {Pattern: &annotations.HttpRule_Get{Get: "/post/{id}"}},
+				"Post": {Pattern: &annotations.HttpRule_Post{Post: "/post"}, Body: "*"},
+			},
+			routes: []services.RoutePolicy{
+				{Method: "GET", Pattern: "/post/{id}"},
+				{Method: "POST", Pattern: "/post"},
+			},
+		},
+		{
+			name: "additional bindings and explicit wildcards",
+			rules: map[string]*annotations.HttpRule{
+				"Get": {
+					Pattern: &annotations.HttpRule_Get{Get: "/post/{id=*}"},
+					AdditionalBindings: []*annotations.HttpRule{
+						{Pattern: &annotations.HttpRule_Delete{Delete: "/post/{id}"}},
+					},
+				},
+			},
+			routes: []services.RoutePolicy{
+				{Method: "GET", Pattern: "/post/{id}"},
+				{Method: "DELETE", Pattern: "/post/{id}"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files := new(protoregistry.Files)
+			file, err := protodesc.NewFile(serviceFile(test.rules), files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := files.RegisterFile(file); err != nil {
+				t.Fatal(err)
+			}
+			if routes := generatedRoutes(files); !reflect.DeepEqual(routes, test.routes) {
+				t.Errorf("got %v, want %v", routes, test.routes)
+			}
+		})
+	}
+}
+
+func TestCoverageIncludesGeneratedRoutes(t *testing.T) {
+	files := new(protoregistry.Files)
+	file, err := protodesc.NewFile(serviceFile(map[string]*annotations.HttpRule{
+		"Get":  {Pattern: &annotations.HttpRule_Get{Get: "/post/{id}"}},
+		"Post": {Pattern: &annotations.HttpRule_Post{Post: "/post/unlisted"}},
+	}), files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := files.RegisterFile(file); err != nil {
+		t.Fatal(err)
+	}
+	table := services.NewPolicyTable(routePolicies, services.Authenticated, nil)
+	err = table.CheckCoverage(generatedRoutes(files))
+	if err == nil || err.Error() != "routes without an authorization policy: POST /post/unlisted" {
+		t.Errorf("got %v, want POST /post/unlisted reported", err)
+	}
+}
+
+// serviceFile describes a service whose methods, in sorted order, carry the
+// given HTTP rules. A nil rule leaves the method without a binding.
+func serviceFile(rules map[string]*annotations.HttpRule) *descriptorpb.FileDescriptorProto {
+	service := &descriptorpb.ServiceDescriptorProto{Name: proto.String("PostService")}
+	for _, name := range []string{"Get", "Post"} {
+		rule, ok := rules[name]
+		if !ok {
+			continue
+		}
+		options := &descriptorpb.MethodOptions{}
+		if rule != nil {
+			proto.SetExtension(options, annotations.E_Http, rule)
+		}
+		service.Method = append(service.Method, &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(name),
+			InputType:  proto.String(".test.Message"),
+			OutputType: proto.String(".test.Message"),
+			Options:    options,
+		})
+	}
+	return &descriptorpb.FileDescriptorProto{
+		Name:        proto.String("test/post.proto"),
+		Package:     proto.String("test"),
+		Syntax:      proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Message")}},
+		Service:     []*descriptorpb.ServiceDescriptorProto{service},
+	}
+}
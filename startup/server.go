@@ -2,78 +2,282 @@ package startup
 
 import (
 	"api-gateway/infrastructure/api"
+	"api-gateway/infrastructure/services"
 	cfg "api-gateway/startup/config"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	tracer "github.com/XWS-DISLINKT/dislinkt/tracer"
 	"github.com/gorilla/handlers"
 	"github.com/opentracing/opentracing-go"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	connectionsGw "github.com/XWS-DISLINKT/dislinkt/common/proto/connection-service"
 	postGw "github.com/XWS-DISLINKT/dislinkt/common/proto/post-service"
 	profileGw "github.com/XWS-DISLINKT/dislinkt/common/proto/profile-service"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 type Server struct {
-	config           *cfg.Config
-	mux              *runtime.ServeMux
-	postTracer       opentracing.Tracer
-	postCloser       io.Closer
-	profileTracer    opentracing.Tracer
-	profileCloser    io.Closer
-	connectionTracer opentracing.Tracer
-	connectionCloser io.Closer
-	authTracer       opentracing.Tracer
-	authCloser       io.Closer
-	allRequests      prometheus.Counter
-	okRequests       prometheus.Counter
-	badRequests      prometheus.Counter
+	config        *cfg.Config
+	mux           *runtime.ServeMux
+	clients       *services.Clients
+	discovery     *services.Discovery
+	verifier      *services.TokenVerifier
+	revocations   services.RevocationStore
+	authenticator *services.Authenticator
+	csrf          *services.CSRFProtector
+	policies      *services.PolicyTable
+	rateLimiter   *services.RateLimiter
+	accessLog     *services.AccessLog
+	deadlines     *services.Deadlines
+	authBreaker   *services.CircuitBreaker
+	authTLS       *tls.Config
+	tracer        opentracing.Tracer
+	otel          *services.OtelTracing
+	tracerCloser  io.Closer
+	otlpMetrics   *services.OTLPMetricsExporter
+	registry      *prometheus.Registry
+	httpMetrics   services.HTTPMetrics
+	health        *api.HealthHandler
+	ready         int32
 }
 
 func NewServer(config *cfg.Config) *Server {
-	postTracer, postCloser := tracer.Init("post_service")
-	opentracing.SetGlobalTracer(postTracer)
-	profileTracer, profileCloser := tracer.Init("profile_service")
-	opentracing.SetGlobalTracer(profileTracer)
-	connectionTracer, connectionCloser := tracer.Init("connection_service")
-	opentracing.SetGlobalTracer(connectionTracer)
-	authTracer, authCloser := tracer.Init("auth_service")
-	opentracing.SetGlobalTracer(authTracer)
-
-	allRequests := promauto.NewCounter(prometheus.CounterOpts{
-		Name: "http_request_total",
-		Help: "The total number of http requests",
+	if err := checkAdminExposure(config); err != nil {
+		log.Fatal(err)
+	}
+	gatewayTracer, otelTracing, tracerCloser, err := newTracing(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opentracing.SetGlobalTracer(gatewayTracer)
+
+	// Metrics live in the gateway's own registry, served only by the admin
+	// listener.
+	registry := newRegistry()
+	factory := promauto.With(registry)
+	httpMetrics := newHTTPMetrics(factory)
+	var otlpMetrics *services.OTLPMetricsExporter
+	if config.OTLPMetricsInterval > 0 {
+		otlpMetrics, err = services.NewOTLPMetricsExporter(otlpConfig(config), "api_gateway", registry, config.OTLPMetricsInterval)
+		if err != nil {
+			log.Fatal(err)
+		}
+		otlpMetrics.Start()
+	}
+	breakerConfig := services.BreakerConfig{
+		FailureThreshold: config.BreakerFailureThreshold,
+		OpenTimeout:      config.BreakerOpenTimeout,
+		HalfOpenRequests: config.BreakerHalfOpenRequests,
+	}
+	breakerState := factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_circuit_breaker_state",
+		Help: "State of the circuit breaker of each backend: 0 closed, 1 open, 2 half-open",
+	}, []string{"service"})
+	discoveryProvider, err := newDiscoveryProvider(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	addresses, err := backendAddresses(config, discoveryProvider)
+	if err != nil {
+		log.Fatal(err)
+	}
+	clients, err := services.NewClients(services.ClientsConfig{
+		ProfileAddresses:    addresses["profile"],
+		PostAddresses:       addresses["post"],
+		ConnectionAddresses: addresses["connection"],
+		ProfileTLS:          clientTLS(config, "profile"),
+		PostTLS:             clientTLS(config, "post"),
+		ConnectionTLS:       clientTLS(config, "connection"),
+		Balancer:            config.LoadBalancing,
+		Outlier: services.OutlierConfig{
+			ConsecutiveFailures: config.OutlierConsecutiveFailures,
+			BaseEjectionTime:    config.OutlierBaseEjectionTime,
+			MaxEjectionTime:     config.OutlierMaxEjectionTime,
+			MaxEjectionPercent:  config.OutlierMaxEjectionPercent,
+		},
+		DNSRefresh: config.DNSRefreshInterval,
+		Retry: services.RetryPolicy{
+			MaxAttempts:    config.RetryMaxAttempts,
+			InitialBackoff: config.RetryInitialBackoff,
+			MaxBackoff:     config.RetryMaxBackoff,
+			Multiplier:     config.RetryMultiplier,
+			Jitter:         config.RetryJitter,
+			Methods:        config.RetryMethods,
+			BudgetTokens:   config.RetryBudgetTokens,
+			BudgetRatio:    config.RetryBudgetRatio,
+		},
+		Breaker:      breakerConfig,
+		BreakerState: breakerState,
+		RetryMetrics: services.RetryMetrics{
+			Retries: factory.NewCounterVec(prometheus.CounterOpts{
+				Name: "grpc_client_retry_total",
+				Help: "The total number of retried gRPC calls",
+			}, []string{"service", "method"}),
+			BudgetExhausted: factory.NewCounterVec(prometheus.CounterOpts{
+				Name: "grpc_client_retry_budget_exhausted_total",
+				Help: "The total number of gRPC retries skipped because the retry budget was spent",
+			}, []string{"service", "method"}),
+		},
+		Tracer: gatewayTracer,
+		Otel:   otelTracing,
+		Metrics: services.ClientMetrics{
+			Handled: factory.NewCounterVec(prometheus.CounterOpts{
+				Name: "grpc_client_handled_total",
+				Help: "The total number of gRPC call attempts to backends by result code",
+			}, []string{"service", "method", "code"}),
+			Duration: factory.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "grpc_client_handling_seconds",
+				Help:    "Latency of gRPC call attempts to backends",
+				Buckets: prometheus.DefBuckets,
+			}, []string{"service", "method"}),
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	var discovery *services.Discovery
+	if discoveryProvider != nil {
+		discovery = services.NewDiscovery(discoveryProvider, config.DiscoveryInterval, map[string]*services.Pool{
+			"profile":    clients.ProfilePool,
+			"post":       clients.PostPool,
+			"connection": clients.ConnectionPool,
+		})
+		if err := discovery.Start(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	authTLS, err := clientTLS(config, "auth").Config()
+	if err != nil {
+		log.Fatalf("auth service TLS: %v", err)
+	}
+
+	verifier, err := services.NewTokenVerifier(services.VerifierConfig{
+		Algorithms:    config.JWTAlgorithms,
+		Secret:        []byte(config.JWTSecret),
+		PublicKeyFile: config.JWTPublicKeyFile,
+		JWKSSource:    config.JWKSSource,
+		JWKSRefresh:   config.JWKSRefreshInterval,
+		Issuer:        config.JWTIssuer,
+		Audience:      config.JWTAudience,
+		ClockSkew:     config.JWTClockSkew,
 	})
-	okRequests := promauto.NewCounter(prometheus.CounterOpts{
-		Name: "http_ok_request_total",
-		Help: "The total number of ok http requests",
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Without CSRF checks there is no use for tokens, nor for their secret.
+	var csrf *services.CSRFProtector
+	var csrfCheck services.CSRFCheck
+	if config.CSRFEnabled || config.AuthCookieRequiresCSRF {
+		csrf, err = services.NewCSRFProtector(services.CSRFConfig{
+			Secret:         []byte(config.CSRFSecret),
+			CookieName:     config.CSRFCookieName,
+			HeaderName:     config.CSRFHeaderName,
+			TokenSources:   config.AuthTokenSources,
+			AuthCookieName: config.AuthCookieName,
+			ExemptPaths:    config.CSRFExemptPaths,
+			CookieSecure:   config.CSRFCookieSecure,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		csrfCheck = csrf.Valid
+	}
+
+	revocations, err := newRevocationStore(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authenticator, err := services.NewAuthenticator(verifier, services.AuthenticatorConfig{
+		TokenSources:       config.AuthTokenSources,
+		CookieName:         config.AuthCookieName,
+		CookieRequiresCSRF: config.AuthCookieRequiresCSRF,
+		CSRFCheck:          csrfCheck,
+		Realm:              "api-gateway",
+		Revocations:        revocations,
 	})
-	badRequests := promauto.NewCounter(prometheus.CounterOpts{
-		Name: "http_bad_request_total",
-		Help: "The total number of bad http requests",
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rateLimiter, err := newRateLimiter(config, factory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	accessLog, err := services.NewAccessLog(services.AccessLogConfig{
+		Level:             config.LogLevel,
+		SuccessSampleRate: config.LogSuccessSampleRate,
+		Output:            os.Stdout,
+		ClientIP:          rateLimiter.ClientIP,
 	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	timeouts := make([]services.RouteTimeout, 0, len(config.RouteTimeouts))
+	for _, value := range config.RouteTimeouts {
+		timeout, err := services.ParseRouteTimeout(value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		timeouts = append(timeouts, timeout)
+	}
+
+	mux := runtime.NewServeMux(
+		runtime.WithErrorHandler(services.GatewayErrorHandler),
+		runtime.WithIncomingHeaderMatcher(services.IncomingHeaderMatcher),
+		runtime.WithMetadata(func(ctx context.Context, r *http.Request) metadata.MD {
+			if pattern, ok := runtime.HTTPPathPattern(ctx); ok {
+				services.SetRoute(ctx, pattern)
+			}
+			return services.OutgoingMetadata(r)
+		}),
+	)
+
+	defaultPolicy := services.Authenticated
+	if config.AuthzDefaultPolicy == "public" {
+		defaultPolicy = services.Public
+	}
+
 	server := &Server{
-		config:           config,
-		mux:              runtime.NewServeMux(),
-		postTracer:       postTracer,
-		postCloser:       postCloser,
-		profileTracer:    profileTracer,
-		profileCloser:    profileCloser,
-		connectionTracer: connectionTracer,
-		connectionCloser: connectionCloser,
-		authTracer:       authTracer,
-		authCloser:       authCloser,
-		allRequests:      allRequests,
-		okRequests:       okRequests,
-		badRequests:      badRequests,
+		config:        config,
+		mux:           mux,
+		clients:       clients,
+		discovery:     discovery,
+		verifier:      verifier,
+		revocations:   revocations,
+		authenticator: authenticator,
+		csrf:          csrf,
+		policies:      services.NewPolicyTable(routePolicies, defaultPolicy, authenticator),
+		rateLimiter:   rateLimiter,
+		accessLog:     accessLog,
+		deadlines:     services.NewDeadlines(timeouts, config.DefaultRouteTimeout),
+		authBreaker:   services.NewCircuitBreaker("auth", breakerConfig, breakerState),
+		authTLS:       authTLS,
+		tracer:        gatewayTracer,
+		otel:          otelTracing,
+		tracerCloser:  tracerCloser,
+		otlpMetrics:   otlpMetrics,
+		registry:      registry,
+		httpMetrics:   httpMetrics,
 	}
 	server.initHandlers()
 	server.initCustomHandlers()
@@ -81,23 +285,19 @@ func NewServer(config *cfg.Config) *Server {
 }
 
 func (server *Server) initHandlers() {
-	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
-	postEndpoint := fmt.Sprintf("%s:%s", server.config.PostHost, server.config.PostPort)
-	err := postGw.RegisterPostServiceHandlerFromEndpoint(context.TODO(), server.mux, postEndpoint, opts)
+	err := postGw.RegisterPostServiceHandlerClient(context.TODO(), server.mux, server.clients.Post)
 
 	if err != nil {
 		panic(err)
 	}
 
-	profileEndpoint := fmt.Sprintf("%s:%s", server.config.ProfileHost, server.config.ProfilePort)
-	err = profileGw.RegisterProfileServiceHandlerFromEndpoint(context.TODO(), server.mux, profileEndpoint, opts)
+	err = profileGw.RegisterProfileServiceHandlerClient(context.TODO(), server.mux, server.clients.Profile)
 
 	if err != nil {
 		panic(err)
 	}
 
-	connectionsEndpoint := fmt.Sprintf("%s:%s", server.config.ConnectionHost, server.config.ConnectionPort)
-	err = connectionsGw.RegisterConnectionServiceHandlerFromEndpoint(context.TODO(), server.mux, connectionsEndpoint, opts)
+	err = connectionsGw.RegisterConnectionServiceHandlerClient(context.TODO(), server.mux, server.clients.Connection)
 
 	if err != nil {
 		panic(err)
@@ -105,27 +305,350 @@ func (server *Server) initHandlers() {
 }
 
 func (server *Server) initCustomHandlers() {
-	profileEndpoint := fmt.Sprintf("%s:%s", server.config.ProfileHost, server.config.ProfilePort)
-	profileHandler := api.NewProfileHandler(profileEndpoint, server.profileTracer, server.allRequests, server.okRequests, server.badRequests)
-	profileHandler.Init(server.mux)
-	postEndpoint := fmt.Sprintf("%s:%s", server.config.PostHost, server.config.PostPort)
-	postHandler := api.NewPostHandler(postEndpoint, server.postTracer, server.allRequests, server.okRequests, server.badRequests)
-	postHandler.Init(server.mux)
+	server.health = api.NewHealthHandler(server.dependencies(), server.config.ReadinessTimeout, server.isReady)
+	authEndpoint := fmt.Sprintf("%s:%s", server.config.AuthHost, server.config.AuthPort)
+	customHandlers := []api.Handler{
+		api.NewProfileHandler(server.clients.Profile, server.tracer),
+		api.NewPostHandler(server.clients.Post, server.tracer),
+		api.NewAuthHandler(authEndpoint, api.AuthProxyConfig{
+			Cookies: api.CookieRewrite{
+				Domain: server.config.AuthProxyCookieDomain,
+				Path:   server.config.AuthProxyCookiePath,
+				Secure: server.config.AuthProxyCookieSecure,
+			},
+			Timeout: server.config.AuthProxyTimeout,
+			Breaker: server.authBreaker,
+			TLS:     server.authTLS,
+		}, server.revocations, server.config.RevocationMaxTokenLifetime, server.tracer),
+		api.NewConnectionsHandler(server.clients.Connection, server.tracer),
+		api.NewRevocationHandler(server.revocations, server.config.RevocationMaxTokenLifetime),
+		server.health,
+	}
+	if server.csrf != nil {
+		customHandlers = append(customHandlers, api.NewCSRFHandler(server.csrf))
+	}
+
+	routes := generatedRoutes(protoregistry.GlobalFiles)
+	for _, handler := range customHandlers {
+		handler.Init(server.mux)
+		for _, route := range handler.Routes() {
+			routes = append(routes, services.RoutePolicy{Method: route.Method, Pattern: route.Pattern})
+		}
+	}
+	if err := server.policies.CheckCoverage(routes); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (server *Server) dependencies() []api.Dependency {
 	authEndpoint := fmt.Sprintf("%s:%s", server.config.AuthHost, server.config.AuthPort)
-	authHandler := api.NewAuthHandler(authEndpoint, profileEndpoint, server.authTracer, server.allRequests, server.okRequests, server.badRequests)
-	authHandler.Init(server.mux)
-	connectionEndpoint := fmt.Sprintf("%s:%s", server.config.ConnectionHost, server.config.ConnectionPort)
-	connectionsHandler := api.NewConnectionsHandler(connectionEndpoint, server.connectionTracer, server.allRequests, server.okRequests, server.badRequests)
-	connectionsHandler.Init(server.mux)
+	return []api.Dependency{
+		{Name: "profile", Optional: server.config.IsOptional("profile"), Replicas: server.clients.ProfilePool.Probe},
+		{Name: "post", Optional: server.config.IsOptional("post"), Replicas: server.clients.PostPool.Probe},
+		{Name: "connection", Optional: server.config.IsOptional("connection"), Replicas: server.clients.ConnectionPool.Probe},
+		{Name: "auth", Optional: server.config.IsOptional("auth"), Check: services.TCPHealthCheck(authEndpoint)},
+	}
 }
 
 func (server *Server) Start() {
 	cors := handlers.CORS(
-		handlers.AllowedOrigins([]string{"http://localhost:4200", "http://localhost:4200/**"}),
+		handlers.AllowedOrigins(server.config.AllowedOrigins),
 		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-		handlers.AllowedHeaders([]string{"Authorization", "Accept", "Accept-Language", "Content-Type", "Content-Language", "Origin", "Access-Control-Allow-Origin", "*"}),
+		handlers.AllowedHeaders([]string{"Authorization", "Accept", "Accept-Language", "Content-Type", "Content-Language", "Origin", "Access-Control-Allow-Origin", server.config.CSRFHeaderName, "*"}),
 		handlers.AllowCredentials(),
 	)
 
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", server.config.Port), cors(server.mux)))
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	httpServer := &http.Server{
+		Addr:    fmt.Sprintf(":%s", server.config.Port),
+		Handler: server.httpMetrics.Middleware(services.TracingMiddleware(server.tracer, cors(server.handler()))),
+	}
+	if server.otel != nil {
+		httpServer.Handler = server.httpMetrics.Middleware(server.otel.Middleware(cors(server.handler())))
+	}
+	if server.config.AccessLogEnabled {
+		// Outside CORS so preflight requests are logged as well.
+		httpServer.Handler = server.accessLog.Middleware(httpServer.Handler)
+	}
+	httpServer.Handler = services.RequestId(httpServer.Handler)
+	tlsEnabled := server.config.TLSCertFile != ""
+	if tlsEnabled {
+		reloader, err := services.NewCertificateReloader(server.config.TLSCertFile, server.config.TLSKeyFile, server.config.TLSReloadInterval)
+		if err != nil {
+			log.Fatal(err)
+		}
+		httpServer.TLSConfig = services.ServerTLSConfig(reloader, server.config.HTTP2Enabled)
+		if !server.config.HTTP2Enabled {
+			// A non-nil map keeps net/http from configuring HTTP/2 itself.
+			httpServer.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		}
+	}
+	// Both ports are bound before serving so a taken port fails startup
+	// instead of a gateway that reports ready without listening.
+	listener, err := net.Listen("tcp", httpServer.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	serveErr := make(chan error, 2)
+	var adminServer *http.Server
+	if server.config.AdminPort != "" {
+		adminServer = &http.Server{
+			Addr:    net.JoinHostPort(server.config.AdminHost, server.config.AdminPort),
+			Handler: server.adminHandler(),
+		}
+		adminListener, err := net.Listen("tcp", adminServer.Addr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			serveErr <- adminServer.Serve(adminListener)
+		}()
+	}
+	go func() {
+		if tlsEnabled {
+			// The certificate comes from TLSConfig.GetCertificate.
+			serveErr <- httpServer.ServeTLS(listener, "", "")
+			return
+		}
+		serveErr <- httpServer.Serve(listener)
+	}()
+	atomic.StoreInt32(&server.ready, 1)
+
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		// A second signal falls through to the default handler and kills the
+		// process without waiting for the drain.
+		stop()
+		log.Println("shutdown signal received, draining connections")
+	}
+	server.shutdown(httpServer, adminServer)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
+
+// handler wraps the mux in the middleware that applies to every route.
+func (server *Server) handler() http.Handler {
+	var handler http.Handler = server.mux
+	handler = server.deadlines.Middleware(handler)
+	if server.config.RateLimitEnabled {
+		// Inside the policy check so limits keyed by user see the principal.
+		handler = server.rateLimiter.Middleware(handler)
+	}
+	handler = server.policies.Middleware(handler)
+	if server.config.CSRFEnabled {
+		handler = server.csrf.Middleware(handler)
+	}
+	return services.Recover(handler)
+}
+
+// shutdown reports not-ready, waits for the orchestrator to stop routing
+// traffic, drains in-flight requests and then releases tracers and backend
+// connections. The admin listener stays up until the drain is over so the
+// drain can be watched.
+func (server *Server) shutdown(httpServer *http.Server, adminServer *http.Server) {
+	atomic.StoreInt32(&server.ready, 0)
+	time.Sleep(server.config.ShutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), server.config.ShutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Printf("http server did not drain in time: %v", err)
+	}
+	if adminServer != nil {
+		adminServer.Close()
+	}
+
+	if err := server.tracerCloser.Close(); err != nil {
+		log.Printf("failed to close tracer: %v", err)
+	}
+	if server.otlpMetrics != nil {
+		if err := server.otlpMetrics.Close(); err != nil {
+			log.Printf("failed to export metrics: %v", err)
+		}
+	}
+
+	if server.discovery != nil {
+		server.discovery.Close()
+	}
+	if err := server.clients.Close(); err != nil {
+		log.Printf("failed to close gRPC connections: %v", err)
+	}
+	server.verifier.Close()
+	if err := server.revocations.Close(); err != nil {
+		log.Printf("failed to close revocation store: %v", err)
+	}
+}
+
+func clientTLS(config *cfg.Config, backend string) services.ClientTLS {
+	backendTLS := config.BackendTLS(backend)
+	return services.ClientTLS{
+		Enabled:    backendTLS.Enabled,
+		CAFile:     backendTLS.CAFile,
+		CertFile:   backendTLS.CertFile,
+		KeyFile:    backendTLS.KeyFile,
+		ServerName: backendTLS.ServerName,
+		Reload:     config.TLSReloadInterval,
+	}
+}
+
+// newTracing sets up the configured tracing pipeline. With otel the
+// OpenTracing tracer handed to handlers is a no-op; the auth proxy traces
+// through the global OpenTelemetry provider instead.
+func newTracing(config *cfg.Config) (opentracing.Tracer, *services.OtelTracing, io.Closer, error) {
+	if config.TracingProvider != "otel" {
+		gatewayTracer, closer := tracer.Init("api_gateway")
+		return gatewayTracer, nil, closer, nil
+	}
+	exporter, err := services.NewOTLPTraceExporter(otlpConfig(config))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	otelTracing, err := services.NewOtelTracing(services.OtelConfig{
+		ServiceName:  "api_gateway",
+		Exporter:     exporter,
+		Sampler:      config.TracingSampler,
+		SampleRatio:  config.TracingSampleRatio,
+		SampleErrors: config.TracingSampleErrors,
+	})
+	if err != nil {
+		exporter.Shutdown(context.Background())
+		return nil, nil, nil, err
+	}
+	otelTracing.Register()
+	return opentracing.NoopTracer{}, otelTracing, otelTracing, nil
+}
+
+func otlpConfig(config *cfg.Config) services.OTLPConfig {
+	return services.OTLPConfig{
+		Protocol: config.OTLPProtocol,
+		Endpoint: config.OTLPEndpoint,
+		Headers:  config.OTLPHeaders,
+		TLS:      services.ClientTLS{Enabled: config.OTLPTLSEnabled, CAFile: config.OTLPTLSCAFile},
+		Timeout:  config.OTLPTimeout,
+	}
+}
+
+// newDiscoveryProvider returns nil for static backend addresses.
+func newDiscoveryProvider(config *cfg.Config) (services.DiscoveryProvider, error) {
+	switch config.DiscoveryProvider {
+	case "file":
+		if config.DiscoveryFile == "" {
+			return nil, errors.New("discoveryFile is required by the file discovery provider")
+		}
+		return services.FileDiscovery{Path: config.DiscoveryFile}, nil
+	case "srv":
+		if len(config.DiscoverySRVNames) == 0 {
+			return nil, errors.New("discoverySrvNames is required by the srv discovery provider")
+		}
+		return services.SRVDiscovery{Names: config.DiscoverySRVNames}, nil
+	}
+	return nil, nil
+}
+
+// backendAddresses starts from the configured addresses and replaces those
+// the discovery provider knows about.
+func backendAddresses(config *cfg.Config, provider services.DiscoveryProvider) (services.Endpoints, error) {
+	addresses := services.Endpoints{}
+	for _, backend := range []string{"profile", "post", "connection"} {
+		addresses[backend] = config.BackendAddresses(backend)
+	}
+	if provider == nil {
+		return addresses, nil
+	}
+	endpoints, err := provider.Endpoints(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("service discovery: %w", err)
+	}
+	for backend := range addresses {
+		if len(endpoints[backend]) > 0 {
+			addresses[backend] = endpoints[backend]
+		}
+	}
+	return addresses, nil
+}
+
+func newRevocationStore(config *cfg.Config) (services.RevocationStore, error) {
+	if config.RevocationStore == "redis" {
+		return services.NewRedisRevocationStore(services.RedisConfig{
+			Address:   config.RedisAddress,
+			Password:  config.RedisPassword,
+			Database:  config.RedisDatabase,
+			Timeout:   config.RedisTimeout,
+			KeyPrefix: "gateway:revoked:",
+		})
+	}
+	return services.NewMemoryRevocationStore(), nil
+}
+
+// newHTTPMetrics also keeps the unlabelled http_request_total,
+// http_ok_request_total and http_bad_request_total counters that existing
+// dashboards read.
+func newHTTPMetrics(factory promauto.Factory) services.HTTPMetrics {
+	sizeBuckets := prometheus.ExponentialBuckets(128, 4, 8)
+	return services.HTTPMetrics{
+		Requests: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_server_requests_total",
+			Help: "The total number of http requests by route, method and status class",
+		}, []string{"route", "method", "status"}),
+		Duration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_server_request_duration_seconds",
+			Help:    "Latency of http requests by route, method and status class",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		InFlight: factory.NewGauge(prometheus.GaugeOpts{
+			Name: "http_server_requests_in_flight",
+			Help: "The number of http requests being served",
+		}),
+		RequestSize: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_server_request_size_bytes",
+			Help:    "Size of http request bodies",
+			Buckets: sizeBuckets,
+		}, []string{"route", "method"}),
+		ResponseSize: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_server_response_size_bytes",
+			Help:    "Size of http response bodies",
+			Buckets: sizeBuckets,
+		}, []string{"route", "method"}),
+		All: factory.NewCounter(prometheus.CounterOpts{
+			Name: "http_request_total",
+			Help: "The total number of http requests",
+		}),
+		Ok: factory.NewCounter(prometheus.CounterOpts{
+			Name: "http_ok_request_total",
+			Help: "The total number of ok http requests",
+		}),
+		Bad: factory.NewCounter(prometheus.CounterOpts{
+			Name: "http_bad_request_total",
+			Help: "The total number of bad http requests",
+		}),
+	}
+}
+
+func newRateLimiter(config *cfg.Config, factory promauto.Factory) (*services.RateLimiter, error) {
+	limits := make([]services.RateLimit, 0, len(config.RateLimits))
+	for _, value := range config.RateLimits {
+		limit, err := services.ParseRateLimit(value)
+		if err != nil {
+			return nil, err
+		}
+		limits = append(limits, limit)
+	}
+	rejected := factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_rate_limited_request_total",
+		Help: "The total number of http requests rejected by rate limits",
+	}, []string{"route", "key"})
+	return services.NewRateLimiter(services.RateLimiterConfig{
+		Limits:         limits,
+		TrustedProxies: config.RateLimitTrustedProxies,
+		APIKeyHeader:   config.RateLimitAPIKeyHeader,
+		APIKeys:        config.RateLimitAPIKeys,
+	}, rejected)
+}
+
+func (server *Server) isReady() bool {
+	return atomic.LoadInt32(&server.ready) == 1
 }
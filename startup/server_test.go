@@ -0,0 +1,39 @@
+package startup
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLegacyHTTPCounters(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := newHTTPMetrics(promauto.With(registry))
+	for _, status := range []int{http.StatusOK, http.StatusNoContent, http.StatusNotFound, http.StatusInternalServerError, http.StatusOK} {
+		status := status
+		handler := metrics.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/post", nil))
+	}
+
+	want := `
+# HELP http_bad_request_total The total number of bad http requests
+# TYPE http_bad_request_total counter
+http_bad_request_total 2
+# HELP http_ok_request_total The total number of ok http requests
+# TYPE http_ok_request_total counter
+http_ok_request_total 3
+# HELP http_request_total The total number of http requests
+# TYPE http_request_total counter
+http_request_total 5
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want), "http_request_total", "http_ok_request_total", "http_bad_request_total"); err != nil {
+		t.Error(err)
+	}
+}